@@ -2,36 +2,50 @@ package main
 
 import (
 	"api/authentication"
-	"api/cron"
-	"api/dao"
-	"api/handler"
-	"api/logging"
+	"api/config"
+	"api/problem"
+	"api/timeout"
 	"api/tracing"
-	"io"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func init() {
-	f, err := os.OpenFile(logging.GinLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	gin.DefaultWriter = io.MultiWriter(f, os.Stdout)
-}
+// Router routes the API to the handlers of the server
+func (s *Server) Router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.LoggerWithWriter(s.requestLog), gin.Recovery())
 
-var (
-	C = &handler.CustomerHandler{}
-)
+	r.Use(problem.Middleware())
+	r.Use(tracing.XRequestIDMiddleware(s.Log))
 
-func SetupRouter() *gin.Engine {
-	r := gin.Default()
+	// Every request is bounded by the timeout of its route: the bulk ones work on many customers at once
+	bulk := s.Config.Server.BulkTimeout
+	r.Use(timeout.Middleware(s.Config.Server.RequestTimeout, map[string]time.Duration{
+		timeout.Route(http.MethodPost, "/api/clients/batch"):             bulk,
+		timeout.Route(http.MethodPost, "/api/clients/send"):              bulk,
+		timeout.Route(http.MethodPost, "/api/mailings/:id/send"):         bulk,
+		timeout.Route(http.MethodPost, "/api/mailings/:id/members"):      bulk,
+		timeout.Route(http.MethodPost, "/api/mailings/:id/dead-letters"): bulk,
+		timeout.Route(http.MethodPost, "/admin/purge"):                   bulk,
+	}))
 
-	r.Use(authentication.HeaderAuthMiddleware())
-	r.Use(tracing.XRequestIDMiddleware())
+	// Unsubscribe links are followed by mail recipients, who have no token: they are registered before
+	// the authentication middleware, which only applies to the routes registered after it
+	r.GET("/unsubscribe/:token", s.Unsubscribe.GetUnsubscribe)
+	r.POST("/unsubscribe/:token", s.Unsubscribe.Unsubscribe)
+
+	// Probes have no token either
+	r.GET("/ready", s.Health.Ready)
+
+	r.Use(authentication.HeaderAuthMiddleware(s.Config.Auth.Token))
 
 	// Ping test
 	r.GET("/ping", func(c *gin.Context) {
@@ -39,30 +53,134 @@ func SetupRouter() *gin.Engine {
 	})
 
 	// create a client entry
-	r.POST("/api/clients", C.CreateCustomer)
+	r.POST("/api/clients", s.Customers.CreateCustomer)
+
+	// create several client entries at once
+	r.POST("/api/clients/batch", s.Customers.CreateCustomers)
 
 	// Get client by id
-	r.GET("/api/clients/:id", C.GetCustomer)
+	r.GET("/api/clients/:id", s.Customers.GetCustomer)
 
 	// Delete client by id
-	r.DELETE("/api/clients/:id", C.DeleteCustomer)
+	r.DELETE("/api/clients/:id", s.Customers.DeleteCustomer)
+
+	// Replace client by id
+	r.PUT("/api/clients/:id", s.Customers.ReplaceCustomer)
+
+	// Patch client by id
+	r.PATCH("/api/clients/:id", s.Customers.PatchCustomer)
+
+	// Restore a deleted client by id
+	r.POST("/api/clients/:id/restore", s.Customers.RestoreCustomer)
+
+	// Messages sent, or meant to be sent, to a client, even a deleted one
+	r.GET("/api/clients/:id/messages", s.Customers.FindMessages)
 
 	// Get all clients
-	r.GET("/api/clients", C.FindCustomers)
+	r.GET("/api/clients", s.Customers.FindCustomers)
+
+	// Send a mailing to its members
+	r.POST("/api/clients/send", s.Customers.MailClients)
+
+	// Mailings
+	r.POST("/api/mailings", s.Mailings.CreateMailing)
+	r.GET("/api/mailings", s.Mailings.FindMailings)
+	r.GET("/api/mailings/:id", s.Mailings.GetMailing)
+	r.PUT("/api/mailings/:id", s.Mailings.UpdateMailing)
+	r.DELETE("/api/mailings/:id", s.Mailings.DeleteMailing)
+	r.POST("/api/mailings/:id/send", s.Mailings.SendMailing)
+	r.POST("/api/mailings/:id/cancel", s.Mailings.CancelMailing)
+
+	// Send a mailing later on, or not
+	r.POST("/api/mailings/:id/schedule", s.Mailings.ScheduleMailing)
+	r.DELETE("/api/mailings/:id/schedule", s.Mailings.UnscheduleMailing)
+
+	// Preview the messages of a mailing, without sending them
+	r.GET("/api/mailings/:id/preview", s.Mailings.PreviewMailing)
+
+	// Customers a mailing is sent to
+	r.GET("/api/mailings/:id/members", s.Mailings.FindMembers)
+	r.POST("/api/mailings/:id/members", s.Mailings.AddMembers)
+	r.DELETE("/api/mailings/:id/members/:customer_id", s.Mailings.RemoveMember)
 
-	// Send mail to all clients with the same mailing_id
-	r.POST("/api/clients/send", C.MailClients)
+	// How far a mailing got, message by message
+	r.GET("/api/mailings/:id/status", s.Mailings.GetMailingStatus)
+
+	// Messages given up on after failing every attempt
+	r.GET("/api/mailings/:id/dead-letters", s.Mailings.FindDeadLetters)
+	r.POST("/api/mailings/:id/dead-letters", s.Mailings.RequeueDeadLetters)
+
+	// Email addresses that are never mailed
+	r.POST("/api/suppressions", s.Suppressions.CreateSuppression)
+	r.GET("/api/suppressions", s.Suppressions.FindSuppressions)
+	r.GET("/api/suppressions/:email", s.Suppressions.GetSuppression)
+	r.DELETE("/api/suppressions/:email", s.Suppressions.DeleteSuppression)
+
+	// Webhook subscriptions, and the log of what was posted to them
+	r.POST("/api/webhooks", s.Webhooks.CreateWebhook)
+	r.GET("/api/webhooks", s.Webhooks.FindWebhooks)
+	r.GET("/api/webhooks/:id", s.Webhooks.GetWebhook)
+	r.PUT("/api/webhooks/:id", s.Webhooks.UpdateWebhook)
+	r.DELETE("/api/webhooks/:id", s.Webhooks.DeleteWebhook)
+	r.GET("/api/webhooks/:id/deliveries", s.Webhooks.FindDeliveries)
+
+	// Maintenance
+	r.POST("/admin/purge", s.Admin.PurgeCustomers)
+	r.GET("/admin/leader", s.Admin.GetLeader)
+
+	// Cron jobs, and the history of their runs
+	r.GET("/admin/jobs", s.Admin.FindJobs)
+	r.GET("/admin/jobs/:tag", s.Admin.GetJob)
+	r.POST("/admin/jobs/:tag/run", s.Admin.RunJob)
+	r.POST("/admin/jobs/:tag/pause", s.Admin.PauseJob)
+	r.POST("/admin/jobs/:tag/resume", s.Admin.ResumeJob)
+	r.PUT("/admin/jobs/:tag/interval", s.Admin.SetJobInterval)
+	r.GET("/admin/jobs/:tag/runs", s.Admin.FindJobRuns)
 
 	return r
 }
 
 func main() {
-	if err := dao.DAO.MigrateModels(); err != nil {
-		panic(err)
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	printConfig := flags.Bool("print-config", false, "print the configuration, secrets redacted, and exit")
+	cfg, err := config.Load(flags, os.Args[1:])
+	if err != nil {
+		log.Fatalf("configuration: %s", err.Error())
+	}
+	if *printConfig {
+		fmt.Print(cfg)
+		return
 	}
-	if _, err := cron.Scheduler(); err != nil {
-		panic(err)
+	if flags.Arg(0) == "migrate" {
+		if err := runMigrate(cfg, flags.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("migrate: %s", err.Error())
+		}
+		return
+	}
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Log.Info.Printf("configuration:\n%s", cfg)
+	if cfg.Auth.Token == authentication.DefaultToken {
+		s.Log.Warn.Printf("AUTH_TOKEN is not set, the default token only suits local development")
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-served:
+		s.Log.Error.Printf("SHUTDOWN: %s", err.Error())
+	case sig := <-quit:
+		s.Log.Info.Printf("SHUTDOWN: %s received", sig)
+	}
+	s.Shutdown()
+	if err != nil {
+		log.Fatal(err)
 	}
-	// Listen and serve in 0.0.0.0:8080
-	_ = SetupRouter().Run(":8080")
 }
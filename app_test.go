@@ -2,31 +2,54 @@ package main
 
 import (
 	"api/authentication"
+	"api/config"
+	"api/cron"
 	"api/customer"
 	"api/dao"
 	"api/handler"
+	"api/job"
+	"api/leader"
+	"api/logging"
+	"api/mailing"
+	"api/migration"
+	"api/outbox"
+	"api/problem"
+	"api/suppression"
+	"api/tracing"
+	"api/webhook"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
-	"gorm.io/gorm"
-
-	"gorm.io/gorm/logger"
-
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 var (
-	oKheaders = map[string]string{authentication.AuthTokenHeader: authentication.AuthTokenValue}
+	oKheaders = map[string]string{authentication.AuthTokenHeader: authentication.DefaultToken}
 )
 
+// testServer returns a server of the default configuration on top of store, whose unsubscribe links are signed with
+// the key "test", and which runs as "replica-a"
+func testServer(t *testing.T, store dao.Store) *Server {
+	cfg := config.Default()
+	cfg.UnsubscribeKey = "test"
+	s, err := newServer(cfg, logging.New(ioutil.Discard), ioutil.Discard, &store, nil, "replica-a")
+	require.NoError(t, err)
+	return s
+}
+
 func TestPingRoute(t *testing.T) {
 
 	tests := map[string]struct {
@@ -50,7 +73,7 @@ func TestPingRoute(t *testing.T) {
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			router := SetupRouter()
+			router := testServer(t, dao.Store{}).Router()
 			w := httptest.NewRecorder()
 
 			req, _ := http.NewRequest(http.MethodGet, "/ping", nil)
@@ -85,7 +108,7 @@ func TestGetCustomer(t *testing.T) {
 			expectedCode: http.StatusOK,
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("First", int64(1)).Return(&aCustomer, nil)
+				m.On("First", mock.Anything, int64(1)).Return(&aCustomer, nil)
 				return &m
 			}(),
 			expected: &aCustomer,
@@ -95,7 +118,7 @@ func TestGetCustomer(t *testing.T) {
 			expectedCode: http.StatusInternalServerError,
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("First", int64(1)).Return(nil, errors.New("an error"))
+				m.On("First", mock.Anything, int64(1)).Return(nil, errors.New("an error"))
 				return &m
 			}(),
 		},
@@ -104,7 +127,7 @@ func TestGetCustomer(t *testing.T) {
 			expectedCode: http.StatusNotFound,
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("First", int64(1)).Return(nil, fmt.Errorf("%w: not found", logger.ErrRecordNotFound))
+				m.On("First", mock.Anything, int64(1)).Return(nil, fmt.Errorf("%w: not found", dao.ErrNotFound))
 				return &m
 			}(),
 		},
@@ -116,8 +139,7 @@ func TestGetCustomer(t *testing.T) {
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			dao.DAO = test.m
-			router := SetupRouter()
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
 			w := httptest.NewRecorder()
 
 			req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("/api/clients/%s", test.id), nil)
@@ -131,7 +153,7 @@ func TestGetCustomer(t *testing.T) {
 
 			response := w.Body.String()
 			if test.expected == nil {
-				assert.Empty(t, response)
+				assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
 				return
 			}
 
@@ -159,7 +181,7 @@ func TestCreateCustomer(t *testing.T) {
 		"201 created": {
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Create", mock.Anything).Return(nil)
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
 				return &m
 			}(),
 			expectedCode: http.StatusCreated,
@@ -178,6 +200,19 @@ func TestCreateCustomer(t *testing.T) {
 				MailingID: 1,
 			},
 		},
+		"422 suppressed": {
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("%w: hello@example.com", dao.ErrSuppressed))
+				return &m
+			}(),
+			expectedCode: http.StatusUnprocessableEntity,
+			body: customer.Customer{
+				Email:   "hello@example.com",
+				Title:   "dev",
+				Content: "no content",
+			},
+		},
 		"400 bad request validation": {
 			body: customer.Customer{
 				Model:     gorm.Model{ID: uint(1)},
@@ -200,12 +235,12 @@ func TestCreateCustomer(t *testing.T) {
 			},
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Create", mock.Anything).Return(fmt.Errorf("an error"))
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("an error"))
 				return &m
 			}(),
 		},
-		"400 bad request pg": {
-			expectedCode: http.StatusBadRequest,
+		"409 conflict pg": {
+			expectedCode: http.StatusConflict,
 			body: customer.Customer{
 				Model:     gorm.Model{ID: uint(1)},
 				Email:     "hello@example.com",
@@ -215,15 +250,14 @@ func TestCreateCustomer(t *testing.T) {
 			},
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Create", mock.Anything).Return(fmt.Errorf("%w an error", dao.ErrPgIndex))
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("%w an error", dao.ErrConflict))
 				return &m
 			}(),
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			dao.DAO = test.m
-			router := SetupRouter()
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
 			w := httptest.NewRecorder()
 
 			bodyBytes, err := json.Marshal(test.body)
@@ -251,8 +285,7 @@ func TestDeleteByMailingID_badBody(t *testing.T) {
 	}
 	t.Run("bad request", func(t *testing.T) {
 		m := &dao.CustomerDaoMock{}
-		dao.DAO = m
-		router := SetupRouter()
+		router := testServer(t, dao.Store{Customers: m}).Router()
 		w := httptest.NewRecorder()
 
 		body := mailClientRequest{}
@@ -274,39 +307,218 @@ func TestDeleteByMailingID_badBody(t *testing.T) {
 }
 
 func TestMailClients(t *testing.T) {
+	tomorrow := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	yesterday := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
 	tests := map[string]struct {
-		m            *dao.CustomerDaoMock
+		m            *dao.MailingDaoMock
+		query        string
+		sendAt       *time.Time
 		expectedCode int
 	}{
 		"500 server error": {
-			m: func() *dao.CustomerDaoMock {
-				d := dao.CustomerDaoMock{}
-				d.On("DeleteByMailingID", mock.Anything).Return(int64(0), errors.New("an error"))
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(0), errors.New("an error"))
 				return &d
 			}(),
 			expectedCode: http.StatusInternalServerError,
 		},
-		"204 no content": {
-			m: func() *dao.CustomerDaoMock {
-				d := dao.CustomerDaoMock{}
-				d.On("DeleteByMailingID", mock.Anything).Return(int64(1), nil)
+		"409 already sent": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(0), fmt.Errorf("%w: a sent mailing cannot be sent", dao.ErrInvalidState))
 				return &d
 			}(),
-			expectedCode: http.StatusNoContent,
+			expectedCode: http.StatusConflict,
+		},
+		"404 no such mailing": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(0), &dao.Error{Kind: dao.ErrNotFound, Op: "first mailing", Err: errors.New("record not found")})
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"202 accepted": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(2), nil)
+				return &d
+			}(),
+			expectedCode: http.StatusAccepted,
+		},
+		"200 dry run": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return([]outbox.Message{{CustomerID: 1, Recipient: "a@example.com"}}, nil)
+				return &d
+			}(),
+			query:        "?dry_run=true",
+			expectedCode: http.StatusOK,
+		},
+		"400 bad dry run": {
+			m:            &dao.MailingDaoMock{},
+			query:        "?dry_run=maybe",
+			expectedCode: http.StatusBadRequest,
+		},
+		"202 scheduled": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Schedule", mock.Anything, int64(7), tomorrow).Return(nil)
+				d.On("First", mock.Anything, int64(7)).Return(&mailing.Mailing{ID: 7, Status: mailing.StatusScheduled, SendAt: &tomorrow}, nil)
+				return &d
+			}(),
+			sendAt:       &tomorrow,
+			expectedCode: http.StatusAccepted,
+		},
+		"409 scheduled already sent": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Schedule", mock.Anything, int64(7), tomorrow).Return(fmt.Errorf("%w: a sent mailing cannot be scheduled", dao.ErrInvalidState))
+				return &d
+			}(),
+			sendAt:       &tomorrow,
+			expectedCode: http.StatusConflict,
+		},
+		"400 scheduled in the past": {
+			m:            &dao.MailingDaoMock{},
+			sendAt:       &yesterday,
+			expectedCode: http.StatusBadRequest,
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			dao.DAO = test.m
-			router := SetupRouter()
+			router := testServer(t, dao.Store{Mailings: test.m}).Router()
 			w := httptest.NewRecorder()
 
-			body := handler.MailClientsRequest{}
+			body := handler.MailClientsRequest{MailingID: 7, SendAt: test.sendAt}
 			bodyBytes, err := json.Marshal(body)
 			if err != nil {
 				panic(err.Error())
 			}
-			req, _ := http.NewRequest(http.MethodPost, "/api/clients/send", bytes.NewBuffer(bodyBytes))
+			req, _ := http.NewRequest(http.MethodPost, "/api/clients/send"+test.query, bytes.NewBuffer(bodyBytes))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			switch {
+			case w.Code == http.StatusAccepted && test.sendAt != nil:
+				var response mailing.Mailing
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, mailing.StatusScheduled, response.Status)
+			case w.Code == http.StatusAccepted:
+				var response handler.MailClientsResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, int64(2), response.Queued)
+				assert.NotEmpty(t, response.OperationID)
+				enqueued := test.m.Calls[0].Arguments.String(1)
+				assert.Equal(t, enqueued, response.OperationID)
+			case w.Code == http.StatusOK:
+				var response handler.MailingPreview
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, 1, response.Count)
+				assert.Equal(t, []string{"a@example.com"}, response.Recipients)
+			}
+
+			test.m.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPreviewMailing(t *testing.T) {
+	messages := []outbox.Message{
+		{CustomerID: 1, MailingID: 7, Recipient: "a@example.com", Subject: "Hi", Body: "hello a"},
+		{CustomerID: 2, MailingID: 7, Recipient: "b@example.com", Subject: "Hi", Body: "hello b"},
+	}
+
+	tests := map[string]struct {
+		m            *dao.MailingDaoMock
+		path         string
+		expectedCode int
+		expected     handler.MailingPreview
+	}{
+		"200 ok": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return(messages, nil)
+				return &d
+			}(),
+			path:         "/api/mailings/7/preview",
+			expectedCode: http.StatusOK,
+			expected: handler.MailingPreview{
+				MailingID:  7,
+				Count:      2,
+				Recipients: []string{"a@example.com", "b@example.com"},
+				Sample: []handler.PreviewMessage{
+					{CustomerID: 1, Recipient: "a@example.com", Subject: "Hi", Body: "hello a"},
+					{CustomerID: 2, Recipient: "b@example.com", Subject: "Hi", Body: "hello b"},
+				},
+			},
+		},
+		"200 smaller sample": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return(messages, nil)
+				return &d
+			}(),
+			path:         "/api/mailings/7/preview?sample=1",
+			expectedCode: http.StatusOK,
+			expected: handler.MailingPreview{
+				MailingID:  7,
+				Count:      2,
+				Recipients: []string{"a@example.com", "b@example.com"},
+				Sample:     []handler.PreviewMessage{{CustomerID: 1, Recipient: "a@example.com", Subject: "Hi", Body: "hello a"}},
+			},
+		},
+		"200 nobody": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return([]outbox.Message(nil), nil)
+				return &d
+			}(),
+			path:         "/api/mailings/7/preview",
+			expectedCode: http.StatusOK,
+			expected:     handler.MailingPreview{MailingID: 7, Recipients: []string{}, Sample: []handler.PreviewMessage{}},
+		},
+		"404 no such mailing": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return([]outbox.Message(nil), &dao.Error{Kind: dao.ErrNotFound, Op: "preview", Err: errors.New("record not found")})
+				return &d
+			}(),
+			path:         "/api/mailings/7/preview",
+			expectedCode: http.StatusNotFound,
+		},
+		"400 bad id": {
+			m:            &dao.MailingDaoMock{},
+			path:         "/api/mailings/--/preview",
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 bad sample": {
+			m:            &dao.MailingDaoMock{},
+			path:         "/api/mailings/7/preview?sample=1000",
+			expectedCode: http.StatusBadRequest,
+		},
+		"500 server error": {
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Preview", mock.Anything, int64(7)).Return([]outbox.Message(nil), errors.New("an error"))
+				return &d
+			}(),
+			path:         "/api/mailings/7/preview",
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Mailings: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodGet, test.path, nil)
 			for key, value := range oKheaders {
 				req.Header.Add(key, value)
 			}
@@ -314,6 +526,11 @@ func TestMailClients(t *testing.T) {
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, test.expectedCode, w.Code)
+			if w.Code == http.StatusOK {
+				var response handler.MailingPreview
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, test.expected, response)
+			}
 
 			test.m.AssertExpectations(t)
 		})
@@ -335,7 +552,7 @@ func TestDeleteCustomer(t *testing.T) {
 			id: "1",
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Delete", mock.Anything, int64(1)).Return(fmt.Errorf("an error"))
+				m.On("Delete", mock.Anything, mock.Anything, mock.Anything, int64(1)).Return(fmt.Errorf("an error"))
 				return &m
 			}(),
 			expectedCode: http.StatusInternalServerError,
@@ -344,7 +561,7 @@ func TestDeleteCustomer(t *testing.T) {
 			id: "1",
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Delete", mock.Anything, int64(1)).Return(nil)
+				m.On("Delete", mock.Anything, mock.Anything, mock.Anything, int64(1)).Return(nil)
 				return &m
 			}(),
 			expectedCode: http.StatusNoContent,
@@ -352,8 +569,7 @@ func TestDeleteCustomer(t *testing.T) {
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			dao.DAO = test.m
-			router := SetupRouter()
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
 			w := httptest.NewRecorder()
 
 			req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/api/clients/%s", test.id), nil)
@@ -378,38 +594,78 @@ func TestFind(t *testing.T) {
 		Content:   "content",
 		MailingID: 1,
 	}
+	mailingID := int64(1)
 
 	tests := map[string]struct {
 		m            *dao.CustomerDaoMock
+		query        string
 		expectedCode int
-		expected     []customer.Customer
+		expected     *handler.FindCustomersResponse
 	}{
 		"500 nok": {
 			expectedCode: http.StatusInternalServerError,
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Find").Return([]customer.Customer{}, errors.New("an error"))
+				m.On("Find", mock.Anything, mock.Anything).Return([]customer.Customer{}, "", errors.New("an error"))
 				return &m
 			}(),
-			expected: []customer.Customer{},
+		},
+		"400 invalid query": {
+			query:        "?sort=content",
+			expectedCode: http.StatusBadRequest,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Find", mock.Anything, dao.FindParams{Sort: "content"}).Return([]customer.Customer{}, "", fmt.Errorf("%w: cannot sort", dao.ErrInvalidQuery))
+				return &m
+			}(),
+		},
+		"400 bad parameter": {
+			query:        "?limit=ten",
+			expectedCode: http.StatusBadRequest,
+			m:            &dao.CustomerDaoMock{},
 		},
 		"200 ok": {
 			expectedCode: http.StatusOK,
 			m: func() *dao.CustomerDaoMock {
 				m := dao.CustomerDaoMock{}
-				m.On("Find").Return([]customer.Customer{aCustomer, aCustomer}, nil)
+				m.On("Find", mock.Anything, dao.FindParams{}).Return([]customer.Customer{aCustomer, aCustomer}, "", nil)
+				return &m
+			}(),
+			expected: &handler.FindCustomersResponse{Customers: []customer.Customer{aCustomer, aCustomer}},
+		},
+		"200 ok, empty": {
+			expectedCode: http.StatusOK,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Find", mock.Anything, dao.FindParams{}).Return([]customer.Customer(nil), "", nil)
+				return &m
+			}(),
+			expected: &handler.FindCustomersResponse{Customers: []customer.Customer{}},
+		},
+		"200 ok, paginated and filtered": {
+			query:        "?limit=1&cursor=abc&mailing_id=1&email=oroparece@platano.es&sort=-created_at&include_deleted=true",
+			expectedCode: http.StatusOK,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Find", mock.Anything, dao.FindParams{
+					Email:          "oroparece@platano.es",
+					MailingID:      &mailingID,
+					IncludeDeleted: "true",
+					Sort:           "-created_at",
+					Cursor:         "abc",
+					Limit:          1,
+				}).Return([]customer.Customer{aCustomer}, "def", nil)
 				return &m
 			}(),
-			expected: []customer.Customer{aCustomer, aCustomer},
+			expected: &handler.FindCustomersResponse{Customers: []customer.Customer{aCustomer}, NextCursor: "def"},
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
-			dao.DAO = test.m
-			router := SetupRouter()
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
 			w := httptest.NewRecorder()
 
-			req, _ := http.NewRequest(http.MethodGet, "/api/clients", nil)
+			req, _ := http.NewRequest(http.MethodGet, "/api/clients"+test.query, nil)
 			for key, value := range oKheaders {
 				req.Header.Add(key, value)
 			}
@@ -418,18 +674,1941 @@ func TestFind(t *testing.T) {
 
 			assert.Equal(t, test.expectedCode, w.Code)
 
-			response := w.Body.String()
+			test.m.AssertExpectations(t)
+			if test.expected == nil {
+				return
+			}
 
-			var listResponse []customer.Customer
-			bytesResponse := []byte(response)
-			err := json.Unmarshal(bytesResponse, &listResponse)
+			var listResponse handler.FindCustomersResponse
+			err := json.Unmarshal(w.Body.Bytes(), &listResponse)
 			if err != nil {
 				panic(err.Error())
 			}
 
-			assert.True(t, reflect.DeepEqual(listResponse, test.expected))
+			assert.True(t, reflect.DeepEqual(listResponse, *test.expected))
+		})
+	}
+}
+
+func TestUpdateCustomer(t *testing.T) {
+	updatedAt := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	current := customer.Customer{
+		Model:     gorm.Model{ID: uint(1), UpdatedAt: updatedAt},
+		Email:     "oroparece@platano.es",
+		Title:     "ninja",
+		Content:   "content",
+		MailingID: 1,
+	}
+	latest := current
+	latest.UpdatedAt = updatedAt.Add(time.Second)
+
+	tests := map[string]struct {
+		m            *dao.CustomerDaoMock
+		method       string
+		id           string
+		contentType  string
+		ifMatch      string
+		body         string
+		expectedCode int
+		expected     *customer.Customer
+		// expectedETag is the one the failed preconditions are answered with
+		expectedETag string
+	}{
+		"200 put": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: current.ETag(),
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				m.On("Update", mock.Anything, &customer.Customer{
+					Model: current.Model,
+					Email: "hello@example.com",
+					Title: "dev",
+				}, updatedAt).Return(nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+			expected: &customer.Customer{
+				Model: current.Model,
+				Email: "hello@example.com",
+				Title: "dev",
+			},
+		},
+		"200 patch": {
+			method:      http.MethodPatch,
+			id:          "1",
+			contentType: "application/merge-patch+json",
+			ifMatch:     current.ETag(),
+			body:        `{"title":"dev","content":null,"id":7}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				m.On("Update", mock.Anything, &customer.Customer{
+					Model:     current.Model,
+					Email:     "oroparece@platano.es",
+					Title:     "dev",
+					MailingID: 1,
+				}, updatedAt).Return(nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+			expected: &customer.Customer{
+				Model:     current.Model,
+				Email:     "oroparece@platano.es",
+				Title:     "dev",
+				MailingID: 1,
+			},
+		},
+		"412 if-match": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: `"1"`,
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				return &m
+			}(),
+			expectedCode: http.StatusPreconditionFailed,
+			expectedETag: current.ETag(),
+		},
+		"412 concurrent update": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: current.ETag(),
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil).Once()
+				m.On("Update", mock.Anything, mock.Anything, updatedAt).Return(dao.ErrStale)
+				m.On("First", mock.Anything, int64(1)).Return(&latest, nil).Once()
+				return &m
+			}(),
+			expectedCode: http.StatusPreconditionFailed,
+			expectedETag: latest.ETag(),
+		},
+		"428 no if-match": {
+			method:       http.MethodPut,
+			id:           "1",
+			body:         `{"email":"hello@example.com","title":"dev"}`,
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusPreconditionRequired,
+		},
+		"400 validation": {
+			method:      http.MethodPatch,
+			id:          "1",
+			contentType: "application/merge-patch+json",
+			ifMatch:     "*",
+			body:        `{"email":null}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				return &m
+			}(),
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 bad id": {
+			method:       http.MethodPut,
+			id:           "--",
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"409 duplicate": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: current.ETag(),
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				m.On("Update", mock.Anything, mock.Anything, updatedAt).Return(fmt.Errorf("%w: an error", dao.ErrConflict))
+				return &m
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"404 not found": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: current.ETag(),
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(nil, fmt.Errorf("%w: not found", dao.ErrNotFound))
+				return &m
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"415 patch": {
+			method:       http.MethodPatch,
+			id:           "1",
+			contentType:  "text/plain",
+			body:         `{"title":"dev"}`,
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		"500 server error": {
+			method:  http.MethodPut,
+			id:      "1",
+			ifMatch: current.ETag(),
+			body:    `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(&current, nil)
+				m.On("Update", mock.Anything, mock.Anything, updatedAt).Return(errors.New("an error"))
+				return &m
+			}(),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, fmt.Sprintf("/api/clients/%s", test.id), bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			if test.ifMatch != "" {
+				req.Header.Set("If-Match", test.ifMatch)
+			}
+
+			router.ServeHTTP(w, req)
 
+			assert.Equal(t, test.expectedCode, w.Code)
 			test.m.AssertExpectations(t)
+			if test.expectedETag != "" {
+				assert.Equal(t, test.expectedETag, w.Header().Get("ETag"))
+			}
+			if test.expected == nil {
+				return
+			}
+
+			var updated customer.Customer
+			err := json.Unmarshal(w.Body.Bytes(), &updated)
+			if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, reflect.DeepEqual(updated, *test.expected))
+			assert.Equal(t, test.expected.ETag(), w.Header().Get("ETag"))
 		})
 	}
 }
+
+func TestCreateCustomers(t *testing.T) {
+	valid := `{"email":"hello@example.com","title":"dev"}`
+	other := `{"email":"bye@example.com","title":"dev"}`
+	invalid := `{"email":"---","title":"dev"}`
+
+	tests := map[string]struct {
+		m            *dao.CustomerDaoMock
+		query        string
+		body         string
+		expectedCode int
+		expected     []string
+	}{
+		"201 atomic": {
+			body: "[" + valid + "," + other + "]",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("CreateBatch", mock.Anything, mock.Anything, mock.Anything).Return(-1, nil)
+				return &m
+			}(),
+			expectedCode: http.StatusCreated,
+			expected:     []string{handler.BatchStatusCreated, handler.BatchStatusCreated},
+		},
+		"400 atomic, invalid item": {
+			body:         "[" + valid + "," + invalid + "]",
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+			expected:     []string{handler.BatchStatusSkipped, handler.BatchStatusInvalid},
+		},
+		"409 atomic, duplicate": {
+			body: "[" + valid + "," + other + "]",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("CreateBatch", mock.Anything, mock.Anything, mock.Anything).Return(1, fmt.Errorf("%w: an error", dao.ErrConflict))
+				return &m
+			}(),
+			expectedCode: http.StatusConflict,
+			expected:     []string{handler.BatchStatusSkipped, handler.BatchStatusDuplicate},
+		},
+		"500 atomic, commit error": {
+			body: "[" + valid + "]",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("CreateBatch", mock.Anything, mock.Anything, mock.Anything).Return(-1, fmt.Errorf("%w: an error", dao.ErrPg))
+				return &m
+			}(),
+			expectedCode: http.StatusInternalServerError,
+			expected:     []string{handler.BatchStatusSkipped},
+		},
+		"201 best effort": {
+			query: "?mode=best_effort",
+			body:  "[" + valid + "," + other + "]",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil).Twice()
+				return &m
+			}(),
+			expectedCode: http.StatusCreated,
+			expected:     []string{handler.BatchStatusCreated, handler.BatchStatusCreated},
+		},
+		"207 best effort": {
+			query: "?mode=best_effort",
+			body:  "[" + valid + "," + invalid + "," + other + "]",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(fmt.Errorf("%w: an error", dao.ErrConflict)).Once()
+				return &m
+			}(),
+			expectedCode: http.StatusMultiStatus,
+			expected:     []string{handler.BatchStatusCreated, handler.BatchStatusInvalid, handler.BatchStatusDuplicate},
+		},
+		"400 unknown mode": {
+			query:        "?mode=yolo",
+			body:         "[" + valid + "]",
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 empty batch": {
+			body:         "[]",
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 not an array": {
+			body:         valid,
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodPost, "/api/clients/batch"+test.query, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.m.AssertExpectations(t)
+			if test.expected == nil {
+				return
+			}
+
+			var response handler.BatchResponse
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil {
+				panic(err.Error())
+			}
+			statuses := make([]string, 0, len(response.Results))
+			for _, result := range response.Results {
+				statuses = append(statuses, result.Status)
+			}
+			assert.Equal(t, test.expected, statuses)
+		})
+	}
+}
+
+func TestRestoreCustomer(t *testing.T) {
+	restored := customer.Customer{
+		Model: gorm.Model{ID: uint(1), UpdatedAt: time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)},
+		Email: "oroparece@platano.es",
+		Title: "ninja",
+	}
+
+	tests := map[string]struct {
+		m            *dao.CustomerDaoMock
+		id           string
+		expectedCode int
+	}{
+		"200 ok": {
+			id: "1",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Restore", mock.Anything, int64(1)).Return(nil)
+				m.On("First", mock.Anything, int64(1)).Return(&restored, nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"404 not deleted": {
+			id: "1",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Restore", mock.Anything, int64(1)).Return(fmt.Errorf("%w: not deleted", dao.ErrNotFound))
+				return &m
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"500 server error": {
+			id: "1",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Restore", mock.Anything, int64(1)).Return(errors.New("an error"))
+				return &m
+			}(),
+			expectedCode: http.StatusInternalServerError,
+		},
+		"400 bad request": {
+			id:           "--",
+			m:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/api/clients/%s/restore", test.id), nil)
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.m.AssertExpectations(t)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+
+			var response customer.Customer
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil {
+				panic(err.Error())
+			}
+			assert.True(t, reflect.DeepEqual(response, restored))
+			assert.Equal(t, restored.ETag(), w.Header().Get("ETag"))
+			// a restored client is no longer deleted
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fields))
+			assert.Contains(t, fields, "deleted_at")
+			assert.Nil(t, fields["deleted_at"])
+		})
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	tests := map[string]struct {
+		m        *dao.CustomerDaoMock
+		method   string
+		path     string
+		body     string
+		expected problem.Details
+	}{
+		"validation": {
+			method: http.MethodPost,
+			path:   "/api/clients",
+			body:   `{"email":"---"}`,
+			m:      &dao.CustomerDaoMock{},
+			expected: problem.Details{
+				Type:     problem.TypeValidation,
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "some fields are not valid",
+				Instance: "a-request-id",
+				Errors: map[string]string{
+					"email": "must be a valid email address",
+					"title": "cannot be blank",
+				},
+			},
+		},
+		"duplicate": {
+			method: http.MethodPost,
+			path:   "/api/clients",
+			body:   `{"email":"hello@example.com","title":"dev"}`,
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(&dao.Error{
+					Kind:       dao.ErrConflict,
+					Op:         "create",
+					Constraint: "idx_multi",
+					Err:        errors.New("ERROR: duplicate key value violates unique constraint"),
+				})
+				return &m
+			}(),
+			expected: problem.Details{
+				Type:     problem.TypeConflict,
+				Title:    "Conflict",
+				Status:   http.StatusConflict,
+				Detail:   "conflicting record: violates idx_multi",
+				Instance: "a-request-id",
+			},
+		},
+		"database": {
+			method: http.MethodDelete,
+			path:   "/api/clients/1",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Delete", mock.Anything, mock.Anything, mock.Anything, int64(1)).Return(fmt.Errorf("%w: delete: connection refused", dao.ErrPg))
+				return &m
+			}(),
+			expected: problem.Details{
+				Type:     problem.TypeDatabase,
+				Title:    "Internal Server Error",
+				Status:   http.StatusInternalServerError,
+				Detail:   dao.ErrPg.Error(),
+				Instance: "a-request-id",
+			},
+		},
+		"timeout": {
+			method: http.MethodGet,
+			path:   "/api/clients",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Find", mock.Anything, mock.Anything).Return([]customer.Customer{}, "", &dao.Error{Kind: dao.ErrTimeout, Op: "find", Err: context.DeadlineExceeded})
+				return &m
+			}(),
+			expected: problem.Details{
+				Type:     problem.TypeTimeout,
+				Title:    "Gateway Timeout",
+				Status:   http.StatusGatewayTimeout,
+				Detail:   dao.ErrTimeout.Error(),
+				Instance: "a-request-id",
+			},
+		},
+		"not found": {
+			method: http.MethodGet,
+			path:   "/api/clients/1",
+			m: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("First", mock.Anything, int64(1)).Return(nil, &dao.Error{Kind: dao.ErrNotFound, Op: "first", Err: errors.New("record not found")})
+				return &m
+			}(),
+			expected: problem.Details{
+				Type:     problem.TypeNotFound,
+				Title:    "Not Found",
+				Status:   http.StatusNotFound,
+				Detail:   dao.ErrNotFound.Error(),
+				Instance: "a-request-id",
+			},
+		},
+		"bad request": {
+			method: http.MethodGet,
+			path:   "/api/clients/abc",
+			m:      &dao.CustomerDaoMock{},
+			expected: problem.Details{
+				Type:     problem.TypeBadRequest,
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   `strconv.ParseInt: parsing "abc": invalid syntax`,
+				Instance: "a-request-id",
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Customers: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+			req.Header.Set(tracing.XRequestID, "a-request-id")
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expected.Status, w.Code)
+			assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+			var details problem.Details
+			err := json.Unmarshal(w.Body.Bytes(), &details)
+			if err != nil {
+				panic(err.Error())
+			}
+			assert.Equal(t, test.expected, details)
+
+			test.m.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailings(t *testing.T) {
+	draft := &mailing.Mailing{ID: 7, Subject: "Hi", Body: "hello", Status: mailing.StatusDraft}
+	sent := &mailing.Mailing{ID: 7, Subject: "Hi", Body: "hello", Status: mailing.StatusSent}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first mailing", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		method       string
+		path         string
+		body         string
+		m            *dao.MailingDaoMock
+		c            *dao.CustomerDaoMock
+		expectedCode int
+	}{
+		"create 201": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi", "body": "hello"}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Create", mock.Anything, &mailing.Mailing{Subject: "Hi", Body: "hello"}).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusCreated,
+		},
+		"create 400 validation": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi"}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"create 201 never expire": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi", "body": "hello", "retention_seconds": 0}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				never := int64(0)
+				d.On("Create", mock.Anything, &mailing.Mailing{Subject: "Hi", Body: "hello", RetentionSeconds: &never}).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusCreated,
+		},
+		"create 400 negative retention": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi", "body": "hello", "retention_seconds": -1}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"create 400 template syntax": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi {{.title", "body": "hello"}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"create 400 unknown merge field": {
+			method: http.MethodPost, path: "/api/mailings", body: `{"subject": "Hi", "body": "hello", "html_body": "{{.name}}"}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"get 200": {
+			method: http.MethodGet, path: "/api/mailings/7",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(draft, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"get 404": {
+			method: http.MethodGet, path: "/api/mailings/7",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(nil, notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"find 200": {
+			method: http.MethodGet, path: "/api/mailings?status=draft&limit=10",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Find", mock.Anything, dao.MailingFindParams{Status: "draft", Limit: 10}).Return([]mailing.Mailing{*draft}, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"update 200": {
+			method: http.MethodPut, path: "/api/mailings/7", body: `{"subject": "Hello", "body": "hello"}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(&mailing.Mailing{ID: 7, Subject: "Hi", Body: "hello", Status: mailing.StatusDraft}, nil)
+				d.On("Update", mock.Anything, mock.MatchedBy(func(m *mailing.Mailing) bool { return m.Subject == "Hello" })).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"update 409 sent": {
+			method: http.MethodPut, path: "/api/mailings/7", body: `{"subject": "Hello", "body": "hello"}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(sent, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"delete 204": {
+			method: http.MethodDelete, path: "/api/mailings/7",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Delete", mock.Anything, int64(7)).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusNoContent,
+		},
+		"delete 409 sending": {
+			method: http.MethodDelete, path: "/api/mailings/7",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Delete", mock.Anything, int64(7)).Return(fmt.Errorf("%w: a sending mailing cannot be deleted", dao.ErrInvalidState))
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"send 202": {
+			method: http.MethodPost, path: "/api/mailings/7/send",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(3), nil)
+				return &d
+			}(),
+			expectedCode: http.StatusAccepted,
+		},
+		"send 422 template": {
+			method: http.MethodPost, path: "/api/mailings/7/send",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Send", mock.Anything, mock.Anything, mock.Anything, int64(7)).Return(int64(0), fmt.Errorf("%w: customer 1: boom", dao.ErrTemplate))
+				return &d
+			}(),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		"cancel 200": {
+			method: http.MethodPost, path: "/api/mailings/7/cancel",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Cancel", mock.Anything, int64(7)).Return(nil)
+				d.On("First", mock.Anything, int64(7)).Return(&mailing.Mailing{ID: 7, Status: mailing.StatusCancelled}, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"schedule 202": {
+			method: http.MethodPost, path: "/api/mailings/7/schedule", body: `{"send_at": "2999-01-01T10:00:00Z"}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Schedule", mock.Anything, int64(7), time.Date(2999, 1, 1, 10, 0, 0, 0, time.UTC)).Return(nil)
+				d.On("First", mock.Anything, int64(7)).Return(&mailing.Mailing{ID: 7, Status: mailing.StatusScheduled}, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusAccepted,
+		},
+		"schedule 400 no send_at": {
+			method: http.MethodPost, path: "/api/mailings/7/schedule", body: `{}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"unschedule 200": {
+			method: http.MethodDelete, path: "/api/mailings/7/schedule",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Unschedule", mock.Anything, int64(7)).Return(nil)
+				d.On("First", mock.Anything, int64(7)).Return(draft, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"unschedule 409": {
+			method: http.MethodDelete, path: "/api/mailings/7/schedule",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("Unschedule", mock.Anything, int64(7)).Return(fmt.Errorf("%w: a draft mailing cannot be unscheduled", dao.ErrInvalidState))
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"add members 200": {
+			method: http.MethodPost, path: "/api/mailings/7/members", body: `{"customer_ids": [1, 2]}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(draft, nil)
+				d.On("AddMembers", mock.Anything, int64(7), []uint{1, 2}).Return(int64(2), nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"add members 400 empty": {
+			method: http.MethodPost, path: "/api/mailings/7/members", body: `{"customer_ids": []}`,
+			m:            &dao.MailingDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"add members 409 sent": {
+			method: http.MethodPost, path: "/api/mailings/7/members", body: `{"customer_ids": [1]}`,
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(sent, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"remove member 204": {
+			method: http.MethodDelete, path: "/api/mailings/7/members/1",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(draft, nil)
+				d.On("RemoveMember", mock.Anything, int64(7), uint(1)).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusNoContent,
+		},
+		"find members 200": {
+			method: http.MethodGet, path: "/api/mailings/7/members",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(draft, nil)
+				return &d
+			}(),
+			c: func() *dao.CustomerDaoMock {
+				d := dao.CustomerDaoMock{}
+				memberOf := int64(7)
+				d.On("Find", mock.Anything, dao.FindParams{MemberOf: &memberOf}).Return([]customer.Customer{{Email: "a@example.com"}}, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if test.c == nil {
+				test.c = &dao.CustomerDaoMock{}
+			}
+			router := testServer(t, dao.Store{Customers: test.c, Mailings: test.m}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.m.AssertExpectations(t)
+			test.c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	letters := []outbox.DeadLetter{{ID: 1, MessageID: 3, MailingID: 7, Recipient: "a@example.com", Attempts: 5}}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first mailing", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		method       string
+		path         string
+		body         string
+		o            *dao.OutboxDaoMock
+		expectedCode int
+	}{
+		"find 200": {
+			method: http.MethodGet, path: "/api/mailings/7/dead-letters?limit=10",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("DeadLetters", mock.Anything, int64(7), dao.PageParams{Limit: 10}).Return(letters, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"find 400 cursor": {
+			method: http.MethodGet, path: "/api/mailings/7/dead-letters?cursor=x",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("DeadLetters", mock.Anything, int64(7), dao.PageParams{Cursor: "x"}).
+					Return(nil, "", fmt.Errorf("%w: malformed cursor", dao.ErrInvalidQuery))
+				return &d
+			}(),
+			expectedCode: http.StatusBadRequest,
+		},
+		"find 404": {
+			method: http.MethodGet, path: "/api/mailings/7/dead-letters",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("DeadLetters", mock.Anything, int64(7), dao.PageParams{}).Return(nil, "", notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"requeue all 202": {
+			method: http.MethodPost, path: "/api/mailings/7/dead-letters",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Requeue", mock.Anything, int64(7), []uint(nil), mock.Anything).Return(int64(1), nil)
+				return &d
+			}(),
+			expectedCode: http.StatusAccepted,
+		},
+		"requeue some 202": {
+			method: http.MethodPost, path: "/api/mailings/7/dead-letters", body: `{"ids": [1]}`,
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Requeue", mock.Anything, int64(7), []uint{1}, mock.Anything).Return(int64(1), nil)
+				return &d
+			}(),
+			expectedCode: http.StatusAccepted,
+		},
+		"requeue 400 empty ids": {
+			method: http.MethodPost, path: "/api/mailings/7/dead-letters", body: `{"ids": []}`,
+			o:            &dao.OutboxDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"requeue 409": {
+			method: http.MethodPost, path: "/api/mailings/7/dead-letters",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Requeue", mock.Anything, int64(7), []uint(nil), mock.Anything).
+					Return(int64(0), fmt.Errorf("%w: the messages of a draft mailing cannot be requeued", dao.ErrInvalidState))
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Outbox: test.o}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.o.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingStatus(t *testing.T) {
+	sending := &mailing.Mailing{ID: 7, Status: mailing.StatusSending}
+	mailingID := int64(7)
+	messages := []outbox.Message{
+		{ID: 1, MailingID: 7, CustomerID: 1, Recipient: "a@example.com", Status: outbox.StatusSent},
+		{ID: 2, MailingID: 7, CustomerID: 2, Recipient: "b@example.com", Status: outbox.StatusBounced},
+	}
+	counts := map[string]int64{outbox.StatusPending: 1, outbox.StatusSent: 1, outbox.StatusBounced: 1}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first mailing", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		path          string
+		m             *dao.MailingDaoMock
+		o             *dao.OutboxDaoMock
+		expectedCode  int
+		expectedTotal int64
+	}{
+		"200": {
+			path: "/api/mailings/7/status?limit=2",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(sending, nil)
+				return &d
+			}(),
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Messages", mock.Anything, dao.MessageFindParams{MailingID: &mailingID, Limit: 2}).Return(messages, "Mg", nil)
+				d.On("Counts", mock.Anything, int64(7)).Return(counts, nil)
+				return &d
+			}(),
+			expectedCode:  http.StatusOK,
+			expectedTotal: 3,
+		},
+		"400 status": {
+			path: "/api/mailings/7/status?status=queued",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(sending, nil)
+				return &d
+			}(),
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Messages", mock.Anything, dao.MessageFindParams{MailingID: &mailingID, Status: "queued"}).
+					Return(nil, "", fmt.Errorf("%w: unknown status queued", dao.ErrInvalidQuery))
+				return &d
+			}(),
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 id": {
+			path:         "/api/mailings/x/status",
+			m:            &dao.MailingDaoMock{},
+			o:            &dao.OutboxDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"404": {
+			path: "/api/mailings/7/status",
+			m: func() *dao.MailingDaoMock {
+				d := dao.MailingDaoMock{}
+				d.On("First", mock.Anything, int64(7)).Return(nil, notFound)
+				return &d
+			}(),
+			o:            &dao.OutboxDaoMock{},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Mailings: test.m, Outbox: test.o}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodGet, test.path, nil)
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			if test.expectedCode == http.StatusOK {
+				var response handler.MailingStatusResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, mailing.StatusSending, response.Status)
+				assert.Equal(t, test.expectedTotal, response.Total)
+				assert.Equal(t, "Mg", response.NextCursor)
+				assert.Len(t, response.Messages, 2)
+				assert.NotContains(t, w.Body.String(), `"body"`)
+			}
+			test.m.AssertExpectations(t)
+			test.o.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCustomerMessages(t *testing.T) {
+	customerID := uint(5)
+	messages := []outbox.Message{{ID: 1, MailingID: 7, CustomerID: 5, Status: outbox.StatusSent}}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first customer", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		path         string
+		o            *dao.OutboxDaoMock
+		expectedCode int
+	}{
+		"200": {
+			path: "/api/clients/5/messages?status=sent",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Messages", mock.Anything, dao.MessageFindParams{CustomerID: &customerID, Status: outbox.StatusSent}).
+					Return(messages, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"400 id": {
+			path:         "/api/clients/-1/messages",
+			o:            &dao.OutboxDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"404": {
+			path: "/api/clients/5/messages",
+			o: func() *dao.OutboxDaoMock {
+				d := dao.OutboxDaoMock{}
+				d.On("Messages", mock.Anything, dao.MessageFindParams{CustomerID: &customerID}).Return(nil, "", notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Outbox: test.o}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodGet, test.path, nil)
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.o.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSuppressions(t *testing.T) {
+	suppressed := &suppression.Suppression{Email: "hello@example.com", Reason: suppression.ReasonManual}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first suppression", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		method       string
+		path         string
+		body         string
+		s            *dao.SuppressionDaoMock
+		expectedCode int
+	}{
+		"create 201": {
+			method: http.MethodPost, path: "/api/suppressions", body: `{"email": "hello@example.com"}`,
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Create", mock.Anything, suppressed).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusCreated,
+		},
+		"create 400 validation": {
+			method: http.MethodPost, path: "/api/suppressions", body: `{"email": "---"}`,
+			s:            &dao.SuppressionDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"create 409": {
+			method: http.MethodPost, path: "/api/suppressions", body: `{"email": "hello@example.com", "reason": "bounced"}`,
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Create", mock.Anything, &suppression.Suppression{Email: "hello@example.com", Reason: "bounced"}).
+					Return(&dao.Error{Kind: dao.ErrConflict, Op: "create suppression", Err: errors.New("duplicate key"), Constraint: "suppressions_pkey"})
+				return &d
+			}(),
+			expectedCode: http.StatusConflict,
+		},
+		"find 200": {
+			method: http.MethodGet, path: "/api/suppressions?limit=10",
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Find", mock.Anything, dao.PageParams{Limit: 10}).Return([]suppression.Suppression{*suppressed}, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"get 200": {
+			method: http.MethodGet, path: "/api/suppressions/hello@example.com",
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("First", mock.Anything, "hello@example.com").Return(suppressed, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"get 404": {
+			method: http.MethodGet, path: "/api/suppressions/hello@example.com",
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("First", mock.Anything, "hello@example.com").Return(nil, notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"delete 204": {
+			method: http.MethodDelete, path: "/api/suppressions/hello@example.com",
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Delete", mock.Anything, "hello@example.com").Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusNoContent,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Suppressions: test.s}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.s.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	subscription := &webhook.Subscription{
+		ID:     1,
+		URL:    "https://example.com/hook",
+		Events: []string{webhook.EventCustomerCreated},
+		Secret: "0123456789abcdef",
+	}
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first subscription", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		method       string
+		path         string
+		body         string
+		w            *dao.WebhookDaoMock
+		expectedCode int
+		expectedBody func(t *testing.T, body []byte)
+	}{
+		"create 201 generated secret": {
+			method: http.MethodPost, path: "/api/webhooks",
+			body: `{"url": "https://example.com/hook", "events": ["customer.created"]}`,
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Create", mock.Anything, mock.Anything).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusCreated,
+			expectedBody: func(t *testing.T, body []byte) {
+				var response handler.CreateWebhookResponse
+				assert.NoError(t, json.Unmarshal(body, &response))
+				assert.Len(t, response.Secret, 64)
+				assert.Equal(t, []string{webhook.EventCustomerCreated}, response.Events)
+			},
+		},
+		"create 201 given secret": {
+			method: http.MethodPost, path: "/api/webhooks",
+			body: `{"url": "https://example.com/hook", "events": ["customer.created"], "secret": "0123456789abcdef"}`,
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Create", mock.Anything, &webhook.Subscription{
+					URL:    "https://example.com/hook",
+					Events: []string{webhook.EventCustomerCreated},
+					Secret: "0123456789abcdef",
+				}).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusCreated,
+			expectedBody: func(t *testing.T, body []byte) {
+				var response handler.CreateWebhookResponse
+				assert.NoError(t, json.Unmarshal(body, &response))
+				assert.Equal(t, "0123456789abcdef", response.Secret)
+			},
+		},
+		"create 400 validation": {
+			method: http.MethodPost, path: "/api/webhooks",
+			body:         `{"url": "ftp://example.com/hook", "events": ["customer.renamed"]}`,
+			w:            &dao.WebhookDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"find 200": {
+			method: http.MethodGet, path: "/api/webhooks?limit=10",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Find", mock.Anything, dao.PageParams{Limit: 10}).Return([]webhook.Subscription{*subscription}, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: func(t *testing.T, body []byte) {
+				assert.NotContains(t, string(body), subscription.Secret)
+			},
+		},
+		"get 200": {
+			method: http.MethodGet, path: "/api/webhooks/1",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("First", mock.Anything, uint(1)).Return(subscription, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: func(t *testing.T, body []byte) {
+				assert.NotContains(t, string(body), subscription.Secret)
+			},
+		},
+		"get 400": {
+			method: http.MethodGet, path: "/api/webhooks/one",
+			w:            &dao.WebhookDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"get 404": {
+			method: http.MethodGet, path: "/api/webhooks/1",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("First", mock.Anything, uint(1)).Return(nil, notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"update 200 keeps secret": {
+			method: http.MethodPut, path: "/api/webhooks/1",
+			body: `{"url": "https://example.com/other", "events": ["mailing.sent"]}`,
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				found := *subscription
+				d.On("First", mock.Anything, uint(1)).Return(&found, nil)
+				d.On("Update", mock.Anything, &webhook.Subscription{
+					ID:     1,
+					URL:    "https://example.com/other",
+					Events: []string{webhook.EventMailingSent},
+					Secret: "0123456789abcdef",
+				}).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"update 404": {
+			method: http.MethodPut, path: "/api/webhooks/1",
+			body: `{"url": "https://example.com/other", "events": ["mailing.sent"]}`,
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("First", mock.Anything, uint(1)).Return(nil, notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"delete 204": {
+			method: http.MethodDelete, path: "/api/webhooks/1",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Delete", mock.Anything, uint(1)).Return(nil)
+				return &d
+			}(),
+			expectedCode: http.StatusNoContent,
+		},
+		"delete 404": {
+			method: http.MethodDelete, path: "/api/webhooks/1",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Delete", mock.Anything, uint(1)).Return(&dao.Error{Kind: dao.ErrNotFound, Op: "delete subscription", Err: errors.New("no subscription with id 1")})
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+		"deliveries 200": {
+			method: http.MethodGet, path: "/api/webhooks/1/deliveries?status=failed",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Deliveries", mock.Anything, uint(1), dao.DeliveryFindParams{Status: webhook.StatusFailed}).
+					Return([]webhook.Delivery{{ID: 3, SubscriptionID: 1, Status: webhook.StatusFailed}}, "", nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"deliveries 400 status": {
+			method: http.MethodGet, path: "/api/webhooks/1/deliveries?status=lost",
+			w: func() *dao.WebhookDaoMock {
+				d := dao.WebhookDaoMock{}
+				d.On("Deliveries", mock.Anything, uint(1), dao.DeliveryFindParams{Status: "lost"}).
+					Return(nil, "", fmt.Errorf("%w: unknown status lost", dao.ErrInvalidQuery))
+				return &d
+			}(),
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Webhooks: test.w}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			if test.expectedBody != nil {
+				test.expectedBody(t, w.Body.Bytes())
+			}
+			test.w.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	token := (&suppression.Signer{Key: []byte("test")}).Token(5, 7)
+	notFound := &dao.Error{Kind: dao.ErrNotFound, Op: "first customer", Err: errors.New("record not found")}
+
+	tests := map[string]struct {
+		method       string
+		path         string
+		s            *dao.SuppressionDaoMock
+		expectedCode int
+		expectedBody string
+	}{
+		"get 200": {
+			method: http.MethodGet, path: "/unsubscribe/" + token,
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Recipient", mock.Anything, uint(5)).Return("hello@example.com", false, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: `"unsubscribed": false`,
+		},
+		"get 404 forged token": {
+			method: http.MethodGet, path: "/unsubscribe/" + (&suppression.Signer{Key: []byte("forged")}).Token(5, 7),
+			s:            &dao.SuppressionDaoMock{},
+			expectedCode: http.StatusNotFound,
+		},
+		"post 200": {
+			method: http.MethodPost, path: "/unsubscribe/" + token,
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Unsubscribe", mock.Anything, uint(5), int64(7)).
+					Return(&suppression.Suppression{Email: "hello@example.com", MailingID: 7}, nil)
+				return &d
+			}(),
+			expectedCode: http.StatusOK,
+			expectedBody: `"unsubscribed": true`,
+		},
+		"post 404 deleted customer": {
+			method: http.MethodPost, path: "/unsubscribe/" + token,
+			s: func() *dao.SuppressionDaoMock {
+				d := dao.SuppressionDaoMock{}
+				d.On("Unsubscribe", mock.Anything, uint(5), int64(7)).Return(nil, notFound)
+				return &d
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Suppressions: test.s}).Router()
+			w := httptest.NewRecorder()
+
+			// no authentication header: the links are public
+			req, _ := http.NewRequest(test.method, test.path, nil)
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			assert.Contains(t, w.Body.String(), test.expectedBody)
+			test.s.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPurgeCustomers(t *testing.T) {
+	purge := config.Default().PurgePolicy()
+	// before tells whether a purge was asked for the customers deleted about grace ago
+	before := func(grace time.Duration) interface{} {
+		return mock.MatchedBy(func(b time.Time) bool {
+			return time.Since(b.Add(grace)) < time.Minute
+		})
+	}
+
+	tests := map[string]struct {
+		body         string
+		c            *dao.CustomerDaoMock
+		expectedCode int
+	}{
+		"200 configured grace period": {
+			c: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Purge", mock.Anything, before(purge.GracePeriod), purge.BatchSize).Return(int64(3), nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"200 given grace period": {
+			body: `{"grace_period": "0s"}`,
+			c: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Purge", mock.Anything, before(0), purge.BatchSize).Return(int64(0), nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"400 bad grace period": {
+			body:         `{"grace_period": "a month"}`,
+			c:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"400 negative grace period": {
+			body:         `{"grace_period": "-1h"}`,
+			c:            &dao.CustomerDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"500": {
+			c: func() *dao.CustomerDaoMock {
+				m := dao.CustomerDaoMock{}
+				m.On("Purge", mock.Anything, mock.Anything, purge.BatchSize).
+					Return(int64(500), fmt.Errorf("%w: purge: connection refused", dao.ErrPg))
+				return &m
+			}(),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Customers: test.c}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodPost, "/admin/purge", bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.c.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetLeader(t *testing.T) {
+	lease := func(holder string, expiresAt time.Time) *leader.Lease {
+		return &leader.Lease{Name: leader.CronLease, Holder: holder, ExpiresAt: expiresAt}
+	}
+
+	tests := map[string]struct {
+		l            *dao.LeaseDaoMock
+		expectedCode int
+		leading      bool
+	}{
+		"leading": {
+			l: func() *dao.LeaseDaoMock {
+				m := dao.LeaseDaoMock{}
+				m.On("First", mock.Anything, leader.CronLease).Return(lease("replica-a", time.Now().Add(time.Minute)), nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+			leading:      true,
+		},
+		"lease expired": {
+			l: func() *dao.LeaseDaoMock {
+				m := dao.LeaseDaoMock{}
+				m.On("First", mock.Anything, leader.CronLease).Return(lease("replica-a", time.Now().Add(-time.Minute)), nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"another replica leads": {
+			l: func() *dao.LeaseDaoMock {
+				m := dao.LeaseDaoMock{}
+				m.On("First", mock.Anything, leader.CronLease).Return(lease("replica-b", time.Now().Add(time.Minute)), nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"never led": {
+			l: func() *dao.LeaseDaoMock {
+				m := dao.LeaseDaoMock{}
+				m.On("First", mock.Anything, leader.CronLease).
+					Return(nil, &dao.Error{Kind: dao.ErrNotFound, Op: "first lease", Err: errors.New("record not found")})
+				return &m
+			}(),
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := testServer(t, dao.Store{Leases: test.l}).Router()
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(http.MethodGet, "/admin/leader", nil)
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			if test.expectedCode == http.StatusOK {
+				var response handler.LeaderResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, test.leading, response.Leading)
+				assert.Equal(t, "replica-a", response.Replica)
+			}
+			test.l.AssertExpectations(t)
+		})
+	}
+}
+
+func TestJobs(t *testing.T) {
+	release := make(chan struct{})
+	leases := &dao.LeaseDaoMock{}
+	leases.On("Acquire", mock.Anything, leader.CronLease, "replica-a", time.Minute).Return(true, nil)
+	elector := &cron.Elector{
+		Leases: leases,
+		Log:    logging.New(ioutil.Discard),
+		Lease:  leader.CronLease,
+		Holder: "replica-a",
+		TTL:    time.Minute,
+	}
+	// jobs are only triggered on the leader
+	elector.Campaign()
+	jobs := cron.NewJobs(elector, nil, logging.New(ioutil.Discard))
+	require.NoError(t, jobs.Add("noop", time.Minute, func(context.Context) (int64, error) { return 0, nil }))
+	require.NoError(t, jobs.Add("blocked", time.Minute, func(context.Context) (int64, error) {
+		<-release
+		return 1, nil
+	}))
+	// the jobs are shared by the cases, which record their runs in their own mock
+	router := func(m *dao.JobDaoMock) http.Handler {
+		jobs.Runs = m
+		s := testServer(t, dao.Store{Jobs: m})
+		s.Admin.Jobs = jobs
+		return s.Router()
+	}
+
+	lastRuns := func(m *dao.JobDaoMock) {
+		m.On("LastRuns", mock.Anything).Return(map[string]job.Run{"noop": {ID: 3, Job: "noop", Rows: 2}}, nil)
+	}
+
+	recorded := make(chan struct{})
+	tests := map[string]struct {
+		method       string
+		path         string
+		body         string
+		j            *dao.JobDaoMock
+		expectedCode int
+		// recorded is closed once a run triggered is recorded
+		recorded chan struct{}
+	}{
+		"list 200": {
+			method: http.MethodGet, path: "/admin/jobs",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"get 200": {
+			method: http.MethodGet, path: "/admin/jobs/noop",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"get 404": {
+			method: http.MethodGet, path: "/admin/jobs/unknown",
+			j:            &dao.JobDaoMock{},
+			expectedCode: http.StatusNotFound,
+		},
+		"pause 200": {
+			method: http.MethodPost, path: "/admin/jobs/noop/pause",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				m.On("SaveSettings", mock.Anything, mock.MatchedBy(func(s *job.Settings) bool {
+					return s.Tag == "noop" && s.Paused
+				})).Return(nil)
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"resume 200": {
+			method: http.MethodPost, path: "/admin/jobs/noop/resume",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				m.On("SaveSettings", mock.Anything, mock.MatchedBy(func(s *job.Settings) bool {
+					return s.Tag == "noop" && !s.Paused
+				})).Return(nil)
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"resume 500": {
+			method: http.MethodPost, path: "/admin/jobs/noop/resume",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				m.On("SaveSettings", mock.Anything, mock.Anything).
+					Return(&dao.Error{Kind: dao.ErrPg, Op: "save job settings", Err: errors.New("an error")})
+				return &m
+			}(),
+			expectedCode: http.StatusInternalServerError,
+		},
+		"interval 200": {
+			method: http.MethodPut, path: "/admin/jobs/noop/interval", body: `{"interval": "30s"}`,
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				m.On("SaveSettings", mock.Anything, mock.MatchedBy(func(s *job.Settings) bool {
+					return s.Tag == "noop" && s.IntervalSeconds == 30
+				})).Return(nil)
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"interval 400 not whole seconds": {
+			method: http.MethodPut, path: "/admin/jobs/noop/interval", body: `{"interval": "1500ms"}`,
+			j:            &dao.JobDaoMock{},
+			expectedCode: http.StatusBadRequest,
+		},
+		"interval 404": {
+			method: http.MethodPut, path: "/admin/jobs/unknown/interval", body: `{"interval": "30s"}`,
+			j:            &dao.JobDaoMock{},
+			expectedCode: http.StatusNotFound,
+		},
+		"run 202": {
+			method: http.MethodPost, path: "/admin/jobs/noop/run",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				// recorded once the run is over, which may be after the answer
+				m.On("Record", mock.Anything, mock.Anything).Return(nil).Run(func(mock.Arguments) { close(recorded) })
+				lastRuns(&m)
+				return &m
+			}(),
+			expectedCode: http.StatusAccepted,
+			recorded:     recorded,
+		},
+		"runs 200": {
+			method: http.MethodGet, path: "/admin/jobs/noop/runs?limit=1",
+			j: func() *dao.JobDaoMock {
+				m := dao.JobDaoMock{}
+				m.On("Runs", mock.Anything, "noop", dao.PageParams{Limit: 1}).Return([]job.Run{{ID: 3, Job: "noop"}}, "Mw", nil)
+				return &m
+			}(),
+			expectedCode: http.StatusOK,
+		},
+		"runs 404": {
+			method: http.MethodGet, path: "/admin/jobs/unknown/runs",
+			j:            &dao.JobDaoMock{},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := router(test.j)
+			w := httptest.NewRecorder()
+
+			req, _ := http.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+
+			router.ServeHTTP(w, req)
+			if test.recorded != nil {
+				select {
+				case <-test.recorded:
+				case <-time.After(time.Second):
+				}
+			}
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			test.j.AssertExpectations(t)
+		})
+	}
+
+	t.Run("run 409", func(t *testing.T) {
+		m := dao.JobDaoMock{}
+		m.On("Record", mock.Anything, mock.Anything).Return(nil).Maybe()
+		lastRuns(&m)
+		router := router(&m)
+
+		codes := make([]int, 0, 2)
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/admin/jobs/blocked/run", nil)
+			for key, value := range oKheaders {
+				req.Header.Add(key, value)
+			}
+			router.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+			// the first run is blocked until the test is over: wait for it to start
+			assert.Eventually(t, func() bool {
+				info, err := jobs.Get(context.Background(), "blocked")
+				return err == nil && info.Running
+			}, time.Second, 10*time.Millisecond)
+		}
+		assert.Equal(t, []int{http.StatusAccepted, http.StatusConflict}, codes)
+	})
+
+	t.Run("run 409 not leading", func(t *testing.T) {
+		follower := cron.NewJobs(&cron.Elector{Lease: leader.CronLease, Holder: "replica-a", TTL: time.Minute}, nil,
+			logging.New(ioutil.Discard))
+		require.NoError(t, follower.Add("noop", time.Minute, func(context.Context) (int64, error) { return 0, nil }))
+		l := dao.LeaseDaoMock{}
+		l.On("First", mock.Anything, leader.CronLease).
+			Return(&leader.Lease{Name: leader.CronLease, Holder: "replica-b", ExpiresAt: time.Now().Add(time.Minute)}, nil)
+		s := testServer(t, dao.Store{Leases: &l})
+		s.Admin.Jobs = follower
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/admin/jobs/noop/run", nil)
+		for key, value := range oKheaders {
+			req.Header.Add(key, value)
+		}
+		s.Router().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, w.Body.String(), "this replica does not lead the jobs, replica-b does")
+		l.AssertExpectations(t)
+	})
+
+	t.Run("run 503 once stopped", func(t *testing.T) {
+		m := dao.JobDaoMock{}
+		m.On("Record", mock.Anything, mock.MatchedBy(func(r *job.Run) bool { return r.Job == "blocked" })).Return(nil)
+		router := router(&m)
+
+		// the blocked run is still in progress: it is not waited for past the deadline
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, jobs.Stop(ctx), context.DeadlineExceeded)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/admin/jobs/noop/run", nil)
+		for key, value := range oKheaders {
+			req.Header.Add(key, value)
+		}
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		// once released, the blocked run is waited for
+		close(release)
+		assert.NoError(t, jobs.Stop(context.Background()))
+		m.AssertExpectations(t)
+	})
+}
+
+func TestReady(t *testing.T) {
+	s := testServer(t, dao.Store{})
+	router := s.Router()
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		// probes send no token
+		req, _ := http.NewRequest(http.MethodGet, "/ready", nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, ready())
+	s.Health.Drain()
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	m := dao.CustomerDaoMock{}
+	// the query is bound by the timeout of the request, and cancelled once it is over
+	m.On("First", mock.MatchedBy(func(ctx context.Context) bool {
+		_, ok := ctx.Deadline()
+		return ok
+	}), int64(1)).
+		Run(func(args mock.Arguments) { <-args.Get(0).(context.Context).Done() }).
+		Return(nil, &dao.Error{Kind: dao.ErrTimeout, Op: "first", Err: context.DeadlineExceeded})
+	s := testServer(t, dao.Store{Customers: &m})
+	s.Config.Server.RequestTimeout = 10 * time.Millisecond
+	router := s.Router()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/clients/1", nil)
+	for key, value := range oKheaders {
+		req.Header.Add(key, value)
+	}
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), problem.TypeTimeout)
+	m.AssertExpectations(t)
+}
+
+func TestMigrate(t *testing.T) {
+	known := []migration.Migration{{Version: 1, Name: "one"}, {Version: 2, Name: "two"}}
+	appliedAt := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		args           []string
+		migrations     *dao.MigrationDaoMock
+		expectedOutput string
+		withError      error
+	}{
+		"up": {
+			args: []string{"up"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("Up").Return([]migration.Step{{Migration: known[0]}, {Migration: known[1]}}, nil)
+				return m
+			}(),
+			expectedOutput: "apply 1_one\napply 2_two\n",
+		},
+		"up to date": {
+			args: []string{"up"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("Up").Return([]migration.Step{}, nil)
+				return m
+			}(),
+			expectedOutput: "nothing to do\n",
+		},
+		"down": {
+			args: []string{"down"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("Down").Return([]migration.Step{{Migration: known[1], Undo: true}}, nil)
+				return m
+			}(),
+			expectedOutput: "undo 2_two\n",
+		},
+		"to": {
+			args: []string{"to", "1"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("To", 1).Return([]migration.Step{{Migration: known[0]}}, nil)
+				return m
+			}(),
+			expectedOutput: "apply 1_one\n",
+		},
+		"to an unknown version": {
+			args: []string{"to", "3"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("To", 3).Return(nil, migration.ErrUnknownVersion)
+				return m
+			}(),
+			withError: migration.ErrUnknownVersion,
+		},
+		"status": {
+			args: []string{"status"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("Applied").Return([]migration.Record{{Version: 1, Name: "one", AppliedAt: appliedAt}}, nil)
+				return m
+			}(),
+			expectedOutput: "schema version 1, latest 2\n1_one  applied 2023-03-01T10:00:00Z\n2_two  pending\n",
+		},
+		"status ahead": {
+			args: []string{"status"},
+			migrations: func() *dao.MigrationDaoMock {
+				m := &dao.MigrationDaoMock{}
+				m.On("Applied").Return([]migration.Record{
+					{Version: 1, Name: "one", AppliedAt: appliedAt},
+					{Version: 2, Name: "two", AppliedAt: appliedAt},
+					{Version: 3, Name: "three", AppliedAt: appliedAt},
+				}, nil)
+				return m
+			}(),
+			expectedOutput: "schema version 3, latest 2\n1_one    applied 2023-03-01T10:00:00Z\n" +
+				"2_two    applied 2023-03-01T10:00:00Z\n3_three  unknown\n",
+		},
+		"no command": {
+			migrations: &dao.MigrationDaoMock{},
+			withError:  errMigrateUsage,
+		},
+		"bad version": {
+			args:       []string{"to", "latest"},
+			migrations: &dao.MigrationDaoMock{},
+			withError:  errMigrateUsage,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := migrate(test.migrations, known, test.args, out)
+			test.migrations.AssertExpectations(t)
+			if test.withError != nil {
+				assert.ErrorIs(t, err, test.withError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedOutput, out.String())
+		})
+	}
+}
+
+func TestUnsubscribeKey(t *testing.T) {
+	tests := map[string]struct {
+		key         string
+		development bool
+		withError   error
+	}{
+		"set":                    {key: "test"},
+		"missing":                {withError: errNoUnsubscribeKey},
+		"missing in development": {development: true},
+		"set in development":     {key: "test", development: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.UnsubscribeKey, cfg.Development = test.key, test.development
+			_, err := newServer(cfg, logging.New(ioutil.Discard), ioutil.Discard, &dao.Store{}, nil, "replica-a")
+			assert.ErrorIs(t, err, test.withError)
+		})
+	}
+}
+
+func TestServeRefusesUnknownSchema(t *testing.T) {
+	m := &dao.MigrationDaoMock{}
+	m.On("Check").Return(fmt.Errorf("%w 3: the latest migration known is 2", migration.ErrUnknownVersion))
+	s := testServer(t, dao.Store{Migrations: m})
+
+	assert.ErrorIs(t, s.Serve(), migration.ErrUnknownVersion)
+	m.AssertExpectations(t)
+}
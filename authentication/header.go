@@ -1,8 +1,11 @@
 package authentication
 
+import "crypto/subtle"
+
 const (
 	AuthTokenHeader = "X-Token"
-	AuthTokenValue  = "test"
+	// DefaultToken is the token expected unless configured otherwise, which only suits local development
+	DefaultToken = "test"
 )
 
 type headerAuth interface {
@@ -12,8 +15,11 @@ type headerAuth interface {
 type HeaderAuth struct {
 	Name  string
 	Value string
+	// Token is the value expected
+	Token string
 }
 
 func (h *HeaderAuth) Login() bool {
-	return h.Name == AuthTokenHeader && h.Value == AuthTokenValue
+	return h.Name == AuthTokenHeader && h.Token != "" &&
+		subtle.ConstantTimeCompare([]byte(h.Value), []byte(h.Token)) == 1
 }
@@ -26,6 +26,7 @@ func TestHeaderAuth_Login(t *testing.T) {
 			input: HeaderAuth{
 				Name:  "X-Token",
 				Value: "test",
+				Token: "test",
 			},
 			output: true,
 		},
@@ -33,12 +34,19 @@ func TestHeaderAuth_Login(t *testing.T) {
 			input: HeaderAuth{
 				Name:  "X-Token",
 				Value: "-",
+				Token: "test",
 			},
 		},
 		"no x-token": {
 			input: HeaderAuth{
 				Name:  "-",
 				Value: "test",
+				Token: "test",
+			},
+		},
+		"no token configured": {
+			input: HeaderAuth{
+				Name: "X-Token",
 			},
 		},
 	}
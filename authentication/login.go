@@ -7,17 +7,19 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func HeaderAuthMiddleware() gin.HandlerFunc {
+// HeaderAuthMiddleware lets through the requests whose AuthTokenHeader holds token. The token is not logged.
+func HeaderAuthMiddleware(token string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		header := HeaderAuth{
 			Name:  AuthTokenHeader,
 			Value: ctx.Request.Header.Get(AuthTokenHeader),
+			Token: token,
 		}
 		if header.Login() {
-			log.Printf("Auth success: %s:%s\n", header.Name, header.Value)
+			log.Printf("Auth success: %s\n", header.Name)
 			ctx.Next()
 		} else {
-			log.Printf("Auth failure: %s:%s\n", header.Name, header.Value)
+			log.Printf("Auth failure: %s\n", header.Name)
 			ctx.AbortWithStatus(http.StatusUnauthorized)
 		}
 	}
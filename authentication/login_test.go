@@ -11,7 +11,7 @@ import (
 )
 
 func TestHeaderAuthMiddleware(t *testing.T) {
-	handler := HeaderAuthMiddleware()
+	handler := HeaderAuthMiddleware("secret")
 
 	tests := map[string]struct {
 		ctx       *gin.Context
@@ -21,8 +21,12 @@ func TestHeaderAuthMiddleware(t *testing.T) {
 			ctx:       newContext(map[string]string{}),
 			isAborted: true,
 		},
+		"X-Token=secret in context": {
+			ctx: newContext(map[string]string{AuthTokenHeader: "secret"}),
+		},
 		"X-Token=test in context": {
-			ctx: newContext(map[string]string{AuthTokenHeader: AuthTokenValue}),
+			ctx:       newContext(map[string]string{AuthTokenHeader: DefaultToken}),
+			isAborted: true,
 		},
 	}
 	for name, test := range tests {
@@ -0,0 +1,339 @@
+package config
+
+import (
+	"api/authentication"
+	"api/cron"
+	"api/mail"
+	"api/outbox"
+	"api/retention"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the value of secrets when the configuration is printed
+const Redacted = "REDACTED"
+
+type (
+	// Config is the whole configuration of the service. Every setting may be given in the YAML file, by the
+	// environment variable of its env tag, or by the flag named after its YAML path, e.g. -database.host, each
+	// overriding the previous ones. Settings tagged as secret may also be read from the file named by their
+	// environment variable suffixed with _FILE, and are redacted when printed.
+	Config struct {
+		Server    Server    `yaml:"server"`
+		Database  Database  `yaml:"database"`
+		Auth      Auth      `yaml:"auth"`
+		Log       Log       `yaml:"log"`
+		Mail      Mail      `yaml:"mail"`
+		Retention Retention `yaml:"retention"`
+		Purge     Purge     `yaml:"purge"`
+		Cron      Cron      `yaml:"cron"`
+		// PublicURL is the address this service is reached at from outside, which unsubscribe links point at
+		PublicURL string `yaml:"public_url" env:"PUBLIC_URL"`
+		// UnsubscribeKey signs the unsubscribe links. The service does not start without it, but in development,
+		// where a random key is used and the links sent stop working once the service restarts.
+		UnsubscribeKey string `yaml:"unsubscribe_key" env:"UNSUBSCRIBE_KEY" secret:"true"`
+		// Development allows what only suits local development, such as a random UnsubscribeKey
+		Development bool `yaml:"development" env:"DEVELOPMENT"`
+	}
+
+	Server struct {
+		Addr string `yaml:"addr" env:"SERVER_ADDR"`
+		// DrainDelay is how long requests are still served once readiness fails, for load balancers to notice
+		DrainDelay time.Duration `yaml:"drain_delay" env:"SERVER_DRAIN_DELAY"`
+		// ShutdownTimeout is how long the requests in flight, then the running cron jobs, are waited for on shutdown
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+		// RequestTimeout bounds the requests to the API, but the bulk ones
+		RequestTimeout time.Duration `yaml:"request_timeout" env:"SERVER_REQUEST_TIMEOUT"`
+		// BulkTimeout bounds the requests working on many customers at once, e.g. creating a batch or purging
+		BulkTimeout time.Duration `yaml:"bulk_timeout" env:"SERVER_BULK_TIMEOUT"`
+	}
+
+	Database struct {
+		Host     string `yaml:"host" env:"DATABASE_HOST"`
+		Port     int    `yaml:"port" env:"DATABASE_PORT"`
+		User     string `yaml:"user" env:"DATABASE_USER"`
+		Password string `yaml:"password" env:"DATABASE_PASSWORD" secret:"true"`
+		Name     string `yaml:"name" env:"DATABASE_NAME"`
+		SSLMode  string `yaml:"sslmode" env:"DATABASE_SSLMODE"`
+		// TimeZone is the one of the database sessions
+		TimeZone string `yaml:"timezone" env:"DATABASE_TIMEZONE"`
+	}
+
+	Auth struct {
+		// Token is the value expected in the X-Token header
+		Token string `yaml:"token" env:"AUTH_TOKEN" secret:"true"`
+	}
+
+	Log struct {
+		// AppFile is where the service logs
+		AppFile string `yaml:"app_file" env:"LOG_APP_FILE"`
+		// GinFile is where the requests served are logged, besides the standard output
+		GinFile string `yaml:"gin_file" env:"LOG_GIN_FILE"`
+	}
+
+	Mail struct {
+		// Driver is mail.DriverSMTP, mail.DriverSendmail or mail.DriverMaildir
+		Driver       string `yaml:"driver" env:"MAIL_DRIVER"`
+		From         string `yaml:"from" env:"MAIL_FROM"`
+		MaildirDir   string `yaml:"maildir_dir" env:"MAIL_MAILDIR_DIR"`
+		SendmailPath string `yaml:"sendmail_path" env:"MAIL_SENDMAIL_PATH"`
+		SMTP         SMTP   `yaml:"smtp"`
+		// PostSend is what happens to a customer once its message was sent, outbox.PostSendDelete or
+		// outbox.PostSendKeep
+		PostSend outbox.PostSendAction `yaml:"post_send" env:"MAIL_POST_SEND"`
+	}
+
+	SMTP struct {
+		Host     string        `yaml:"host" env:"SMTP_HOST"`
+		Port     int           `yaml:"port" env:"SMTP_PORT"`
+		Username string        `yaml:"username" env:"SMTP_USERNAME"`
+		Password string        `yaml:"password" env:"SMTP_PASSWORD" secret:"true"`
+		StartTLS bool          `yaml:"starttls" env:"SMTP_STARTTLS"`
+		Timeout  time.Duration `yaml:"timeout" env:"SMTP_TIMEOUT"`
+	}
+
+	// Retention is a retention.Policy
+	Retention struct {
+		Period   Period        `yaml:"period" env:"RETENTION_PERIOD"`
+		Interval time.Duration `yaml:"interval" env:"RETENTION_INTERVAL"`
+	}
+
+	// Purge is a retention.Purge
+	Purge struct {
+		GracePeriod time.Duration `yaml:"grace_period" env:"PURGE_GRACE_PERIOD"`
+		Interval    time.Duration `yaml:"interval" env:"PURGE_INTERVAL"`
+		BatchSize   int           `yaml:"batch_size" env:"PURGE_BATCH_SIZE"`
+	}
+
+	Cron struct {
+		// Timeout bounds every run of the cron jobs
+		Timeout time.Duration `yaml:"timeout" env:"CRON_TIMEOUT"`
+	}
+
+	// Period is a retention period, as parsed by retention.ParsePeriod
+	Period time.Duration
+)
+
+// Default returns the configuration used unless told otherwise. It suits local development, against the database of
+// docker-compose.yaml.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":8080",
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			RequestTimeout:  10 * time.Second,
+			BulkTimeout:     2 * time.Minute,
+		},
+		Database: Database{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "example",
+			Name:     "customer",
+			SSLMode:  "disable",
+			TimeZone: "Europe/Warsaw",
+		},
+		Auth: Auth{Token: authentication.DefaultToken},
+		Log:  Log{AppFile: "app.log", GinFile: "gin.log"},
+		Mail: Mail{
+			Driver:     mail.DriverMaildir,
+			From:       "noreply@localhost",
+			MaildirDir: "maildir",
+			SMTP:       SMTP{Port: 587, StartTLS: true},
+			PostSend:   outbox.PostSendDelete,
+		},
+		Retention: Retention{
+			Period:   Period(retention.DefaultPolicy.Period),
+			Interval: retention.DefaultPolicy.Interval,
+		},
+		Purge: Purge{
+			GracePeriod: retention.DefaultPurge.GracePeriod,
+			Interval:    retention.DefaultPurge.Interval,
+			BatchSize:   retention.DefaultPurge.BatchSize,
+		},
+		Cron:      Cron{Timeout: cron.DefaultTimeout},
+		PublicURL: "http://localhost:8080",
+	}
+}
+
+func (c Config) Validate() error {
+	return validation.Errors{
+		"server":     c.Server.Validate(),
+		"database":   c.Database.Validate(),
+		"auth":       validation.Validate(c.Auth.Token, validation.Required),
+		"log":        c.Log.Validate(),
+		"mail":       c.Mail.Validate(),
+		"retention":  c.RetentionPolicy().Validate(),
+		"purge":      c.PurgePolicy().Validate(),
+		"cron":       c.Cron.Validate(),
+		"public_url": validation.Validate(c.PublicURL, validation.Required, is.URL, validation.By(absolute)),
+	}.Filter()
+}
+
+func (s Server) Validate() error {
+	return validation.Errors{
+		"addr":             validation.Validate(s.Addr, validation.Required),
+		"drain_delay":      validation.Validate(s.DrainDelay, validation.Min(time.Duration(0))),
+		"shutdown_timeout": validation.Validate(s.ShutdownTimeout, validation.Required, validation.Min(time.Second)),
+		"request_timeout":  validation.Validate(s.RequestTimeout, validation.Required, validation.Min(time.Second)),
+		"bulk_timeout":     validation.Validate(s.BulkTimeout, validation.Required, validation.Min(time.Second)),
+	}.Filter()
+}
+
+func (d Database) Validate() error {
+	return validation.Errors{
+		"host": validation.Validate(d.Host, validation.Required),
+		"port": validation.Validate(d.Port, validation.Required, validation.Min(1), validation.Max(65535)),
+		"user": validation.Validate(d.User, validation.Required),
+		"name": validation.Validate(d.Name, validation.Required),
+		"sslmode": validation.Validate(d.SSLMode,
+			validation.In("disable", "allow", "prefer", "require", "verify-ca", "verify-full")),
+		"timezone": validation.Validate(d.TimeZone, validation.By(location)),
+	}.Filter()
+}
+
+func (l Log) Validate() error {
+	return validation.Errors{
+		"app_file": validation.Validate(l.AppFile, validation.Required),
+		"gin_file": validation.Validate(l.GinFile, validation.Required),
+	}.Filter()
+}
+
+func (c Cron) Validate() error {
+	return validation.Errors{
+		"timeout": validation.Validate(c.Timeout, validation.Required, validation.Min(time.Second)),
+	}.Filter()
+}
+
+func (m Mail) Validate() error {
+	if _, err := mail.New(m.Config()); err != nil {
+		return err
+	}
+	return validation.Errors{
+		"from": validation.Validate(m.From, validation.Required, validation.By(address)),
+		"post_send": validation.Validate(m.PostSend, validation.Required,
+			validation.In(outbox.PostSendDelete, outbox.PostSendKeep)),
+	}.Filter()
+}
+
+// DSN is the data source name of the database, as understood by the postgres driver
+func (d Database) DSN() string {
+	values := []string{
+		"host=" + quote(d.Host),
+		fmt.Sprintf("port=%d", d.Port),
+		"user=" + quote(d.User),
+		"password=" + quote(d.Password),
+		"dbname=" + quote(d.Name),
+	}
+	if d.SSLMode != "" {
+		values = append(values, "sslmode="+quote(d.SSLMode))
+	}
+	if d.TimeZone != "" {
+		values = append(values, "TimeZone="+quote(d.TimeZone))
+	}
+	return strings.Join(values, " ")
+}
+
+// Config returns the configuration of the mail.Mailer
+func (m Mail) Config() mail.Config {
+	return mail.Config{
+		Driver: m.Driver,
+		SMTP: mail.SMTPMailer{
+			Host:     m.SMTP.Host,
+			Port:     m.SMTP.Port,
+			Username: m.SMTP.Username,
+			Password: m.SMTP.Password,
+			StartTLS: m.SMTP.StartTLS,
+			Timeout:  m.SMTP.Timeout,
+		},
+		SendmailPath: m.SendmailPath,
+		MaildirDir:   m.MaildirDir,
+	}
+}
+
+func (c Config) RetentionPolicy() retention.Policy {
+	return retention.Policy{Period: time.Duration(c.Retention.Period), Interval: c.Retention.Interval}
+}
+
+func (c Config) PurgePolicy() retention.Purge {
+	return retention.Purge{
+		GracePeriod: c.Purge.GracePeriod,
+		Interval:    c.Purge.Interval,
+		BatchSize:   c.Purge.BatchSize,
+	}
+}
+
+// String prints the configuration as YAML, with the secrets set redacted
+func (c Config) String() string {
+	redact(reflect.ValueOf(&c).Elem())
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+func (p *Period) UnmarshalText(text []byte) error {
+	d, err := retention.ParsePeriod(string(text))
+	*p = Period(d)
+	return err
+}
+
+func (p Period) MarshalText() ([]byte, error) {
+	if p == 0 {
+		return []byte(retention.Never), nil
+	}
+	return []byte(time.Duration(p).String()), nil
+}
+
+// redact replaces the secrets set in v, a struct, with Redacted
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field, f := v.Field(i), v.Type().Field(i)
+		switch {
+		case f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}):
+			redact(field)
+		case f.Tag.Get("secret") == "true" && field.String() != "":
+			field.SetString(Redacted)
+		}
+	}
+}
+
+// quote escapes a value of a DSN, see https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, ` '\`) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func absolute(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("must be an absolute URL")
+	}
+	return nil
+}
+
+func address(value interface{}) error {
+	if _, err := netmail.ParseAddress(value.(string)); err != nil {
+		return fmt.Errorf("must be an email address")
+	}
+	return nil
+}
+
+func location(value interface{}) error {
+	if _, err := time.LoadLocation(value.(string)); err != nil {
+		return fmt.Errorf("must be a time zone, e.g. UTC or Europe/Warsaw")
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"api/outbox"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	yml := file("config.yaml", "database:\n  host: file\n  name: file\nretention:\n  period: never\n")
+
+	tests := map[string]struct {
+		args      []string
+		env       map[string]string
+		expected  func(c *Config)
+		withError *regexp.Regexp
+	}{
+		"defaults": {
+			expected: func(c *Config) {},
+		},
+		"file": {
+			args: []string{"-config", yml},
+			expected: func(c *Config) {
+				c.Database.Host = "file"
+				c.Database.Name = "file"
+				c.Retention.Period = 0
+			},
+		},
+		"file from the environment": {
+			env: map[string]string{FileEnv: yml},
+			expected: func(c *Config) {
+				c.Database.Host = "file"
+				c.Database.Name = "file"
+				c.Retention.Period = 0
+			},
+		},
+		"environment over file": {
+			args: []string{"-config", yml},
+			env:  map[string]string{"DATABASE_HOST": "env", "RETENTION_PERIOD": "720h", "SMTP_STARTTLS": "false"},
+			expected: func(c *Config) {
+				c.Database.Host = "env"
+				c.Database.Name = "file"
+				c.Retention.Period = Period(720 * time.Hour)
+				c.Mail.SMTP.StartTLS = false
+			},
+		},
+		"flags over environment": {
+			args: []string{"-config", yml, "-database.host", "flag", "-server.drain_delay=1s", "-mail.smtp.starttls=false"},
+			env:  map[string]string{"DATABASE_HOST": "env"},
+			expected: func(c *Config) {
+				c.Database.Host = "flag"
+				c.Database.Name = "file"
+				c.Retention.Period = 0
+				c.Server.DrainDelay = time.Second
+				c.Mail.SMTP.StartTLS = false
+			},
+		},
+		"secret from a file": {
+			env: map[string]string{"AUTH_TOKEN_FILE": file("token", "s3cr3t\n")},
+			expected: func(c *Config) {
+				c.Auth.Token = "s3cr3t"
+			},
+		},
+		"secret from both the environment and a file": {
+			env:       map[string]string{"AUTH_TOKEN": "s3cr3t", "AUTH_TOKEN_FILE": file("token", "s3cr3t\n")},
+			withError: regexp.MustCompile("AUTH_TOKEN and AUTH_TOKEN_FILE are both set"),
+		},
+		"missing secret file": {
+			env:       map[string]string{"DATABASE_PASSWORD_FILE": filepath.Join(dir, "missing")},
+			withError: regexp.MustCompile("DATABASE_PASSWORD_FILE: .*no such file"),
+		},
+		"unknown field in file": {
+			args:      []string{"-config", file("unknown.yaml", "database:\n  hots: typo\n")},
+			withError: regexp.MustCompile("field hots not found"),
+		},
+		"unknown flag": {
+			args:      []string{"-database.hots", "typo"},
+			withError: regexp.MustCompile("flag provided but not defined"),
+		},
+		"not a duration": {
+			env:       map[string]string{"PURGE_INTERVAL": "hourly"},
+			withError: regexp.MustCompile("PURGE_INTERVAL: .*invalid duration"),
+		},
+		"not a number": {
+			args:      []string{"-database.port", "postgres"},
+			withError: regexp.MustCompile("-database.port: .*invalid syntax"),
+		},
+		"not valid": {
+			env:       map[string]string{"DATABASE_PORT": "0", "AUTH_TOKEN": "", "PUBLIC_URL": "/relative"},
+			withError: regexp.MustCompile("auth: cannot be blank.*database: \\(port: cannot be blank.*public_url"),
+		},
+		"negative retention period": {
+			args:      []string{"-retention.period", "-1h"},
+			withError: regexp.MustCompile("must be positive"),
+		},
+		"development": {
+			args: []string{"-development"},
+			expected: func(c *Config) {
+				c.Development = true
+			},
+		},
+		"post-send action": {
+			env: map[string]string{"MAIL_POST_SEND": "keep"},
+			expected: func(c *Config) {
+				c.Mail.PostSend = outbox.PostSendKeep
+			},
+		},
+		"unknown post-send action": {
+			args:      []string{"-mail.post_send", "archive"},
+			withError: regexp.MustCompile("mail: \\(post_send: must be a valid value"),
+		},
+		"unknown mail driver": {
+			env:       map[string]string{"MAIL_DRIVER": "pigeon"},
+			withError: regexp.MustCompile(`mail: unknown mail driver "pigeon"`),
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range test.env {
+				setenv(t, key, value)
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+
+			c, err := Load(fs, test.args)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.Regexp(t, test.withError, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			expected := Default()
+			test.expected(&expected)
+			assert.Equal(t, expected, c)
+		})
+	}
+}
+
+func TestConfig_String(t *testing.T) {
+	c := Default()
+	c.Auth.Token = "s3cr3t"
+
+	s := c.String()
+	assert.NotContains(t, s, "s3cr3t")
+	assert.NotContains(t, s, "example")
+	assert.Contains(t, s, "token: "+Redacted)
+	assert.Contains(t, s, "password: "+Redacted)
+	// unset secrets are told apart
+	assert.Contains(t, s, `unsubscribe_key: ""`)
+	assert.Contains(t, s, "period: 5m0s")
+	// the configuration printed is not altered
+	assert.Equal(t, "s3cr3t", c.Auth.Token)
+
+	c.Retention.Period = 0
+	assert.Contains(t, c.String(), "period: never")
+}
+
+func TestDatabase_DSN(t *testing.T) {
+	tests := map[string]struct {
+		db       Database
+		expected string
+	}{
+		"default": {
+			db: Default().Database,
+			expected: "host=localhost port=5432 user=postgres password=example dbname=customer sslmode=disable " +
+				"TimeZone=Europe/Warsaw",
+		},
+		"quoted": {
+			db:       Database{Host: "db", Port: 5433, User: "api", Password: `it's a \ pass`, Name: "customer"},
+			expected: `host=db port=5433 user=api password='it\'s a \\ pass' dbname=customer`,
+		},
+		"empty password": {
+			db:       Database{Host: "db", Port: 5432, User: "api", Name: "customer"},
+			expected: `host=db port=5432 user=api password='' dbname=customer`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.db.DSN())
+		})
+	}
+}
+
+// setenv sets an environment variable for the duration of the test
+func setenv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, previous)
+			return
+		}
+		_ = os.Unsetenv(key)
+	})
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv is the environment variable naming the YAML file, unless given by the -config flag
+const FileEnv = "CONFIG_FILE"
+
+type (
+	// setting is a leaf of Config
+	setting struct {
+		// path is the YAML path of the setting, which names its flag too
+		path   string
+		env    string
+		secret bool
+		value  reflect.Value
+	}
+
+	// flagValue holds a flag until the file and the environment are applied, for the flag to override them
+	flagValue struct {
+		set   bool
+		value string
+		// boolean flags may be given without a value
+		boolean bool
+	}
+)
+
+// Load returns the configuration given by the file, the environment and the flags of fs parsed from args, in this
+// order of precedence, over Default. The flags are added to fs, with -config naming the file. The configuration
+// returned is valid, unless an error is returned.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	c := Default()
+	settings := settingsOf(reflect.ValueOf(&c).Elem(), "")
+
+	file := fs.String("config", "", "YAML configuration file, or the "+FileEnv+" environment variable")
+	flags := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		f := &flagValue{boolean: s.value.Kind() == reflect.Bool}
+		if !s.secret {
+			// shown as the default by -help
+			f.value = s.text()
+		}
+		flags[s.path] = f
+		usage := s.env + " environment variable"
+		if s.secret {
+			usage += ", or the file named by " + s.env + "_FILE"
+		}
+		fs.Var(f, s.path, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if *file == "" {
+		*file = os.Getenv(FileEnv)
+	}
+	if *file != "" {
+		if err := loadFile(&c, *file); err != nil {
+			return c, err
+		}
+	}
+	for _, s := range settings {
+		if err := s.fromEnv(); err != nil {
+			return c, err
+		}
+		if f := flags[s.path]; f.set {
+			if err := s.parse(f.value); err != nil {
+				return c, fmt.Errorf("-%s: %w", s.path, err)
+			}
+		}
+	}
+	return c, c.Validate()
+}
+
+// loadFile overrides c with the settings of a YAML file
+func loadFile(c *Config, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// settingsOf lists the leaves of v, a struct whose YAML path is prefix
+func settingsOf(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		path := prefix + strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.Type.Kind() == reflect.Struct {
+			settings = append(settings, settingsOf(v.Field(i), path+".")...)
+			continue
+		}
+		settings = append(settings, setting{
+			path:   path,
+			env:    f.Tag.Get("env"),
+			secret: f.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return settings
+}
+
+// fromEnv overrides the setting with its environment variable, or the file named by its _FILE variable for secrets
+func (s setting) fromEnv() error {
+	value, ok := os.LookupEnv(s.env)
+	if s.secret {
+		if name, file := os.LookupEnv(s.env + "_FILE"); file {
+			if ok {
+				return fmt.Errorf("%s and %s_FILE are both set", s.env, s.env)
+			}
+			content, err := ioutil.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", s.env, err)
+			}
+			// files usually end with a newline, which is not part of the secret
+			value, ok = strings.TrimRight(string(content), "\r\n"), true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if err := s.parse(value); err != nil {
+		return fmt.Errorf("%s: %w", s.env, err)
+	}
+	return nil
+}
+
+// parse sets the setting from its text representation
+func (s setting) parse(text string) error {
+	if u, ok := s.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+	switch {
+	case s.value.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(text)
+	case s.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+		s.value.SetInt(int64(n))
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		s.value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// text is the text representation of the setting, the other way round of parse
+func (s setting) text() string {
+	if m, ok := s.value.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	if d, ok := s.value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(s.value.Interface())
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.set, f.value = true, value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.boolean
+}
@@ -1,30 +1,62 @@
 package cron
 
 import (
-	"api/dao"
-	"api/logging"
+	"api/job"
+	"context"
 	"time"
+)
 
-	"github.com/go-co-op/gocron"
+// Tags of the jobs added by Schedule
+const (
+	TagExpireCustomers = "expire-customers"
+	TagDispatchOutbox  = "dispatch-outbox"
+	TagSendScheduled   = "send-scheduled-mailings"
+	TagDeliverWebhooks = "deliver-webhooks"
+	TagPurgeCustomers  = "purge-customers"
+	TagPruneJobRuns    = "prune-job-runs"
 )
 
-// Scheduler configures and starts the scheduler asynchronously
-func Scheduler() (*gocron.Scheduler, error) {
-	s := gocron.NewScheduler(time.UTC)
-	_, err := s.Every(1).Second().Tag("clean up old entries").Do(deleteOldEntries)
-	if err != nil {
-		return nil, err
+// Tasks are the cron jobs of the service
+type Tasks struct {
+	Dispatcher *Dispatcher
+	Scheduled  *Scheduled
+	Webhooks   *WebhookDispatcher
+	Retention  *Retention
+	Purger     *Purger
+}
+
+// Schedule adds the cron jobs of tasks to jobs, along with the pruning of their history. Every replica campaigns for
+// leadership once jobs are started, and only the leader runs them.
+func Schedule(jobs *Jobs, tasks Tasks) error {
+	for _, j := range []struct {
+		tag      string
+		interval time.Duration
+		run      Job
+	}{
+		{tag: TagExpireCustomers, interval: tasks.Retention.Policy.Interval, run: tasks.Retention.expire},
+		// a run may take longer than the interval while mailing: runs do not overlap
+		{tag: TagDispatchOutbox, interval: 5 * time.Second, run: tasks.Dispatcher.dispatch},
+		{tag: TagSendScheduled, interval: 10 * time.Second, run: tasks.Scheduled.send},
+		{tag: TagDeliverWebhooks, interval: 5 * time.Second, run: tasks.Webhooks.deliver},
+		{tag: TagPurgeCustomers, interval: tasks.Purger.Policy.Interval, run: tasks.Purger.purge},
+		{tag: TagPruneJobRuns, interval: time.Hour, run: jobs.prune},
+	} {
+		if err := jobs.Add(j.tag, j.interval, j.run); err != nil {
+			return err
+		}
 	}
-	s.StartAsync()
-	return s, nil
+	return nil
 }
 
-func deleteOldEntries() {
-	rows, err := dao.DAO.DeleteOld(300)
+// prune removes the runs older than job.HistoryRetention from the history
+func (j *Jobs) prune(ctx context.Context) (int64, error) {
+	pruned, err := j.Runs.Prune(ctx, time.Now().Add(-job.HistoryRetention))
 	if err != nil {
-		logging.ErrorLogger.Printf("CRON: %s", err.Error())
+		j.Log.Error.Printf("CRON: %s", err.Error())
+		return 0, err
 	}
-	if rows != 0 {
-		logging.InfoLogger.Printf("CRON: deleted %d old entries", rows)
+	if pruned != 0 {
+		j.Log.Info.Printf("CRON: pruned %d job runs", pruned)
 	}
+	return pruned, nil
 }
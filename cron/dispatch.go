@@ -0,0 +1,97 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/mail"
+	"api/outbox"
+	"api/suppression"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Dispatcher sends the messages waiting in the outbox
+type Dispatcher struct {
+	Outbox   dao.OutboxDao
+	Mailings dao.MailingDao
+	Log      *logging.Logger
+	Mailer   mail.Mailer
+	// From is the sender address of every message
+	From string
+	// PostSend is applied to a customer once its message was sent
+	PostSend outbox.PostSendAction
+	// BatchSize is the maximum number of messages sent per run
+	BatchSize int
+	// Retry tells when failed messages are attempted again, and when they are given up on
+	Retry outbox.RetryPolicy
+	// Unsubscribe signs the unsubscribe link of every message, appended to UnsubscribeURL. Messages have no
+	// unsubscribe link if it is nil.
+	Unsubscribe    *suppression.Signer
+	UnsubscribeURL string
+}
+
+// dispatch sends the pending messages, and completes the mailings with none left. It returns how many messages were
+// attempted and mailings completed, and the first error met.
+func (d *Dispatcher) dispatch(ctx context.Context) (int64, error) {
+	sent, failed, dispatchErr := d.Outbox.Dispatch(ctx, d.BatchSize, d.Retry, d.PostSend, d.send)
+	if dispatchErr != nil {
+		d.Log.Error.Printf("CRON: %s", dispatchErr.Error())
+	}
+	if sent != 0 || failed != 0 {
+		d.Log.Info.Printf("CRON: sent %d messages, %d failed", sent, failed)
+	}
+
+	completed, err := d.Mailings.Complete(ctx)
+	if err != nil {
+		d.Log.Error.Printf("CRON: %s", err.Error())
+	}
+	if completed != 0 {
+		d.Log.Info.Printf("CRON: %d mailings sent", completed)
+	}
+	if dispatchErr != nil {
+		err = dispatchErr
+	}
+	return int64(sent+failed) + completed, err
+}
+
+func (d *Dispatcher) send(ctx context.Context, m *outbox.Message) error {
+	err := d.Mailer.Send(ctx, &mail.Message{
+		From:    d.From,
+		To:      []string{m.Recipient},
+		Subject: m.Subject,
+		Body:    m.Body,
+		HTML:    m.HTMLBody,
+		Headers: d.headers(m),
+	})
+	if err == nil {
+		d.Log.Info.Printf("%s: CRON: message %d of operation %s sent", m.RequestID, m.ID, m.OperationID)
+		return nil
+	}
+
+	attempt := m.Attempts + 1
+	if errors.Is(err, mail.ErrRejected) {
+		d.Log.Error.Printf("%s: CRON: message %d of operation %s: attempt %d: %s, bounced",
+			m.RequestID, m.ID, m.OperationID, attempt, err.Error())
+		return fmt.Errorf("%w: %s", outbox.ErrBounced, err.Error())
+	}
+	if d.Retry.Exhausted(attempt) {
+		d.Log.Error.Printf("%s: CRON: message %d of operation %s: attempt %d of %d: %s, moved to dead letters",
+			m.RequestID, m.ID, m.OperationID, attempt, d.Retry.MaxAttempts, err.Error())
+	} else {
+		d.Log.Warn.Printf("%s: CRON: message %d of operation %s: attempt %d of %d: %s",
+			m.RequestID, m.ID, m.OperationID, attempt, d.Retry.MaxAttempts, err.Error())
+	}
+	return err
+}
+
+// headers returns the List-Unsubscribe headers of a message (RFC 2369), allowing one-click unsubscription (RFC 8058)
+func (d *Dispatcher) headers(m *outbox.Message) map[string]string {
+	if d.Unsubscribe == nil {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + d.UnsubscribeURL + d.Unsubscribe.Token(m.CustomerID, m.MailingID) + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
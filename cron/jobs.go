@@ -0,0 +1,358 @@
+package cron
+
+import (
+	"api/dao"
+	"api/job"
+	"api/logging"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+const (
+	// SyncInterval is how often every replica applies the job settings changed by the others
+	SyncInterval = 5 * time.Second
+	// DefaultTimeout is how long a run lasts at most, unless Jobs.Timeout tells otherwise
+	DefaultTimeout = 10 * time.Minute
+	// storeTimeout bounds the queries of Jobs itself, syncing the settings and recording the runs
+	storeTimeout = 5 * time.Second
+)
+
+var (
+	// ErrUnknownJob is returned for tags no job was added with
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrRunning is returned when triggering a job this replica is running already
+	ErrRunning = errors.New("job is running already")
+	// ErrNotLeading is returned when triggering a job on a replica which does not lead, as the leader might run it
+	// meanwhile
+	ErrNotLeading = errors.New("this replica does not lead the jobs")
+	// ErrStopped is returned when triggering a job once the scheduler is stopped
+	ErrStopped = errors.New("jobs are stopped")
+)
+
+type (
+	// Job is a task run by the scheduler. It returns how many rows it affected. ctx is done once the run outlasts
+	// Jobs.Timeout, or once Stop gives up waiting for it.
+	Job func(ctx context.Context) (int64, error)
+
+	// Jobs schedules the cron jobs and keeps track of them: they may be listed, triggered, paused and rescheduled at
+	// runtime, and every run of theirs is recorded. Settings changed on one replica are stored, and applied by the
+	// others within SyncInterval.
+	Jobs struct {
+		// Elector tells whether this replica leads, running the scheduled jobs
+		Elector *Elector
+		// Runs stores the settings of the jobs, and the history of their runs
+		Runs dao.JobDao
+		Log  *logging.Logger
+		// Timeout bounds every run
+		Timeout time.Duration
+
+		// ctx is the one runs are derived from, cancelled by Stop
+		ctx       context.Context
+		cancel    context.CancelFunc
+		scheduler *gocron.Scheduler
+		mu        sync.Mutex
+		entries   map[string]*entry
+		// triggered counts the runs triggered by hand, which gocron does not wait for
+		triggered sync.WaitGroup
+		stopped   bool
+	}
+
+	// entry is a job added to Jobs
+	entry struct {
+		tag string
+		run Job
+		// interval is the one the job was added with, used unless its settings override it
+		interval time.Duration
+		settings job.Settings
+		// scheduled is the job as scheduled by gocron, nil while paused
+		scheduled *gocron.Job
+		// running is set while this replica runs the job
+		running int32
+	}
+)
+
+// NewJobs returns a Jobs with no job yet
+func NewJobs(elector *Elector, runs dao.JobDao, log *logging.Logger) *Jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Jobs{
+		Elector:   elector,
+		Runs:      runs,
+		Log:       log,
+		Timeout:   DefaultTimeout,
+		ctx:       ctx,
+		cancel:    cancel,
+		scheduler: gocron.NewScheduler(time.UTC),
+		entries:   map[string]*entry{},
+	}
+}
+
+// Add schedules a job every interval, unless stored settings tell otherwise. Runs do not overlap on a replica.
+func (j *Jobs) Add(tag string, interval time.Duration, run Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := &entry{tag: tag, run: run, interval: interval, settings: job.Settings{Tag: tag}}
+	j.entries[tag] = e
+	return j.schedule(e)
+}
+
+// Start applies the stored settings and starts the scheduler asynchronously, along with the campaign for leadership
+func (j *Jobs) Start() error {
+	j.sync()
+	_, err := j.scheduler.Every(j.Elector.TTL / 3).SingletonMode().Tag("campaign").Do(j.Elector.Campaign)
+	if err != nil {
+		return err
+	}
+	_, err = j.scheduler.Every(SyncInterval).SingletonMode().Tag("sync").Do(j.sync)
+	if err != nil {
+		return err
+	}
+	j.scheduler.StartAsync()
+	return nil
+}
+
+// Stop stops scheduling jobs, and waits for the runs in progress, scheduled or triggered, to be over. It returns
+// the error of ctx if it is done first, cancelling the context of the remaining runs without waiting for them.
+func (j *Jobs) Stop(ctx context.Context) error {
+	j.mu.Lock()
+	j.stopped = true
+	j.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		j.scheduler.Stop()
+		j.triggered.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		j.cancel()
+		return nil
+	case <-ctx.Done():
+		j.cancel()
+		return ctx.Err()
+	}
+}
+
+// List describes every job, by tag
+func (j *Jobs) List(ctx context.Context) ([]job.Info, error) {
+	last, err := j.Runs.LastRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	infos := make([]job.Info, 0, len(j.entries))
+	for _, e := range j.entries {
+		infos = append(infos, e.info(last))
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Tag < infos[b].Tag })
+	return infos, nil
+}
+
+// Has tells whether a job was added with the given tag
+func (j *Jobs) Has(tag string) bool {
+	_, err := j.entry(tag)
+	return err == nil
+}
+
+// Get describes a job. It may return ErrUnknownJob.
+func (j *Jobs) Get(ctx context.Context, tag string) (job.Info, error) {
+	if _, err := j.entry(tag); err != nil {
+		return job.Info{}, err
+	}
+	last, err := j.Runs.LastRuns(ctx)
+	if err != nil {
+		return job.Info{}, err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.entries[tag].info(last), nil
+}
+
+// Trigger runs a job right away on this replica, provided it leads, in the background. It may return ErrUnknownJob,
+// ErrNotLeading, ErrRunning if this replica is running it already, or ErrStopped once Stop was called.
+func (j *Jobs) Trigger(tag string) error {
+	e, err := j.entry(tag)
+	if err != nil {
+		return err
+	}
+	if !j.Elector.Leading() {
+		return ErrNotLeading
+	}
+	if atomic.LoadInt32(&e.running) != 0 {
+		return ErrRunning
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopped {
+		return ErrStopped
+	}
+	j.triggered.Add(1)
+	go func() {
+		defer j.triggered.Done()
+		j.run(e, job.TriggerManual)
+	}()
+	return nil
+}
+
+// Pause stops scheduling a job, on every replica. It may return ErrUnknownJob.
+func (j *Jobs) Pause(ctx context.Context, tag string) error {
+	return j.update(ctx, tag, func(s *job.Settings) { s.Paused = true })
+}
+
+// Resume schedules a paused job again, on every replica. It may return ErrUnknownJob.
+func (j *Jobs) Resume(ctx context.Context, tag string) error {
+	return j.update(ctx, tag, func(s *job.Settings) { s.Paused = false })
+}
+
+// SetInterval reschedules a job every interval, on every replica. It may return ErrUnknownJob.
+func (j *Jobs) SetInterval(ctx context.Context, tag string, interval time.Duration) error {
+	return j.update(ctx, tag, func(s *job.Settings) { s.IntervalSeconds = int64(interval / time.Second) })
+}
+
+// update changes the settings of a job, storing them for the other replicas, and reschedules it
+func (j *Jobs) update(ctx context.Context, tag string, change func(*job.Settings)) error {
+	e, err := j.entry(tag)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := e.settings
+	change(&s)
+	if err := j.Runs.SaveSettings(ctx, &s); err != nil {
+		return err
+	}
+	e.settings = s
+	return j.schedule(e)
+}
+
+// sync applies the settings stored by any replica
+func (j *Jobs) sync() {
+	ctx, cancel := context.WithTimeout(j.ctx, storeTimeout)
+	defer cancel()
+	settings, err := j.Runs.Settings(ctx)
+	if err != nil {
+		j.Log.Error.Printf("CRON: %s", err.Error())
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, s := range settings {
+		e, ok := j.entries[s.Tag]
+		if !ok || (s.Paused == e.settings.Paused && s.IntervalSeconds == e.settings.IntervalSeconds) {
+			continue
+		}
+		e.settings = s
+		if err := j.schedule(e); err != nil {
+			j.Log.Error.Printf("CRON: %s: %s", e.tag, err.Error())
+			continue
+		}
+		j.Log.Info.Printf("CRON: %s rescheduled every %s, paused: %t", e.tag, e.period(), s.Paused)
+	}
+}
+
+// schedule (re)schedules the job of an entry as its settings tell. j.mu must be held.
+func (j *Jobs) schedule(e *entry) error {
+	if e.scheduled != nil {
+		j.scheduler.RemoveByReference(e.scheduled)
+		e.scheduled = nil
+	}
+	if e.settings.Paused {
+		return nil
+	}
+
+	s := j.scheduler.Every(e.period()).SingletonMode().Tag(e.tag)
+	if j.scheduler.IsRunning() {
+		// rescheduled jobs run after the new interval, not right away
+		s = s.WaitForSchedule()
+	}
+	scheduled, err := s.Do(func() {
+		if j.Elector.Leading() {
+			j.run(e, job.TriggerSchedule)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	e.scheduled = scheduled
+	return nil
+}
+
+// run runs the job of an entry unless this replica is running it already, and records the run
+func (j *Jobs) run(e *entry, trigger string) {
+	if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&e.running, 0)
+
+	ctx, cancel := context.WithTimeout(j.ctx, j.Timeout)
+	defer cancel()
+	start := time.Now()
+	rows, err := e.run(ctx)
+	r := job.Run{
+		Job:        e.tag,
+		Replica:    j.Elector.Holder,
+		Trigger:    trigger,
+		StartedAt:  start,
+		DurationMS: time.Since(start).Milliseconds(),
+		Rows:       rows,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	// the run is recorded even if it was cancelled
+	record, cancelRecord := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancelRecord()
+	if err := j.Runs.Record(record, &r); err != nil {
+		j.Log.Error.Printf("CRON: %s: %s", e.tag, err.Error())
+	}
+}
+
+func (j *Jobs) entry(tag string) (*entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownJob, tag)
+	}
+	return e, nil
+}
+
+// period is the time between two runs of the job
+func (e *entry) period() time.Duration {
+	if e.settings.IntervalSeconds > 0 {
+		return time.Duration(e.settings.IntervalSeconds) * time.Second
+	}
+	return e.interval
+}
+
+// info describes the job, last holding the last run of every job
+func (e *entry) info(last map[string]job.Run) job.Info {
+	i := job.Info{
+		Tag:      e.tag,
+		Interval: e.period().String(),
+		Paused:   e.settings.Paused,
+		Running:  atomic.LoadInt32(&e.running) != 0,
+	}
+	if e.scheduled != nil && !e.scheduled.NextRun().IsZero() {
+		next := e.scheduled.NextRun()
+		i.NextRun = &next
+	}
+	if r, ok := last[e.tag]; ok {
+		i.LastRun = &r
+	}
+	return i
+}
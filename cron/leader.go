@@ -0,0 +1,73 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"context"
+	"sync"
+	"time"
+)
+
+// Elector campaigns for the leadership of the cron jobs, so that only one replica runs them. The leader renews its
+// lease every third of its TTL; if it dies, another replica takes over once the lease expired.
+type Elector struct {
+	Leases dao.LeaseDao
+	Log    *logging.Logger
+	// Lease is the name of the lease whose holder leads
+	Lease string
+	// Holder identifies this replica
+	Holder string
+	// TTL is how long leadership lasts without being renewed
+	TTL time.Duration
+
+	mu sync.Mutex
+	// until is when this replica stops leading unless it renews its lease, by the local clock
+	until time.Time
+}
+
+// Leading tells whether this replica leads right now
+func (e *Elector) Leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.until)
+}
+
+// Resign gives up the leadership, so that another replica takes over without waiting for the lease to expire
+func (e *Elector) Resign() error {
+	e.mu.Lock()
+	e.until = time.Time{}
+	e.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), e.TTL/3)
+	defer cancel()
+	return e.Leases.Release(ctx, e.Lease, e.Holder)
+}
+
+// Campaign takes or renews the lease, as Jobs.Start does every third of the TTL. Leadership is counted from before
+// asking for it, so that this replica stops leading before the lease expires in the database.
+func (e *Elector) Campaign() {
+	start := time.Now()
+	// the next campaign is due by then
+	ctx, cancel := context.WithTimeout(context.Background(), e.TTL/3)
+	defer cancel()
+	acquired, err := e.Leases.Acquire(ctx, e.Lease, e.Holder, e.TTL)
+	if err != nil {
+		// the lease is kept until it expires: the database may be back before then
+		e.Log.Error.Printf("CRON: %s: %s", e.Holder, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	leading := start.Before(e.until)
+	switch {
+	case acquired && !leading:
+		e.Log.Info.Printf("CRON: %s leads %s", e.Holder, e.Lease)
+	case !acquired && leading:
+		e.Log.Warn.Printf("CRON: %s no longer leads %s", e.Holder, e.Lease)
+	}
+	if acquired {
+		e.until = start.Add(e.TTL)
+	} else {
+		e.until = time.Time{}
+	}
+}
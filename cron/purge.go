@@ -0,0 +1,28 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/retention"
+	"context"
+	"time"
+)
+
+// Purger deletes for good the customers soft deleted longer than a grace period ago
+type Purger struct {
+	Customers dao.CustomerDao
+	Log       *logging.Logger
+	Policy    retention.Purge
+}
+
+func (p *Purger) purge(ctx context.Context) (int64, error) {
+	before := time.Now().Add(-p.Policy.GracePeriod)
+	purged, err := p.Customers.Purge(ctx, before, p.Policy.BatchSize)
+	if err != nil {
+		p.Log.Error.Printf("CRON: purge: %s", err.Error())
+	}
+	if purged != 0 {
+		p.Log.Info.Printf("CRON: purged %d customers deleted before %s", purged, before.Format(time.RFC3339))
+	}
+	return purged, err
+}
@@ -0,0 +1,32 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/retention"
+	"api/tools"
+	"context"
+)
+
+// Retention deletes the customers whose retention is over
+type Retention struct {
+	Customers dao.CustomerDao
+	Log       *logging.Logger
+	Policy    retention.Policy
+}
+
+func (r *Retention) expire(ctx context.Context) (int64, error) {
+	operationID, err := tools.GenerateUUID4()
+	if err != nil {
+		r.Log.Error.Printf("CRON: %s", err.Error())
+		return 0, err
+	}
+	rows, err := r.Customers.DeleteExpired(ctx, operationID, r.Policy.Period)
+	if err != nil {
+		r.Log.Error.Printf("%s: CRON: %s", operationID, err.Error())
+	}
+	if rows != 0 {
+		r.Log.Info.Printf("%s: CRON: deleted %d expired customers", operationID, rows)
+	}
+	return rows, err
+}
@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/tools"
+	"context"
+	"errors"
+	"time"
+)
+
+// Scheduled sends the scheduled mailings once due
+type Scheduled struct {
+	Mailings dao.MailingDao
+	Log      *logging.Logger
+}
+
+// send sends the scheduled mailings that are due. A mailing whose templates cannot be rendered is turned
+// back into a draft, recording why; on a database error it is attempted again on the next run. It returns how many
+// mailings were sent, and the last database error met.
+func (s *Scheduled) send(ctx context.Context) (int64, error) {
+	now := time.Now()
+	due, err := s.Mailings.Due(ctx, now)
+	if err != nil {
+		s.Log.Error.Printf("CRON: %s", err.Error())
+		return 0, err
+	}
+
+	var (
+		sent    int64
+		lastErr error
+	)
+	for _, m := range due {
+		id := int64(m.ID)
+		operationID, err := tools.GenerateUUID4()
+		if err != nil {
+			s.Log.Error.Printf("CRON: %s", err.Error())
+			return sent, err
+		}
+
+		queued, err := s.Mailings.SendDue(ctx, operationID, id, now)
+		switch {
+		case err == nil:
+			sent++
+			s.Log.Info.Printf("%s: CRON: scheduled mailing %d sent, %d messages queued", operationID, id, queued)
+		case errors.Is(err, dao.ErrInvalidState):
+			// sent, rescheduled or cancelled since it was found due, maybe by another instance
+			s.Log.Info.Printf("%s: CRON: scheduled mailing %d skipped: %s", operationID, id, err.Error())
+		case errors.Is(err, dao.ErrTemplate):
+			s.Log.Error.Printf("%s: CRON: scheduled mailing %d failed: %s", operationID, id, err.Error())
+			if err := s.Mailings.Fail(ctx, id, err.Error()); err != nil {
+				s.Log.Error.Printf("%s: CRON: %s", operationID, err.Error())
+				lastErr = err
+			}
+		default:
+			s.Log.Error.Printf("%s: CRON: scheduled mailing %d: %s", operationID, id, err.Error())
+			lastErr = err
+		}
+	}
+	return sent, lastErr
+}
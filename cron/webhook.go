@@ -0,0 +1,50 @@
+package cron
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/outbox"
+	"api/webhook"
+	"context"
+)
+
+// WebhookDispatcher posts the pending webhook deliveries to their subscribers
+type WebhookDispatcher struct {
+	Webhooks dao.WebhookDao
+	Log      *logging.Logger
+	Sender   *webhook.Sender
+	// BatchSize is the maximum number of deliveries attempted per run
+	BatchSize int
+	// Retry tells when failed deliveries are attempted again, and when they are given up on
+	Retry outbox.RetryPolicy
+}
+
+func (w *WebhookDispatcher) deliver(ctx context.Context) (int64, error) {
+	delivered, failed, err := w.Webhooks.Deliver(ctx, w.BatchSize, w.Retry, w.send)
+	if err != nil {
+		w.Log.Error.Printf("CRON: %s", err.Error())
+	}
+	if delivered != 0 || failed != 0 {
+		w.Log.Info.Printf("CRON: delivered %d webhook events, %d failed", delivered, failed)
+	}
+	return int64(delivered + failed), err
+}
+
+func (w *WebhookDispatcher) send(ctx context.Context, s *webhook.Subscription, d *webhook.Delivery) (int, error) {
+	status, err := w.Sender.Send(ctx, s, d)
+	if err == nil {
+		w.Log.Info.Printf("%s: CRON: %s event %s delivered to subscription %d", d.RequestID, d.EventType,
+			d.Event.ID, s.ID)
+		return status, nil
+	}
+
+	attempt := d.Attempts + 1
+	if w.Retry.Exhausted(attempt) {
+		w.Log.Error.Printf("%s: CRON: delivery %d to subscription %d: attempt %d of %d: %s, given up on",
+			d.RequestID, d.ID, s.ID, attempt, w.Retry.MaxAttempts, err.Error())
+	} else {
+		w.Log.Warn.Printf("%s: CRON: delivery %d to subscription %d: attempt %d of %d: %s",
+			d.RequestID, d.ID, s.ID, attempt, w.Retry.MaxAttempts, err.Error())
+	}
+	return status, err
+}
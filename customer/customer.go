@@ -1,6 +1,10 @@
 package customer
 
 import (
+	"encoding/json"
+	"fmt"
+	"time"
+
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"gorm.io/gorm"
@@ -13,6 +17,10 @@ type (
 		Title     string `json:"title,omitempty" gorm:"uniqueIndex:idx_multi"`
 		Content   string `json:"content,omitempty" gorm:"uniqueIndex:idx_multi"`
 		MailingID int64  `json:"mailing_id,omitempty" gorm:"uniqueIndex:idx_multi"`
+		// ExpiresAt is when the customer is deleted, whatever the retention policy says
+		ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"index"`
+		// LegalHold keeps the customer from ever expiring
+		LegalHold bool `json:"legal_hold,omitempty" gorm:"not null;default:false"`
 	}
 )
 
@@ -23,3 +31,21 @@ func (c *Customer) Validate() error {
 		"content": validation.Validate(c.Content, validation.Length(0, 150)),
 	}.Filter()
 }
+
+// ETag identifies the current version of the customer. It changes on every update.
+func (c *Customer) ETag() string {
+	return fmt.Sprintf(`"%d"`, c.UpdatedAt.UnixNano()/1000)
+}
+
+// MarshalJSON adds deleted_at, null unless the customer is soft deleted, to the fields of the customer
+func (c Customer) MarshalJSON() ([]byte, error) {
+	type plain Customer
+	var deletedAt *time.Time
+	if c.DeletedAt.Valid {
+		deletedAt = &c.DeletedAt.Time
+	}
+	return json.Marshal(struct {
+		plain
+		DeletedAt *time.Time `json:"deleted_at"`
+	}{plain(c), deletedAt})
+}
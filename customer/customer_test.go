@@ -1,12 +1,15 @@
 package customer
 
 import (
+	"encoding/json"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 type mockCustomer struct {
@@ -81,3 +84,44 @@ func TestCustomer_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomer_ETag(t *testing.T) {
+	c := Customer{}
+	c.UpdatedAt = time.Date(2023, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	assert.Equal(t, `"1677664800123456"`, c.ETag())
+
+	c.UpdatedAt = c.UpdatedAt.Add(time.Microsecond)
+	assert.Equal(t, `"1677664800123457"`, c.ETag())
+}
+
+func TestCustomer_MarshalJSON(t *testing.T) {
+	deletedAt := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		customer          Customer
+		expectedDeletedAt interface{}
+	}{
+		"not deleted": {
+			customer: Customer{Model: gorm.Model{ID: 1}, Email: "hello@example.com"},
+		},
+		"soft deleted": {
+			customer: Customer{Model: gorm.Model{ID: 1, DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}},
+				Email: "hello@example.com"},
+			expectedDeletedAt: "2023-03-01T10:00:00Z",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			js, err := json.Marshal(test.customer)
+			require.NoError(t, err)
+
+			var fields map[string]interface{}
+			require.NoError(t, json.Unmarshal(js, &fields))
+			assert.Contains(t, fields, "deleted_at")
+			assert.Equal(t, test.expectedDeletedAt, fields["deleted_at"])
+			assert.Equal(t, float64(1), fields["ID"])
+			assert.Contains(t, fields, "CreatedAt")
+			assert.Contains(t, fields, "UpdatedAt")
+			assert.Equal(t, "hello@example.com", fields["email"])
+		})
+	}
+}
@@ -2,96 +2,252 @@ package dao
 
 import (
 	"api/customer"
+	"api/migration"
 	"api/postgresql"
+	"api/suppression"
+	"api/webhook"
+	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 type (
+	// CustomerDao stores the customers. Its statements are cancelled once the context they are given is done, failing
+	// with ErrTimeout.
 	CustomerDao interface {
-		// Create creates a new customer.Customer in the database. It may return ErrPgIndex or a generic ErrPg.
-		Create(*customer.Customer) error
-
-		// Delete deletes a customer.Customer from the database. It may return ErrPg.
-		Delete(*customer.Customer, int64) error
-
-		// MigrateModels applies any possible modifications to the underlying database schema. It may return ErrPg.
-		MigrateModels() error
-
-		// First retrieves customer.Customer by primary key. It may return ErrPg.
-		First(int64) (*customer.Customer, error)
-
-		// Find retrieves all customer.Customer. It may return ErrPg
-		Find() ([]customer.Customer, error)
-
-		// DeleteOld handles removal of database entries older than 5 minutes
-		DeleteOld(int) (int64, error)
-
-		// DeleteByMailingID deletes all customers with the given mailingID
-		DeleteByMailingID(int64) (int64, error)
+		// Create creates a new customer.Customer in the database, unless its email address is suppressed, and fires
+		// a customer.created webhook event traced by requestID. It may return ErrSuppressed, ErrConflict or any
+		// other *Error.
+		Create(ctx context.Context, requestID string, c *customer.Customer) error
+
+		// CreateBatch creates all the given customer.Customer in a single transaction, firing a customer.created
+		// webhook event for each. On error nothing is created, and the index of the offending customer is returned,
+		// or -1 if none is to blame. It may return ErrSuppressed, ErrConflict or any other *Error.
+		CreateBatch(ctx context.Context, requestID string, cs []*customer.Customer) (int, error)
+
+		// Delete deletes a customer.Customer from the database, and fires a customer.deleted webhook event traced by
+		// requestID unless it was deleted already. It may return an *Error.
+		Delete(ctx context.Context, requestID string, c *customer.Customer, id int64) error
+
+		// Close closes the connections to the database, once done with it. It may return an *Error.
+		Close() error
+
+		// First retrieves customer.Customer by primary key. It may return ErrNotFound or any other *Error.
+		First(ctx context.Context, id int64) (*customer.Customer, error)
+
+		// Find retrieves a page of customer.Customer matching the given FindParams, along with the cursor
+		// of the next page, empty on the last one. It may return ErrInvalidQuery or an *Error
+		Find(ctx context.Context, params FindParams) ([]customer.Customer, string, error)
+
+		// DeleteExpired deletes the customers whose retention is over, see retention.Policy, firing a
+		// customer.expired webhook event for each, traced by operationID. period is the global retention period,
+		// zero keeping the customers it applies to forever.
+		DeleteExpired(ctx context.Context, operationID string, period time.Duration) (int64, error)
+
+		// Purge deletes for good the customers soft deleted before the given time, along with their memberships,
+		// messages and dead letters, batchSize customers per transaction. Customers under legal hold are kept. It
+		// returns how many were purged, even if a batch failed.
+		Purge(ctx context.Context, before time.Time, batchSize int) (int64, error)
+
+		// Restore undoes the soft delete of a customer.Customer. It may return ErrNotFound if there is
+		// no deleted customer with the given id, or any other *Error.
+		Restore(ctx context.Context, id int64) error
+
+		// Update overwrites the editable fields of a customer.Customer, provided it was last updated at the given time.
+		// It may return ErrStale, ErrConflict or any other *Error.
+		Update(ctx context.Context, c *customer.Customer, updatedAt time.Time) error
 	}
 
 	CustomerDAO struct {
 		Db postgresql.Db
 	}
+
+	// Store gathers the data access objects of a database
+	Store struct {
+		Customers    CustomerDao
+		Outbox       OutboxDao
+		Mailings     MailingDao
+		Suppressions SuppressionDao
+		Webhooks     WebhookDao
+		Leases       LeaseDao
+		Jobs         JobDao
+		Migrations   MigrationDao
+	}
 )
 
 var (
-	DAO        CustomerDao = &CustomerDAO{Db: postgresql.DB}
-	ErrPgIndex             = errors.New("duplicate key value for Tx index")
-	ErrPg                  = errors.New("database error")
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrStale        = errors.New("customer was modified or deleted concurrently")
+	ErrSuppressed   = errors.New("email address is suppressed")
 )
 
-func (dao *CustomerDAO) MigrateModels() error {
-	return dao.Db.Migrate(&customer.Customer{})
+// New returns the data access objects of db, whose schema is brought up to date by the given migrations
+func New(db postgresql.Db, migrations []migration.Migration) *Store {
+	return &Store{
+		Customers:    &CustomerDAO{Db: db},
+		Outbox:       &OutboxDAO{Db: db},
+		Mailings:     &MailingDAO{Db: db},
+		Suppressions: &SuppressionDAO{Db: db},
+		Webhooks:     &WebhookDAO{Db: db},
+		Leases:       &LeaseDAO{Db: db},
+		Jobs:         &JobDAO{Db: db},
+		Migrations:   &MigrationDAO{Db: db, Migrations: migrations},
+	}
+}
+
+func (dao *CustomerDAO) Close() error {
+	return wrap("close", dao.Db.Close())
 }
 
-func (dao *CustomerDAO) Create(c *customer.Customer) error {
-	if tx := dao.Db.Create(c); tx.Error != nil {
-		if strings.Contains(tx.Error.Error(), "duplicate key value violates unique constraint") {
-			return fmt.Errorf("%w: %s", ErrPgIndex, tx.Error.Error())
+func (dao *CustomerDAO) Create(ctx context.Context, requestID string, c *customer.Customer) error {
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		if err := create(db, c); err != nil {
+			return err
 		}
-		return fmt.Errorf("%w: %s", ErrPg, tx.Error.Error())
-	}
-	return nil
+		return enqueue(db, webhook.EventCustomerCreated, requestID, c)
+	})
 }
 
-func (dao *CustomerDAO) Delete(c *customer.Customer, id int64) error {
-	if tx := dao.Db.Delete(c, id); tx.Error != nil {
-		return fmt.Errorf("%w: delete: %s", ErrPg, tx.Error.Error())
+func (dao *CustomerDAO) CreateBatch(ctx context.Context, requestID string, cs []*customer.Customer) (int, error) {
+	failed := -1
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		created := make([]interface{}, 0, len(cs))
+		for i, c := range cs {
+			if err := create(db, c); err != nil {
+				failed = i
+				return err
+			}
+			created = append(created, c)
+		}
+		return enqueue(db, webhook.EventCustomerCreated, requestID, created...)
+	})
+	if err != nil && failed < 0 {
+		return failed, wrap("create batch", err)
 	}
-	return nil
+	return failed, err
+}
+
+func (dao *CustomerDAO) Delete(ctx context.Context, requestID string, c *customer.Customer, id int64) error {
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		tx := db.Delete(c, id)
+		if tx.Error != nil {
+			return wrap("delete", tx.Error)
+		}
+		if tx.RowsAffected == 0 {
+			return nil
+		}
+		return enqueue(db, webhook.EventCustomerDeleted, requestID, c)
+	})
 }
 
-func (dao *CustomerDAO) First(id int64) (*customer.Customer, error) {
-	c, tx := dao.Db.First(id)
+func (dao *CustomerDAO) First(ctx context.Context, id int64) (*customer.Customer, error) {
+	c, tx := dao.Db.WithContext(ctx).First(id)
 	if tx.Error != nil {
-		return nil, fmt.Errorf("%w: first: %s", ErrPg, tx.Error.Error())
+		return nil, wrap("first", tx.Error)
 	}
 	return &c, nil
 }
 
-func (dao *CustomerDAO) Find() ([]customer.Customer, error) {
-	customers, tx := dao.Db.Find()
+func (dao *CustomerDAO) DeleteExpired(ctx context.Context, operationID string, period time.Duration) (int64, error) {
+	var deleted int64
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		cs, tx := db.DeleteExpired(period)
+		if tx.Error != nil {
+			return wrap("delete expired", tx.Error)
+		}
+		deleted = tx.RowsAffected
+		expired := make([]interface{}, 0, len(cs))
+		for i := range cs {
+			expired = append(expired, &cs[i])
+		}
+		return enqueue(db, webhook.EventCustomerExpired, operationID, expired...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+func (dao *CustomerDAO) Purge(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	var purged int64
+	for {
+		var ids []uint
+		err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+			var tx *gorm.DB
+			if ids, tx = db.Purge(before, batchSize); tx.Error != nil {
+				return wrap("purge", tx.Error)
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+			if tx := db.DeleteMemberships(ids); tx.Error != nil {
+				return wrap("purge memberships", tx.Error)
+			}
+			if tx := db.DeleteDeadLettersTo(ids); tx.Error != nil {
+				return wrap("purge dead letters", tx.Error)
+			}
+			if tx := db.DeleteMessagesTo(ids); tx.Error != nil {
+				return wrap("purge messages", tx.Error)
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(len(ids))
+		// a short batch is the last one
+		if len(ids) < batchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (dao *CustomerDAO) Restore(ctx context.Context, id int64) error {
+	tx := dao.Db.WithContext(ctx).Restore(id)
 	if tx.Error != nil {
-		return nil, fmt.Errorf("%w: find: %s", ErrPg, tx.Error.Error())
+		return wrap("restore", tx.Error)
 	}
-	return customers, nil
+	if tx.RowsAffected == 0 {
+		return &Error{Kind: ErrNotFound, Op: "restore", Err: fmt.Errorf("no deleted customer with id %d", id)}
+	}
+	return nil
 }
 
-func (dao *CustomerDAO) DeleteOld(seconds int) (int64, error) {
-	tx := dao.Db.DeleteOld(seconds)
+func (dao *CustomerDAO) Update(ctx context.Context, c *customer.Customer, updatedAt time.Time) error {
+	tx := dao.Db.WithContext(ctx).Update(c, updatedAt)
 	if tx.Error != nil {
-		return tx.RowsAffected, fmt.Errorf("%w: delete old: %s", ErrPg, tx.Error.Error())
+		return wrap("update", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrStale
 	}
-	return tx.RowsAffected, tx.Error
+	return nil
 }
 
-func (dao *CustomerDAO) DeleteByMailingID(mailingID int64) (int64, error) {
-	tx := dao.Db.DeleteByMailingID(mailingID)
+// create inserts a customer, unless its email address is suppressed, joining it to its mailing if that exists
+func create(db postgresql.Db, c *customer.Customer) error {
+	_, tx := db.FirstSuppression(suppression.Normalize(c.Email))
+	if tx.Error == nil {
+		return fmt.Errorf("%w: %s", ErrSuppressed, c.Email)
+	}
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return wrap("first suppression", tx.Error)
+	}
+	if err := db.Create(c).Error; err != nil {
+		return wrap("create", err)
+	}
+	if c.MailingID == 0 {
+		return nil
+	}
+	_, tx = db.FirstMailing(c.MailingID)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
 	if tx.Error != nil {
-		return tx.RowsAffected, fmt.Errorf("%w: delete old: %s", ErrPg, tx.Error.Error())
+		return wrap("first mailing", tx.Error)
 	}
-	return tx.RowsAffected, tx.Error
+	return wrap("add members", db.AddMembers(c.MailingID, []uint{c.ID}).Error)
 }
@@ -2,12 +2,19 @@ package dao
 
 import (
 	"api/customer"
+	"api/mailing"
 	"api/postgresql"
+	"api/suppression"
+	"api/webhook"
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"gorm.io/gorm"
 
@@ -25,32 +32,122 @@ func TestDao_Create(t *testing.T) {
 		"OK": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+				m.On("Subscribers", webhook.EventCustomerCreated).Return([]webhook.Subscription(nil), nil)
+				return &m
+			}(),
+		},
+		"OK with subscribers": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
 				m.On("Create", mock.Anything).Return(nil)
+				m.On("Subscribers", webhook.EventCustomerCreated).Return([]webhook.Subscription{{ID: 3}, {ID: 4}}, nil)
+				m.On("CreateDeliveries", mock.MatchedBy(func(ds []webhook.Delivery) bool {
+					return len(ds) == 2 && ds[0].SubscriptionID == 3 && ds[1].SubscriptionID == 4 &&
+						ds[0].RequestID == "req" && ds[0].Event.Type == webhook.EventCustomerCreated &&
+						ds[0].Status == webhook.StatusPending && ds[0].Event.ID == ds[1].Event.ID
+				})).Return(nil)
 				return &m
 			}(),
 		},
+		"subscribers error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+				m.On("Subscribers", webhook.EventCustomerCreated).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
 		"index error": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("Create", mock.Anything).Return(fmt.Errorf("duplicate key value violates unique constraint"))
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(&pgconn.PgError{Code: "23505", ConstraintName: "idx_multi"})
 				return &m
 			}(),
-			withError: ErrPgIndex,
+			withError: ErrConflict,
 		},
 		"other error": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
 				m.On("Create", mock.Anything).Return(fmt.Errorf("other error"))
 				return &m
 			}(),
 			withError: ErrPg,
 		},
+		"OK with mailing": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+				m.On("FirstMailing", int64(5)).Return(mailing.Mailing{ID: 5}, nil)
+				m.On("AddMembers", int64(5), []uint{7}).Return(int64(1), nil)
+				m.On("Subscribers", webhook.EventCustomerCreated).Return([]webhook.Subscription(nil), nil)
+				return &m
+			}(),
+			input: customer.Customer{Model: gorm.Model{ID: 7}, MailingID: 5},
+		},
+		"OK with missing mailing": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+				m.On("FirstMailing", int64(5)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+				m.On("Subscribers", webhook.EventCustomerCreated).Return([]webhook.Subscription(nil), nil)
+				return &m
+			}(),
+			input: customer.Customer{Model: gorm.Model{ID: 7}, MailingID: 5},
+		},
+		"add members error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+				m.On("FirstMailing", int64(5)).Return(mailing.Mailing{ID: 5}, nil)
+				m.On("AddMembers", int64(5), []uint{7}).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			input:     customer.Customer{Model: gorm.Model{ID: 7}, MailingID: 5},
+			withError: ErrPg,
+		},
+		"suppressed": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", "hello@example.com").Return(suppression.Suppression{Email: "hello@example.com"}, nil)
+				return &m
+			}(),
+			input:     customer.Customer{Email: " Hello@Example.com"},
+			withError: ErrSuppressed,
+		},
+		"suppression error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
 	}
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			err := dao.Create(&test.input)
+			err := dao.Create(context.Background(), "req", &test.input)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.True(t, errors.Is(err, test.withError))
@@ -62,7 +159,7 @@ func TestDao_Create(t *testing.T) {
 	}
 }
 
-func TestDao_MigrateModels(t *testing.T) {
+func TestCustomerDAO_Close(t *testing.T) {
 	tests := map[string]struct {
 		db        *postgresql.DataBaseMock
 		withError *regexp.Regexp
@@ -70,29 +167,29 @@ func TestDao_MigrateModels(t *testing.T) {
 		"OK": {
 			db: func() *postgresql.DataBaseMock {
 				m := &postgresql.DataBaseMock{}
-				m.On("Migrate", mock.Anything).Return(nil)
+				m.On("Close").Return(nil)
 				return m
 			}(),
 		},
 		"not OK": {
 			db: func() *postgresql.DataBaseMock {
 				m := &postgresql.DataBaseMock{}
-				m.On("Migrate", mock.Anything).Return(fmt.Errorf("an error"))
+				m.On("Close").Return(fmt.Errorf("an error"))
 				return m
 			}(),
-			withError: regexp.MustCompile("an error"),
+			withError: regexp.MustCompile("close: an error"),
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			err := dao.MigrateModels()
+			err := dao.Close()
+			test.db.AssertExpectations(t)
 			if test.withError != nil {
-				assert.Regexp(t, err, test.withError)
+				assert.Regexp(t, test.withError, err.Error())
 				return
 			}
 			require.NoError(t, err)
-			test.db.AssertExpectations(t)
 		})
 	}
 }
@@ -105,14 +202,25 @@ func TestCustomerDAO_Delete(t *testing.T) {
 		"OK deletion": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("Delete", mock.Anything, mock.Anything).Return(nil)
+				m.On("Transaction").Return()
+				m.On("Delete", mock.Anything, mock.Anything).Return(int64(1), nil)
+				m.On("Subscribers", webhook.EventCustomerDeleted).Return([]webhook.Subscription(nil), nil)
+				return &m
+			}(),
+		},
+		"deleted already": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("Delete", mock.Anything, mock.Anything).Return(int64(0), nil)
 				return &m
 			}(),
 		},
 		"NOK deletion": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("Delete", mock.Anything, mock.Anything).Return(fmt.Errorf("an error"))
+				m.On("Transaction").Return()
+				m.On("Delete", mock.Anything, mock.Anything).Return(int64(0), fmt.Errorf("an error"))
 				return &m
 			}(),
 			withError: ErrPg,
@@ -121,7 +229,7 @@ func TestCustomerDAO_Delete(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			err := dao.Delete(&customer.Customer{}, 1)
+			err := dao.Delete(context.Background(), "req", &customer.Customer{}, 1)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.True(t, errors.Is(err, test.withError))
@@ -166,12 +274,20 @@ func TestCustomerDAO_First(t *testing.T) {
 			}(),
 			withError: regexp.MustCompile("database error: first: an error"),
 		},
+		"not ok, not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("First", int64(1)).Return(nil, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: regexp.MustCompile("record not found: first: record not found"),
+		},
 	}
 
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			c, err := dao.First(1)
+			c, err := dao.First(context.Background(), 1)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.Regexp(t, test.withError, err.Error())
@@ -190,33 +306,118 @@ func TestCustomerDAO_First(t *testing.T) {
 }
 
 func TestCustomerDAO_Find(t *testing.T) {
+	first := customer.Customer{
+		Email:     "oroparece@platano.es",
+		Title:     "a client",
+		MailingID: 1,
+		Model:     gorm.Model{ID: 1, CreatedAt: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	second := customer.Customer{
+		Email:     "hello@example.com",
+		Title:     "another client",
+		MailingID: 1,
+		Model:     gorm.Model{ID: 2, CreatedAt: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	nextCursor, err := encodeCursor(DefaultSort, first)
+	require.NoError(t, err)
+
 	tests := map[string]struct {
+		params    FindParams
 		db        *postgresql.DataBaseMock
 		withError *regexp.Regexp
 		expected  []customer.Customer
+		next      string
 	}{
 		"ok": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("Find").Return([]customer.Customer{{
-					Email:     "oroparece@platano.es",
-					Title:     "a client",
-					MailingID: 1,
-					Model:     gorm.Model{ID: 1},
-				}}, nil).Once()
+				m.On("Find", postgresql.FindQuery{
+					Sort:  []postgresql.SortKey{{Column: "created_at"}, {Column: "id"}},
+					Limit: DefaultLimit + 1,
+				}).Return([]customer.Customer{first}, nil).Once()
 				return &m
 			}(),
-			expected: []customer.Customer{{
-				Email:     "oroparece@platano.es",
-				Title:     "a client",
-				MailingID: 1,
-				Model:     gorm.Model{ID: 1},
-			}},
+			expected: []customer.Customer{first},
+		},
+		"ok, next page": {
+			params: FindParams{Limit: 1},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Find", mock.Anything).Return([]customer.Customer{first, second}, nil).Once()
+				return &m
+			}(),
+			expected: []customer.Customer{first},
+			next:     nextCursor,
+		},
+		"ok, from cursor": {
+			params: FindParams{Limit: 1, Cursor: nextCursor},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Find", postgresql.FindQuery{
+					Sort:  []postgresql.SortKey{{Column: "created_at"}, {Column: "id"}},
+					After: []interface{}{first.CreatedAt, first.ID},
+					Limit: 2,
+				}).Return([]customer.Customer{second}, nil).Once()
+				return &m
+			}(),
+			expected: []customer.Customer{second},
+		},
+		"ok, filters and sort": {
+			params: FindParams{Email: "hello@example.com", MailingID: &second.MailingID, Sort: "-mailing_id,title"},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Find", postgresql.FindQuery{
+					Email:     "hello@example.com",
+					MailingID: &second.MailingID,
+					Sort:      []postgresql.SortKey{{Column: "mailing_id", Desc: true}, {Column: "title"}, {Column: "id"}},
+					Limit:     DefaultLimit + 1,
+				}).Return([]customer.Customer{second}, nil).Once()
+				return &m
+			}(),
+			expected: []customer.Customer{second},
+		},
+		"ok, only deleted": {
+			params: FindParams{IncludeDeleted: "only"},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Find", postgresql.FindQuery{
+					Deleted: postgresql.OnlyDeleted,
+					Sort:    []postgresql.SortKey{{Column: "created_at"}, {Column: "id"}},
+					Limit:   DefaultLimit + 1,
+				}).Return([]customer.Customer{first}, nil).Once()
+				return &m
+			}(),
+			expected: []customer.Customer{first},
+		},
+		"unknown include_deleted": {
+			params:    FindParams{IncludeDeleted: "maybe"},
+			db:        &postgresql.DataBaseMock{},
+			withError: regexp.MustCompile("invalid query: include_deleted must be true, false or only"),
+		},
+		"unknown sort column": {
+			params:    FindParams{Sort: "content"},
+			db:        &postgresql.DataBaseMock{},
+			withError: regexp.MustCompile(`invalid query: cannot sort by "content"`),
+		},
+		"limit too big": {
+			params:    FindParams{Limit: MaxLimit + 1},
+			db:        &postgresql.DataBaseMock{},
+			withError: regexp.MustCompile("invalid query: limit must be between 1 and 500"),
+		},
+		"malformed cursor": {
+			params:    FindParams{Cursor: "!"},
+			db:        &postgresql.DataBaseMock{},
+			withError: regexp.MustCompile("invalid query: malformed cursor"),
+		},
+		"cursor from another sort": {
+			params:    FindParams{Cursor: nextCursor, Sort: "email"},
+			db:        &postgresql.DataBaseMock{},
+			withError: regexp.MustCompile(`invalid query: cursor does not match sort "email"`),
 		},
 		"db returns an error": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("Find").Return([]customer.Customer{}, fmt.Errorf("an error")).Once()
+				m.On("Find", mock.Anything).Return([]customer.Customer{}, fmt.Errorf("an error")).Once()
 				return &m
 			}(),
 			withError: regexp.MustCompile("database error: find: an error"),
@@ -225,7 +426,7 @@ func TestCustomerDAO_Find(t *testing.T) {
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			cs, err := dao.Find()
+			cs, next, err := dao.Find(context.Background(), test.params)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.Regexp(t, test.withError, err.Error())
@@ -233,13 +434,14 @@ func TestCustomerDAO_Find(t *testing.T) {
 			}
 			require.NoError(t, err)
 			assert.True(t, reflect.DeepEqual(cs, test.expected))
+			assert.Equal(t, test.next, next)
 
 			test.db.AssertExpectations(t)
 		})
 	}
 }
 
-func TestDeleteOld(t *testing.T) {
+func TestDeleteExpired(t *testing.T) {
 	tests := map[string]struct {
 		withError    *regexp.Regexp
 		db           *postgresql.DataBaseMock
@@ -248,24 +450,38 @@ func TestDeleteOld(t *testing.T) {
 		"ok": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("DeleteOld", 1).Return(int64(1), nil)
+				m.On("Transaction").Return()
+				m.On("DeleteExpired", time.Hour).Return([]customer.Customer{{Model: gorm.Model{ID: 1}}}, nil)
+				m.On("Subscribers", webhook.EventCustomerExpired).Return([]webhook.Subscription{{ID: 3}}, nil)
+				m.On("CreateDeliveries", mock.MatchedBy(func(ds []webhook.Delivery) bool {
+					return len(ds) == 1 && ds[0].RequestID == "op" && string(ds[0].Event.Data) != ""
+				})).Return(nil)
 				return &m
 			}(),
 			rowsAffected: 1,
 		},
+		"nothing expired": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("DeleteExpired", time.Hour).Return([]customer.Customer(nil), nil)
+				return &m
+			}(),
+		},
 		"nok, orm error": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("DeleteOld", 1).Return(int64(0), fmt.Errorf("an error"))
+				m.On("Transaction").Return()
+				m.On("DeleteExpired", time.Hour).Return(nil, fmt.Errorf("an error"))
 				return &m
 			}(),
-			withError: regexp.MustCompile("database error: delete old: an error"),
+			withError: regexp.MustCompile("database error: delete expired: an error"),
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			rowsAffected, err := dao.DeleteOld(1)
+			rowsAffected, err := dao.DeleteExpired(context.Background(), "op", time.Hour)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.Regexp(t, test.withError, err.Error())
@@ -279,40 +495,247 @@ func TestDeleteOld(t *testing.T) {
 	}
 }
 
-func TestDeleteByMailingID(t *testing.T) {
+func TestPurge(t *testing.T) {
+	before := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+
 	tests := map[string]struct {
-		withError    *regexp.Regexp
-		db           *postgresql.DataBaseMock
-		expectedRows int64
+		withError *regexp.Regexp
+		db        *postgresql.DataBaseMock
+		purged    int64
 	}{
-		"NOK ErrPg": {
-			withError: regexp.MustCompile("database error: delete old: an error"),
+		"ok, several batches": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("DeleteByMailingID", int64(1)).Return(int64(0), fmt.Errorf("an error"))
+				m.On("Transaction").Return()
+				m.On("Purge", before, 2).Return([]uint{1, 2}, nil).Once()
+				m.On("Purge", before, 2).Return([]uint{3}, nil).Once()
+				for _, ids := range [][]uint{{1, 2}, {3}} {
+					m.On("DeleteMemberships", ids).Return(int64(1), nil).Once()
+					m.On("DeleteDeadLettersTo", ids).Return(int64(0), nil).Once()
+					m.On("DeleteMessagesTo", ids).Return(int64(1), nil).Once()
+				}
 				return &m
 			}(),
+			purged: 3,
 		},
-		"OK": {
+		"ok, full last batch": {
 			db: func() *postgresql.DataBaseMock {
 				m := postgresql.DataBaseMock{}
-				m.On("DeleteByMailingID", int64(1)).Return(int64(3), nil)
+				m.On("Transaction").Return()
+				m.On("Purge", before, 2).Return([]uint{1, 2}, nil).Once()
+				m.On("Purge", before, 2).Return([]uint{}, nil).Once()
+				m.On("DeleteMemberships", []uint{1, 2}).Return(int64(0), nil)
+				m.On("DeleteDeadLettersTo", []uint{1, 2}).Return(int64(0), nil)
+				m.On("DeleteMessagesTo", []uint{1, 2}).Return(int64(0), nil)
 				return &m
 			}(),
-			expectedRows: 3,
+			purged: 2,
+		},
+		"nothing to purge": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("Purge", before, 2).Return([]uint{}, nil)
+				return &m
+			}(),
+		},
+		"nok, second batch": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("Purge", before, 2).Return([]uint{1, 2}, nil).Once()
+				m.On("DeleteMemberships", []uint{1, 2}).Return(int64(0), nil)
+				m.On("DeleteDeadLettersTo", []uint{1, 2}).Return(int64(0), nil)
+				m.On("DeleteMessagesTo", []uint{1, 2}).Return(int64(0), nil)
+				m.On("Purge", before, 2).Return(nil, fmt.Errorf("an error")).Once()
+				return &m
+			}(),
+			purged:    2,
+			withError: regexp.MustCompile("database error: purge: an error"),
+		},
+		"nok, messages": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("Purge", before, 2).Return([]uint{1}, nil)
+				m.On("DeleteMemberships", []uint{1}).Return(int64(0), nil)
+				m.On("DeleteDeadLettersTo", []uint{1}).Return(int64(0), nil)
+				m.On("DeleteMessagesTo", []uint{1}).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: regexp.MustCompile("database error: purge messages: an error"),
 		},
 	}
 	for name, test := range tests {
 		t.Run(name, func(t *testing.T) {
 			dao := CustomerDAO{Db: test.db}
-			rows, err := dao.DeleteByMailingID(1)
-			assert.Equal(t, test.expectedRows, rows)
+			purged, err := dao.Purge(context.Background(), before, 2)
+			assert.Equal(t, test.purged, purged)
 			if test.withError != nil {
 				require.Error(t, err)
 				assert.Regexp(t, test.withError, err.Error())
 				return
 			}
 			require.NoError(t, err)
+
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCustomerDAO_Update(t *testing.T) {
+	updatedAt := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Update", mock.Anything, updatedAt).Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"stale": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Update", mock.Anything, updatedAt).Return(int64(0), nil)
+				return &m
+			}(),
+			withError: ErrStale,
+		},
+		"index error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Update", mock.Anything, updatedAt).Return(int64(0), &pgconn.PgError{Code: "23505", ConstraintName: "idx_multi"})
+				return &m
+			}(),
+			withError: ErrConflict,
+		},
+		"other error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Update", mock.Anything, updatedAt).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := CustomerDAO{Db: test.db}
+			err := dao.Update(context.Background(), &customer.Customer{Model: gorm.Model{ID: 1}}, updatedAt)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCustomerDAO_CreateBatch(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		failed    int
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil).Twice()
+				m.On("Subscribers", webhook.EventCustomerCreated).Return([]webhook.Subscription{{ID: 3}}, nil).Once()
+				m.On("CreateDeliveries", mock.MatchedBy(func(ds []webhook.Delivery) bool {
+					return len(ds) == 2 && ds[0].Event.ID != ds[1].Event.ID
+				})).Return(nil)
+				return &m
+			}(),
+			failed: -1,
+		},
+		"index error on second": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil).Once()
+				m.On("Create", mock.Anything).Return(&pgconn.PgError{Code: "23505", ConstraintName: "idx_multi"}).Once()
+				return &m
+			}(),
+			failed:    1,
+			withError: ErrConflict,
+		},
+		"other error on first": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstSuppression", mock.Anything).Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(fmt.Errorf("an error")).Once()
+				return &m
+			}(),
+			failed:    0,
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := CustomerDAO{Db: test.db}
+			failed, err := dao.CreateBatch(context.Background(), "req",
+				[]*customer.Customer{{Email: "a@example.com"}, {Email: "b@example.com"}})
+			assert.Equal(t, test.failed, failed)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCustomerDAO_Restore(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Restore", int64(1)).Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"not deleted": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Restore", int64(1)).Return(int64(0), nil)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+		"db error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Restore", int64(1)).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := CustomerDAO{Db: test.db}
+			err := dao.Restore(context.Background(), 1)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
 			test.db.AssertExpectations(t)
 		})
 	}
@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Kinds of database errors. Every error returned by the DAO matches exactly one of them with errors.Is.
+var (
+	// ErrPg is any database error not covered by the other kinds
+	ErrPg = errors.New("database error")
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a write violates a unique or exclusion constraint
+	ErrConflict = errors.New("conflicting record")
+	// ErrSerialization is returned when a transaction was aborted because of concurrent ones, and can be retried
+	ErrSerialization = errors.New("serialization failure")
+	// ErrTimeout is returned when a statement was cancelled, either by the database or by its caller
+	ErrTimeout = errors.New("database timeout")
+	// ErrUnavailable is returned when the database cannot be reached or refuses to work
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// Error is a classified database error. It keeps the original error, so that errors.Is and errors.As reach
+// both its Kind and the underlying driver error.
+type Error struct {
+	// Kind is ErrPg, ErrNotFound, ErrConflict, ErrSerialization, ErrTimeout or ErrUnavailable
+	Kind error
+	// Op is the DAO operation that failed
+	Op string
+	// Constraint is the name of the violated constraint of an ErrConflict, if known
+	Constraint string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Kind.Error(), e.Op, e.Err.Error())
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation      = "23505"
+	exclusionViolation   = "23P01"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+	lockNotAvailable     = "55P03"
+	queryCanceled        = "57014"
+	adminShutdown        = "57P01"
+	crashShutdown        = "57P02"
+	cannotConnectNow     = "57P03"
+	// classes
+	connectionException   = "08"
+	insufficientResources = "53"
+)
+
+// wrap classifies err, returned by the given operation, into an *Error. It returns nil if err is nil.
+func wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Kind: ErrPg, Op: op, Err: err}
+
+	var pgErr *pgconn.PgError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		e.Kind = ErrNotFound
+	case errors.As(err, &pgErr):
+		e.Kind = kindOf(pgErr.Code)
+		if e.Kind == ErrConflict {
+			e.Constraint = pgErr.ConstraintName
+		}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled), pgconn.Timeout(err):
+		e.Kind = ErrTimeout
+	case errors.As(err, &netErr):
+		e.Kind = ErrUnavailable
+		if netErr.Timeout() {
+			e.Kind = ErrTimeout
+		}
+	case errors.Is(err, driver.ErrBadConn):
+		e.Kind = ErrUnavailable
+	}
+	return e
+}
+
+// kindOf maps a SQLSTATE code to an error kind
+func kindOf(code string) error {
+	switch code {
+	case uniqueViolation, exclusionViolation:
+		return ErrConflict
+	case serializationFailure, deadlockDetected:
+		return ErrSerialization
+	case queryCanceled, lockNotAvailable:
+		return ErrTimeout
+	case adminShutdown, crashShutdown, cannotConnectNow:
+		return ErrUnavailable
+	}
+	if strings.HasPrefix(code, connectionException) || strings.HasPrefix(code, insufficientResources) {
+		return ErrUnavailable
+	}
+	return ErrPg
+}
@@ -0,0 +1,119 @@
+package dao
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestWrap(t *testing.T) {
+	tests := map[string]struct {
+		err        error
+		kind       error
+		constraint string
+	}{
+		"unique violation": {
+			err:        &pgconn.PgError{Code: "23505", ConstraintName: "idx_multi"},
+			kind:       ErrConflict,
+			constraint: "idx_multi",
+		},
+		"wrapped unique violation": {
+			err:        fmt.Errorf("create: %w", &pgconn.PgError{Code: "23505", ConstraintName: "idx_multi"}),
+			kind:       ErrConflict,
+			constraint: "idx_multi",
+		},
+		"exclusion violation": {
+			err:  &pgconn.PgError{Code: "23P01"},
+			kind: ErrConflict,
+		},
+		"serialization failure": {
+			err:  &pgconn.PgError{Code: "40001"},
+			kind: ErrSerialization,
+		},
+		"deadlock": {
+			err:  &pgconn.PgError{Code: "40P01"},
+			kind: ErrSerialization,
+		},
+		"statement timeout": {
+			err:  &pgconn.PgError{Code: "57014"},
+			kind: ErrTimeout,
+		},
+		"lock timeout": {
+			err:  &pgconn.PgError{Code: "55P03"},
+			kind: ErrTimeout,
+		},
+		"context deadline": {
+			err:  fmt.Errorf("find: %w", context.DeadlineExceeded),
+			kind: ErrTimeout,
+		},
+		"admin shutdown": {
+			err:  &pgconn.PgError{Code: "57P01"},
+			kind: ErrUnavailable,
+		},
+		"connection failure": {
+			err:  &pgconn.PgError{Code: "08006"},
+			kind: ErrUnavailable,
+		},
+		"too many connections": {
+			err:  &pgconn.PgError{Code: "53300"},
+			kind: ErrUnavailable,
+		},
+		"network error": {
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			kind: ErrUnavailable,
+		},
+		"bad connection": {
+			err:  driver.ErrBadConn,
+			kind: ErrUnavailable,
+		},
+		"not found": {
+			err:  gorm.ErrRecordNotFound,
+			kind: ErrNotFound,
+		},
+		"syntax error": {
+			err:  &pgconn.PgError{Code: "42601"},
+			kind: ErrPg,
+		},
+		"unknown": {
+			err:  errors.New("an error"),
+			kind: ErrPg,
+		},
+	}
+	kinds := []error{ErrPg, ErrNotFound, ErrConflict, ErrSerialization, ErrTimeout, ErrUnavailable}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := wrap("op", test.err)
+			require.Error(t, err)
+
+			for _, kind := range kinds {
+				assert.Equal(t, kind == test.kind, errors.Is(err, kind), kind.Error())
+			}
+			assert.True(t, errors.Is(err, test.err))
+
+			var dbErr *Error
+			require.True(t, errors.As(err, &dbErr))
+			assert.Equal(t, test.constraint, dbErr.Constraint)
+			assert.Equal(t, "op", dbErr.Op)
+		})
+	}
+
+	t.Run("pg error is reachable", func(t *testing.T) {
+		err := wrap("op", &pgconn.PgError{Code: "23505"})
+		var pgErr *pgconn.PgError
+		require.True(t, errors.As(err, &pgErr))
+		assert.Equal(t, "23505", pgErr.Code)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		assert.NoError(t, wrap("op", nil))
+	})
+}
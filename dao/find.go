@@ -0,0 +1,220 @@
+package dao
+
+import (
+	"api/customer"
+	"api/postgresql"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the page size used when FindParams.Limit is not set
+	DefaultLimit = 50
+	// MaxLimit is the biggest page size a client may ask for
+	MaxLimit = 500
+	// DefaultSort is the keyset used when FindParams.Sort is not set
+	DefaultSort = "created_at,id"
+)
+
+type (
+	// FindParams holds the filters, sort order and pagination of a CustomerDao.Find call.
+	FindParams struct {
+		Email     string
+		Title     string
+		MailingID *int64
+		// MemberOf keeps the members of the mailing with this id
+		MemberOf      *int64
+		CreatedAfter  *time.Time
+		CreatedBefore *time.Time
+		// IncludeDeleted is either empty, "false", "true" to also find soft deleted customers, or "only" to find
+		// nothing but them.
+		IncludeDeleted string
+		// Sort is a comma separated list of columns, each one optionally prefixed by "-" for descending order.
+		Sort string
+		// Cursor is the opaque value returned as next cursor by the previous page.
+		Cursor string
+		Limit  int
+	}
+
+	// cursor is the decoded form of the opaque cursor handed out to clients.
+	// It carries every sortable column of the last row of a page.
+	cursor struct {
+		Sort      string    `json:"s"`
+		ID        uint      `json:"i"`
+		CreatedAt time.Time `json:"c"`
+		Email     string    `json:"e,omitempty"`
+		Title     string    `json:"t,omitempty"`
+		MailingID int64     `json:"m,omitempty"`
+	}
+)
+
+// sortable maps the column names accepted in FindParams.Sort to the database columns.
+var sortable = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"email":      "email",
+	"title":      "title",
+	"mailing_id": "mailing_id",
+}
+
+// deletedFilters maps the accepted values of FindParams.IncludeDeleted
+var deletedFilters = map[string]postgresql.DeletedFilter{
+	"":      postgresql.ExcludeDeleted,
+	"false": postgresql.ExcludeDeleted,
+	"true":  postgresql.IncludeDeleted,
+	"only":  postgresql.OnlyDeleted,
+}
+
+func (dao *CustomerDAO) Find(ctx context.Context, params FindParams) ([]customer.Customer, string, error) {
+	q, err := params.query()
+	if err != nil {
+		return nil, "", err
+	}
+
+	customers, tx := dao.Db.WithContext(ctx).Find(q)
+	if tx.Error != nil {
+		return nil, "", wrap("find", tx.Error)
+	}
+
+	// one extra row was requested to know whether there is a next page
+	if len(customers) < q.Limit {
+		return customers, "", nil
+	}
+	customers = customers[:q.Limit-1]
+	next, err := encodeCursor(params.sort(), customers[len(customers)-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return customers, next, nil
+}
+
+func (p FindParams) sort() string {
+	if p.Sort == "" {
+		return DefaultSort
+	}
+	return p.Sort
+}
+
+// query validates p and translates it into a postgresql.FindQuery. It may return ErrInvalidQuery.
+func (p FindParams) query() (postgresql.FindQuery, error) {
+	limit := p.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 0 || limit > MaxLimit {
+		return postgresql.FindQuery{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxLimit)
+	}
+
+	keys, err := parseSort(p.sort())
+	if err != nil {
+		return postgresql.FindQuery{}, err
+	}
+
+	deleted, ok := deletedFilters[p.IncludeDeleted]
+	if !ok {
+		return postgresql.FindQuery{}, fmt.Errorf("%w: include_deleted must be true, false or only", ErrInvalidQuery)
+	}
+
+	q := postgresql.FindQuery{
+		Email:         p.Email,
+		Title:         p.Title,
+		MailingID:     p.MailingID,
+		MemberOf:      p.MemberOf,
+		CreatedAfter:  p.CreatedAfter,
+		CreatedBefore: p.CreatedBefore,
+		Deleted:       deleted,
+		Sort:          keys,
+		Limit:         limit + 1,
+	}
+
+	if p.Cursor != "" {
+		c, err := decodeCursor(p.Cursor)
+		if err != nil {
+			return postgresql.FindQuery{}, err
+		}
+		if c.Sort != p.sort() {
+			return postgresql.FindQuery{}, fmt.Errorf("%w: cursor does not match sort %q", ErrInvalidQuery, p.sort())
+		}
+		q.After = c.values(keys)
+	}
+	return q, nil
+}
+
+// parseSort parses a sort expression such as "-created_at,email".
+// The id column is appended when missing so that the keyset identifies a single row.
+func parseSort(sort string) ([]postgresql.SortKey, error) {
+	var (
+		keys   []postgresql.SortKey
+		seen   = map[string]bool{}
+		hasKey bool
+	)
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		column, ok := sortable[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, field)
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("%w: %q appears more than once in sort", ErrInvalidQuery, column)
+		}
+		seen[column] = true
+		hasKey = hasKey || column == "id"
+		keys = append(keys, postgresql.SortKey{Column: column, Desc: desc})
+	}
+	if !hasKey {
+		keys = append(keys, postgresql.SortKey{Column: "id"})
+	}
+	return keys, nil
+}
+
+func encodeCursor(sort string, last customer.Customer) (string, error) {
+	js, err := json.Marshal(cursor{
+		Sort:      sort,
+		ID:        last.ID,
+		CreatedAt: last.CreatedAt,
+		Email:     last.Email,
+		Title:     last.Title,
+		MailingID: last.MailingID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(js), nil
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var c cursor
+	if err := json.Unmarshal(js, &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return &c, nil
+}
+
+// values returns the cursor values of the given sort keys, in the same order.
+func (c *cursor) values(keys []postgresql.SortKey) []interface{} {
+	values := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		switch key.Column {
+		case "id":
+			values = append(values, c.ID)
+		case "created_at":
+			values = append(values, c.CreatedAt)
+		case "email":
+			values = append(values, c.Email)
+		case "title":
+			values = append(values, c.Title)
+		case "mailing_id":
+			values = append(values, c.MailingID)
+		}
+	}
+	return values
+}
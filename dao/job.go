@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"api/job"
+	"api/postgresql"
+	"context"
+	"time"
+)
+
+type (
+	JobDao interface {
+		// Settings retrieves the settings of every job that was paused or rescheduled. It may return any *Error.
+		Settings(ctx context.Context) ([]job.Settings, error)
+
+		// SaveSettings stores the settings of a job, for every replica to apply. It may return any *Error.
+		SaveSettings(ctx context.Context, s *job.Settings) error
+
+		// Record stores a run of a job in the history. It may return any *Error.
+		Record(ctx context.Context, r *job.Run) error
+
+		// Runs retrieves a page of the runs of a job, the latest first, along with the cursor of the next page,
+		// empty on the last one. It may return ErrInvalidQuery or an *Error.
+		Runs(ctx context.Context, tag string, params PageParams) ([]job.Run, string, error)
+
+		// LastRuns retrieves the latest run of every job that ever ran, by tag. It may return any *Error.
+		LastRuns(ctx context.Context) (map[string]job.Run, error)
+
+		// Prune removes the runs started before the given time from the history, and returns how many. It may
+		// return any *Error.
+		Prune(ctx context.Context, before time.Time) (int64, error)
+	}
+
+	JobDAO struct {
+		Db postgresql.Db
+	}
+)
+
+func (dao *JobDAO) Settings(ctx context.Context) ([]job.Settings, error) {
+	ss, tx := dao.Db.WithContext(ctx).FindJobSettings()
+	if tx.Error != nil {
+		return nil, wrap("find job settings", tx.Error)
+	}
+	return ss, nil
+}
+
+func (dao *JobDAO) SaveSettings(ctx context.Context, s *job.Settings) error {
+	return wrap("save job settings", dao.Db.WithContext(ctx).SaveJobSettings(s).Error)
+}
+
+func (dao *JobDAO) Record(ctx context.Context, r *job.Run) error {
+	return wrap("record job run", dao.Db.WithContext(ctx).CreateJobRun(r).Error)
+}
+
+func (dao *JobDAO) Runs(ctx context.Context, tag string, params PageParams) ([]job.Run, string, error) {
+	limit, before, err := page(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one extra row is requested to know whether there is a next page
+	rs, tx := dao.Db.WithContext(ctx).FindJobRuns(tag, before, limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find job runs", tx.Error)
+	}
+	if len(rs) <= limit {
+		return rs, "", nil
+	}
+	rs = rs[:limit]
+	return rs, idCursor(rs[limit-1].ID), nil
+}
+
+func (dao *JobDAO) LastRuns(ctx context.Context) (map[string]job.Run, error) {
+	rs, tx := dao.Db.WithContext(ctx).LastJobRuns()
+	if tx.Error != nil {
+		return nil, wrap("last job runs", tx.Error)
+	}
+	last := make(map[string]job.Run, len(rs))
+	for _, r := range rs {
+		last[r.Job] = r
+	}
+	return last, nil
+}
+
+func (dao *JobDAO) Prune(ctx context.Context, before time.Time) (int64, error) {
+	tx := dao.Db.WithContext(ctx).DeleteJobRuns(before)
+	if tx.Error != nil {
+		return 0, wrap("prune job runs", tx.Error)
+	}
+	return tx.RowsAffected, nil
+}
@@ -0,0 +1,104 @@
+package dao
+
+import (
+	"api/job"
+	"api/postgresql"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobDAO_Runs(t *testing.T) {
+	tests := map[string]struct {
+		params       PageParams
+		db           *postgresql.DataBaseMock
+		expectedIDs  []uint
+		expectedNext string
+		withError    error
+	}{
+		"first page": {
+			params: PageParams{Limit: 2},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindJobRuns", "expire-customers", uint(0), 3).Return([]job.Run{{ID: 9}, {ID: 8}, {ID: 7}}, nil)
+				return &m
+			}(),
+			expectedIDs:  []uint{9, 8},
+			expectedNext: idCursor(8),
+		},
+		"last page": {
+			params: PageParams{Cursor: idCursor(8), Limit: 2},
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindJobRuns", "expire-customers", uint(8), 3).Return([]job.Run{{ID: 7}}, nil)
+				return &m
+			}(),
+			expectedIDs: []uint{7},
+		},
+		"bad cursor": {
+			params:    PageParams{Cursor: "!"},
+			db:        &postgresql.DataBaseMock{},
+			withError: ErrInvalidQuery,
+		},
+		"orm error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindJobRuns", "expire-customers", uint(0), DefaultLimit+1).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := JobDAO{Db: test.db}
+			runs, next, err := dao.Runs(context.Background(), "expire-customers", test.params)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			ids := make([]uint, 0, len(runs))
+			for _, r := range runs {
+				ids = append(ids, r.ID)
+			}
+			assert.Equal(t, test.expectedIDs, ids)
+			assert.Equal(t, test.expectedNext, next)
+
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestJobDAO_LastRuns(t *testing.T) {
+	m := postgresql.DataBaseMock{}
+	m.On("LastJobRuns").Return([]job.Run{{ID: 2, Job: "a"}, {ID: 5, Job: "b"}}, nil)
+	dao := JobDAO{Db: &m}
+
+	last, err := dao.LastRuns(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]job.Run{"a": {ID: 2, Job: "a"}, "b": {ID: 5, Job: "b"}}, last)
+	m.AssertExpectations(t)
+}
+
+func TestJobDAO_Prune(t *testing.T) {
+	before := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	m := postgresql.DataBaseMock{}
+	m.On("DeleteJobRuns", before).Return(int64(4), nil).Once()
+	m.On("DeleteJobRuns", before).Return(int64(0), fmt.Errorf("an error")).Once()
+	dao := JobDAO{Db: &m}
+
+	pruned, err := dao.Prune(context.Background(), before)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pruned)
+
+	_, err = dao.Prune(context.Background(), before)
+	assert.True(t, errors.Is(err, ErrPg))
+	m.AssertExpectations(t)
+}
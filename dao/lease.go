@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"api/leader"
+	"api/postgresql"
+	"context"
+	"time"
+)
+
+type (
+	LeaseDao interface {
+		// Acquire takes or renews a lease for holder until ttl from now, and tells whether it succeeded, which it
+		// does not if somebody else holds it. It may return any *Error.
+		Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+
+		// Release gives up a lease held by holder, so that somebody else may take it right away. Releasing a lease
+		// that is not held is not an error. It may return any *Error.
+		Release(ctx context.Context, name, holder string) error
+
+		// First retrieves a lease by name. It may return ErrNotFound or any other *Error.
+		First(ctx context.Context, name string) (*leader.Lease, error)
+	}
+
+	LeaseDAO struct {
+		Db postgresql.Db
+	}
+)
+
+func (dao *LeaseDAO) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	_, tx := dao.Db.WithContext(ctx).AcquireLease(name, holder, ttl)
+	if tx.Error != nil {
+		return false, wrap("acquire lease", tx.Error)
+	}
+	return tx.RowsAffected != 0, nil
+}
+
+func (dao *LeaseDAO) Release(ctx context.Context, name, holder string) error {
+	return wrap("release lease", dao.Db.WithContext(ctx).ReleaseLease(name, holder).Error)
+}
+
+func (dao *LeaseDAO) First(ctx context.Context, name string) (*leader.Lease, error) {
+	l, tx := dao.Db.WithContext(ctx).FirstLease(name)
+	if tx.Error != nil {
+		return nil, wrap("first lease", tx.Error)
+	}
+	return &l, nil
+}
@@ -0,0 +1,89 @@
+package dao
+
+import (
+	"api/leader"
+	"api/postgresql"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestLeaseDAO_Acquire(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		acquired  bool
+		withError error
+	}{
+		"acquired": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("AcquireLease", leader.CronLease, "a", time.Second).
+					Return(leader.Lease{Name: leader.CronLease, Holder: "a"}, nil)
+				return &m
+			}(),
+			acquired: true,
+		},
+		"held by somebody else": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("AcquireLease", leader.CronLease, "a", time.Second).Return(leader.Lease{}, nil)
+				return &m
+			}(),
+		},
+		"error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("AcquireLease", leader.CronLease, "a", time.Second).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := LeaseDAO{Db: test.db}
+			acquired, err := dao.Acquire(context.Background(), leader.CronLease, "a", time.Second)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.acquired, acquired)
+
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLeaseDAO_Release(t *testing.T) {
+	m := postgresql.DataBaseMock{}
+	m.On("ReleaseLease", leader.CronLease, "a").Return(int64(0), nil).Once()
+	m.On("ReleaseLease", leader.CronLease, "a").Return(int64(0), fmt.Errorf("an error")).Once()
+	dao := LeaseDAO{Db: &m}
+
+	assert.NoError(t, dao.Release(context.Background(), leader.CronLease, "a"))
+	assert.True(t, errors.Is(dao.Release(context.Background(), leader.CronLease, "a"), ErrPg))
+	m.AssertExpectations(t)
+}
+
+func TestLeaseDAO_First(t *testing.T) {
+	m := postgresql.DataBaseMock{}
+	m.On("FirstLease", leader.CronLease).Return(leader.Lease{Name: leader.CronLease, Holder: "a"}, nil).Once()
+	m.On("FirstLease", leader.CronLease).Return(nil, gorm.ErrRecordNotFound).Once()
+	dao := LeaseDAO{Db: &m}
+
+	l, err := dao.First(context.Background(), leader.CronLease)
+	require.NoError(t, err)
+	assert.Equal(t, "a", l.Holder)
+
+	_, err = dao.First(context.Background(), leader.CronLease)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,394 @@
+package dao
+
+import (
+	"api/mailing"
+	"api/outbox"
+	"api/postgresql"
+	"api/render"
+	"api/webhook"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	MailingDao interface {
+		// Create creates a new draft mailing.Mailing. It may return an *Error.
+		Create(ctx context.Context, m *mailing.Mailing) error
+
+		// First retrieves a mailing.Mailing by primary key. It may return ErrNotFound or any other *Error.
+		First(ctx context.Context, id int64) (*mailing.Mailing, error)
+
+		// Find retrieves a page of mailings, along with the cursor of the next page, empty on the last one.
+		// It may return ErrInvalidQuery or an *Error.
+		Find(ctx context.Context, params MailingFindParams) ([]mailing.Mailing, string, error)
+
+		// Update overwrites the subject and body of a mailing.Mailing.
+		// It may return ErrInvalidState if they are no longer editable, or any *Error.
+		Update(ctx context.Context, m *mailing.Mailing) error
+
+		// Delete deletes a draft or cancelled mailing and its memberships.
+		// It may return ErrNotFound, ErrInvalidState or any other *Error.
+		Delete(ctx context.Context, id int64) error
+
+		// Send moves a mailing to sending and queues one outbox.Message per member under the given operation ID,
+		// rendered for that member, all in one transaction. The messages are traced by requestID. Members whose
+		// email address is suppressed get a suppressed message instead, which is never sent.
+		// It returns how many messages were queued, and may return ErrNotFound, ErrInvalidState, ErrTemplate or
+		// any other *Error.
+		Send(ctx context.Context, operationID, requestID string, id int64) (int64, error)
+
+		// Schedule schedules a draft or scheduled mailing to be sent at the given time.
+		// It may return ErrNotFound, ErrInvalidState or any other *Error.
+		Schedule(ctx context.Context, id int64, at time.Time) error
+
+		// Unschedule turns a scheduled mailing back into a draft.
+		// It may return ErrNotFound, ErrInvalidState or any other *Error.
+		Unschedule(ctx context.Context, id int64) error
+
+		// Due retrieves the scheduled mailings due to be sent at the given time. It may return an *Error.
+		Due(ctx context.Context, now time.Time) ([]mailing.Mailing, error)
+
+		// SendDue sends a scheduled mailing like Send does, provided it is still due at the given time, tracing
+		// its messages by the operation ID. It may return ErrNotFound, ErrInvalidState, ErrTemplate or any other *Error.
+		SendDue(ctx context.Context, operationID string, id int64, now time.Time) (int64, error)
+
+		// Fail turns a scheduled mailing that could not be sent back into a draft, recording why.
+		// It may return ErrNotFound, ErrInvalidState or any other *Error.
+		Fail(ctx context.Context, id int64, reason string) error
+
+		// Cancel cancels a mailing along with its pending messages.
+		// It may return ErrNotFound, ErrInvalidState or any other *Error.
+		Cancel(ctx context.Context, id int64) error
+
+		// Complete marks as sent every sending mailing without pending messages, firing a mailing.sent webhook event
+		// for each, traced by the request that sent it, and returns how many. It may return an *Error.
+		Complete(ctx context.Context) (int64, error)
+
+		// Preview returns, without queuing them, the messages Send would queue.
+		// It may return ErrNotFound, ErrTemplate or any other *Error.
+		Preview(ctx context.Context, id int64) ([]outbox.Message, error)
+
+		// AddMembers links existing customers to a mailing, and returns how many were not linked yet.
+		// It may return an *Error.
+		AddMembers(ctx context.Context, id int64, customerIDs []uint) (int64, error)
+
+		// RemoveMember unlinks a customer from a mailing. It may return ErrNotFound or any other *Error.
+		RemoveMember(ctx context.Context, id int64, customerID uint) error
+	}
+
+	// MailingFindParams holds the filter and pagination of a MailingDao.Find call
+	MailingFindParams struct {
+		Status string
+		// Cursor is the opaque value returned as next cursor by the previous page.
+		Cursor string
+		Limit  int
+	}
+
+	MailingDAO struct {
+		Db postgresql.Db
+	}
+)
+
+var (
+	ErrInvalidState = errors.New("invalid mailing state")
+	ErrTemplate     = errors.New("template cannot be rendered")
+)
+
+func (dao *MailingDAO) Create(ctx context.Context, m *mailing.Mailing) error {
+	m.Status = mailing.StatusDraft
+	return wrap("create mailing", dao.Db.WithContext(ctx).CreateMailing(m).Error)
+}
+
+func (dao *MailingDAO) First(ctx context.Context, id int64) (*mailing.Mailing, error) {
+	m, tx := dao.Db.WithContext(ctx).FirstMailing(id)
+	if tx.Error != nil {
+		return nil, wrap("first mailing", tx.Error)
+	}
+	return &m, nil
+}
+
+func (dao *MailingDAO) Find(ctx context.Context, params MailingFindParams) ([]mailing.Mailing, string, error) {
+	limit, after, err := page(PageParams{Cursor: params.Cursor, Limit: params.Limit})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ms, tx := dao.Db.WithContext(ctx).FindMailings(params.Status, after, limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find mailings", tx.Error)
+	}
+	if len(ms) <= limit {
+		return ms, "", nil
+	}
+	ms = ms[:limit]
+	return ms, idCursor(ms[limit-1].ID), nil
+}
+
+func (dao *MailingDAO) Update(ctx context.Context, m *mailing.Mailing) error {
+	tx := dao.Db.WithContext(ctx).UpdateMailing(m)
+	if tx.Error != nil {
+		return wrap("update mailing", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return dao.stateError(dao.Db.WithContext(ctx), int64(m.ID), "edited")
+	}
+	return nil
+}
+
+func (dao *MailingDAO) Delete(ctx context.Context, id int64) error {
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		tx := db.DeleteMailing(id, []string{mailing.StatusDraft, mailing.StatusCancelled})
+		if tx.Error != nil {
+			return wrap("delete mailing", tx.Error)
+		}
+		if tx.RowsAffected == 0 {
+			return dao.stateError(db, id, "deleted")
+		}
+		return wrap("delete mailing", db.RemoveMembers(id, nil).Error)
+	})
+}
+
+func (dao *MailingDAO) Send(ctx context.Context, operationID, requestID string, id int64) (int64, error) {
+	return dao.send(ctx, operationID, requestID, id, func(db postgresql.Db) *gorm.DB {
+		return db.SetMailingStatus(id, mailing.StatusSending)
+	})
+}
+
+func (dao *MailingDAO) Schedule(ctx context.Context, id int64, at time.Time) error {
+	tx := dao.Db.WithContext(ctx).ScheduleMailing(id, at)
+	if tx.Error != nil {
+		return wrap("schedule mailing", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return dao.stateError(dao.Db.WithContext(ctx), id, "scheduled")
+	}
+	return nil
+}
+
+func (dao *MailingDAO) Unschedule(ctx context.Context, id int64) error {
+	tx := dao.Db.WithContext(ctx).UnscheduleMailing(id)
+	if tx.Error != nil {
+		return wrap("unschedule mailing", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return dao.stateError(dao.Db.WithContext(ctx), id, "unscheduled")
+	}
+	return nil
+}
+
+func (dao *MailingDAO) Due(ctx context.Context, now time.Time) ([]mailing.Mailing, error) {
+	ms, tx := dao.Db.WithContext(ctx).FindDueMailings(now)
+	return ms, wrap("due mailings", tx.Error)
+}
+
+func (dao *MailingDAO) SendDue(ctx context.Context, operationID string, id int64, now time.Time) (int64, error) {
+	return dao.send(ctx, operationID, operationID, id, func(db postgresql.Db) *gorm.DB {
+		return db.StartDueMailing(id, now)
+	})
+}
+
+func (dao *MailingDAO) Fail(ctx context.Context, id int64, reason string) error {
+	tx := dao.Db.WithContext(ctx).FailMailing(id, reason)
+	if tx.Error != nil {
+		return wrap("fail mailing", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return dao.stateError(dao.Db.WithContext(ctx), id, "failed")
+	}
+	return nil
+}
+
+// send moves a mailing to sending by means of start, and queues its messages, all in one transaction
+func (dao *MailingDAO) send(ctx context.Context, operationID, requestID string, id int64,
+	start func(postgresql.Db) *gorm.DB) (int64, error) {
+	var queued int64
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		tx := start(db)
+		if tx.Error != nil {
+			return wrap("send mailing", tx.Error)
+		}
+		if tx.RowsAffected == 0 {
+			return dao.stateError(db, id, "sent")
+		}
+
+		ms, err := messages(db, id)
+		if err != nil {
+			return err
+		}
+		for i := range ms {
+			ms[i].OperationID = operationID
+			ms[i].RequestID = requestID
+		}
+		if len(ms) != 0 {
+			tx = db.CreateMessages(ms)
+			if tx.Error != nil {
+				return wrap("enqueue", tx.Error)
+			}
+			queued = tx.RowsAffected
+		}
+		if err := logSuppressed(db, operationID, requestID, id); err != nil {
+			return err
+		}
+		return wrap("record send", db.RecordSend(id, operationID, requestID, queued).Error)
+	})
+	return queued, err
+}
+
+func (dao *MailingDAO) Cancel(ctx context.Context, id int64) error {
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		tx := db.SetMailingStatus(id, mailing.StatusCancelled)
+		if tx.Error != nil {
+			return wrap("cancel mailing", tx.Error)
+		}
+		if tx.RowsAffected == 0 {
+			return dao.stateError(db, id, "cancelled")
+		}
+		return wrap("cancel mailing", db.CancelPending(id).Error)
+	})
+}
+
+func (dao *MailingDAO) Complete(ctx context.Context) (int64, error) {
+	var completed int64
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		ms, tx := db.CompleteMailings()
+		if tx.Error != nil {
+			return wrap("complete mailings", tx.Error)
+		}
+		completed = tx.RowsAffected
+		for i := range ms {
+			if err := enqueue(db, webhook.EventMailingSent, ms[i].RequestID, &ms[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return completed, nil
+}
+
+func (dao *MailingDAO) Preview(ctx context.Context, id int64) ([]outbox.Message, error) {
+	return messages(dao.Db.WithContext(ctx), id)
+}
+
+func (dao *MailingDAO) AddMembers(ctx context.Context, id int64, customerIDs []uint) (int64, error) {
+	tx := dao.Db.WithContext(ctx).AddMembers(id, customerIDs)
+	return tx.RowsAffected, wrap("add members", tx.Error)
+}
+
+func (dao *MailingDAO) RemoveMember(ctx context.Context, id int64, customerID uint) error {
+	tx := dao.Db.WithContext(ctx).RemoveMembers(id, []uint{customerID})
+	if tx.Error != nil {
+		return wrap("remove member", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return &Error{Kind: ErrNotFound, Op: "remove member",
+			Err: fmt.Errorf("customer %d is not a member of mailing %d", customerID, id)}
+	}
+	return nil
+}
+
+// stateError explains why a mailing could not be changed: either it does not exist, or it is in the wrong status
+func (dao *MailingDAO) stateError(db postgresql.Db, id int64, action string) error {
+	m, tx := db.FirstMailing(id)
+	if tx.Error != nil {
+		return wrap("first mailing", tx.Error)
+	}
+	return fmt.Errorf("%w: a %s mailing cannot be %s", ErrInvalidState, m.Status, action)
+}
+
+// messages renders a pending message of the mailing for each of its recipients
+func messages(db postgresql.Db, id int64) ([]outbox.Message, error) {
+	m, tx := db.FirstMailing(id)
+	if tx.Error != nil {
+		return nil, wrap("first mailing", tx.Error)
+	}
+	templates, err := render.Parse(m.Subject, m.Body, m.HTMLBody)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplate, err.Error())
+	}
+
+	recipients, tx := db.Recipients(id)
+	if tx.Error != nil {
+		return nil, wrap("recipients", tx.Error)
+	}
+
+	ms := make([]outbox.Message, 0, len(recipients))
+	for i := range recipients {
+		c := &recipients[i]
+		rendered, err := templates.Render(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: customer %d: %s", ErrTemplate, c.ID, err.Error())
+		}
+		ms = append(ms, outbox.Message{
+			CustomerID: c.ID,
+			MailingID:  id,
+			Recipient:  c.Email,
+			Subject:    rendered.Subject,
+			Body:       rendered.Text,
+			HTMLBody:   rendered.HTML,
+			Status:     outbox.StatusPending,
+		})
+	}
+	return ms, nil
+}
+
+// logSuppressed records a suppressed message for each member of the mailing whose email address is suppressed, so
+// that the message log of the mailing accounts for every member
+func logSuppressed(db postgresql.Db, operationID, requestID string, id int64) error {
+	members, tx := db.SuppressedMembers(id)
+	if tx.Error != nil {
+		return wrap("suppressed members", tx.Error)
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	ms := make([]outbox.Message, 0, len(members))
+	for _, c := range members {
+		ms = append(ms, outbox.Message{
+			OperationID: operationID,
+			RequestID:   requestID,
+			CustomerID:  c.ID,
+			MailingID:   id,
+			Recipient:   c.Email,
+			Status:      outbox.StatusSuppressed,
+		})
+	}
+	return wrap("log suppressed", db.CreateMessages(ms).Error)
+}
+
+// page validates the limit of a page of rows listed by id, and decodes the id its cursor says to list after
+func page(params PageParams) (int, uint, error) {
+	limit := params.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 0 || limit > MaxLimit {
+		return 0, 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxLimit)
+	}
+	if params.Cursor == "" {
+		return limit, 0, nil
+	}
+	id, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	after, err := strconv.ParseUint(string(id), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return limit, uint(after), nil
+}
+
+// idCursor encodes the id of the last row of a page listed by id
+func idCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
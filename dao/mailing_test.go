@@ -0,0 +1,537 @@
+package dao
+
+import (
+	"api/customer"
+	"api/mailing"
+	"api/outbox"
+	"api/postgresql"
+	"api/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMailingDAO_Create(t *testing.T) {
+	db := &postgresql.DataBaseMock{}
+	db.On("CreateMailing", mock.MatchedBy(func(m *mailing.Mailing) bool {
+		return m.Status == mailing.StatusDraft
+	})).Return(nil)
+	dao := MailingDAO{Db: db}
+
+	m := &mailing.Mailing{Subject: "Hi", Body: "hello", Status: mailing.StatusSent}
+	require.NoError(t, dao.Create(context.Background(), m))
+	assert.Equal(t, mailing.StatusDraft, m.Status)
+	db.AssertExpectations(t)
+}
+
+func TestMailingDAO_Find(t *testing.T) {
+	page := []mailing.Mailing{{ID: 1}, {ID: 2}, {ID: 3}}
+	tests := map[string]struct {
+		db           *postgresql.DataBaseMock
+		params       MailingFindParams
+		expectedLen  int
+		expectedNext string
+		withError    error
+	}{
+		"last page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindMailings", "", uint(0), DefaultLimit+1).Return(page, nil)
+				return &m
+			}(),
+			expectedLen: 3,
+		},
+		"next page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindMailings", mailing.StatusDraft, uint(0), 3).Return(page, nil)
+				return &m
+			}(),
+			params:       MailingFindParams{Status: mailing.StatusDraft, Limit: 2},
+			expectedLen:  2,
+			expectedNext: "Mg",
+		},
+		"from cursor": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindMailings", "", uint(2), 3).Return(page[2:], nil)
+				return &m
+			}(),
+			params:      MailingFindParams{Cursor: "Mg", Limit: 2},
+			expectedLen: 1,
+		},
+		"bad cursor": {
+			db:        &postgresql.DataBaseMock{},
+			params:    MailingFindParams{Cursor: "!"},
+			withError: ErrInvalidQuery,
+		},
+		"bad limit": {
+			db:        &postgresql.DataBaseMock{},
+			params:    MailingFindParams{Limit: MaxLimit + 1},
+			withError: ErrInvalidQuery,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			ms, next, err := dao.Find(context.Background(), test.params)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, ms, test.expectedLen)
+			assert.Equal(t, test.expectedNext, next)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Send(t *testing.T) {
+	draft := mailing.Mailing{ID: 7, Subject: "Hi {{.title}}", Body: "Dear {{.title}}", Status: mailing.StatusDraft}
+	recipients := []customer.Customer{
+		{Model: gorm.Model{ID: 1}, Email: "a@example.com", Title: "Ms"},
+		{Model: gorm.Model{ID: 2}, Email: "b@example.com", Title: "Mr"},
+	}
+	expected := []outbox.Message{
+		{OperationID: "op", RequestID: "req", CustomerID: 1, MailingID: 7, Recipient: "a@example.com", Subject: "Hi Ms", Body: "Dear Ms", Status: outbox.StatusPending},
+		{OperationID: "op", RequestID: "req", CustomerID: 2, MailingID: 7, Recipient: "b@example.com", Subject: "Hi Mr", Body: "Dear Mr", Status: outbox.StatusPending},
+	}
+
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		queued    int64
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(draft, nil)
+				m.On("Recipients", int64(7)).Return(recipients, nil)
+				m.On("CreateMessages", expected).Return(nil)
+				m.On("SuppressedMembers", int64(7)).Return([]customer.Customer(nil), nil)
+				m.On("RecordSend", int64(7), "op", "req", int64(2)).Return(nil)
+				return &m
+			}(),
+			queued: 2,
+		},
+		"no recipients": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(draft, nil)
+				m.On("Recipients", int64(7)).Return([]customer.Customer(nil), nil)
+				m.On("SuppressedMembers", int64(7)).Return([]customer.Customer(nil), nil)
+				m.On("RecordSend", int64(7), "op", "req", int64(0)).Return(nil)
+				return &m
+			}(),
+		},
+		"suppressed members": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(draft, nil)
+				m.On("Recipients", int64(7)).Return(recipients[:1], nil)
+				m.On("CreateMessages", expected[:1]).Return(nil)
+				m.On("SuppressedMembers", int64(7)).Return(recipients[1:], nil)
+				m.On("CreateMessages", []outbox.Message{{OperationID: "op", RequestID: "req", CustomerID: 2,
+					MailingID: 7, Recipient: "b@example.com", Status: outbox.StatusSuppressed}}).Return(nil)
+				m.On("RecordSend", int64(7), "op", "req", int64(1)).Return(nil)
+				return &m
+			}(),
+			queued: 1,
+		},
+		"already sent": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSent}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+		"not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+		"broken template": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Subject: "{{.name}}", Body: "hi"}, nil)
+				m.On("Recipients", int64(7)).Return(recipients, nil)
+				return &m
+			}(),
+			withError: ErrTemplate,
+		},
+		"enqueue error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusSending).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(draft, nil)
+				m.On("Recipients", int64(7)).Return(recipients, nil)
+				m.On("CreateMessages", expected).Return(fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			queued, err := dao.Send(context.Background(), "op", "req", 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.queued, queued)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_SendDue(t *testing.T) {
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	scheduled := mailing.Mailing{ID: 7, Subject: "Hi", Body: "Dear {{.title}}", Status: mailing.StatusScheduled, SendAt: &now}
+	recipients := []customer.Customer{{Model: gorm.Model{ID: 1}, Email: "a@example.com", Title: "Ms"}}
+	expected := []outbox.Message{
+		{OperationID: "op", RequestID: "op", CustomerID: 1, MailingID: 7, Recipient: "a@example.com", Subject: "Hi", Body: "Dear Ms", Status: outbox.StatusPending},
+	}
+
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		queued    int64
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("StartDueMailing", int64(7), now).Return(int64(1), nil)
+				m.On("FirstMailing", int64(7)).Return(scheduled, nil)
+				m.On("Recipients", int64(7)).Return(recipients, nil)
+				m.On("CreateMessages", expected).Return(nil)
+				m.On("SuppressedMembers", int64(7)).Return([]customer.Customer(nil), nil)
+				m.On("RecordSend", int64(7), "op", "op", int64(1)).Return(nil)
+				return &m
+			}(),
+			queued: 1,
+		},
+		"rescheduled meanwhile": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("StartDueMailing", int64(7), now).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(scheduled, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			queued, err := dao.SendDue(context.Background(), "op", 7, now)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.queued, queued)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Schedule(t *testing.T) {
+	at := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ScheduleMailing", int64(7), at).Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"already sent": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ScheduleMailing", int64(7), at).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSent}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ScheduleMailing", int64(7), at).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			err := dao.Schedule(context.Background(), 7, at)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Unschedule(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("UnscheduleMailing", int64(7)).Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"not scheduled": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("UnscheduleMailing", int64(7)).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusDraft}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+		"not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("UnscheduleMailing", int64(7)).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			err := dao.Unschedule(context.Background(), 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Cancel(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusCancelled).Return(int64(1), nil)
+				m.On("CancelPending", int64(7)).Return(int64(2), nil)
+				return &m
+			}(),
+		},
+		"already sent": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("SetMailingStatus", int64(7), mailing.StatusCancelled).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSent}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			err := dao.Cancel(context.Background(), 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Complete(t *testing.T) {
+	sent := []mailing.Mailing{{ID: 7, Status: mailing.StatusSent, RequestID: "req"}}
+
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		completed int64
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CompleteMailings").Return(sent, nil)
+				m.On("Subscribers", webhook.EventMailingSent).Return([]webhook.Subscription{{ID: 3}}, nil)
+				m.On("CreateDeliveries", mock.MatchedBy(func(ds []webhook.Delivery) bool {
+					return len(ds) == 1 && ds[0].SubscriptionID == 3 && ds[0].RequestID == "req" &&
+						ds[0].EventType == webhook.EventMailingSent
+				})).Return(nil)
+				return &m
+			}(),
+			completed: 1,
+		},
+		"none": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CompleteMailings").Return([]mailing.Mailing(nil), nil)
+				return &m
+			}(),
+		},
+		"enqueue error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CompleteMailings").Return(sent, nil)
+				m.On("Subscribers", webhook.EventMailingSent).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			completed, err := dao.Complete(context.Background())
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.completed, completed)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Delete(t *testing.T) {
+	deletable := []string{mailing.StatusDraft, mailing.StatusCancelled}
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("DeleteMailing", int64(7), deletable).Return(int64(1), nil)
+				m.On("RemoveMembers", int64(7), []uint(nil)).Return(int64(2), nil)
+				return &m
+			}(),
+		},
+		"sending": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("DeleteMailing", int64(7), deletable).Return(int64(0), nil)
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSending}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := MailingDAO{Db: test.db}
+			err := dao.Delete(context.Background(), 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMailingDAO_Preview(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		db := &postgresql.DataBaseMock{}
+		db.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Subject: "Hi", Body: "{{.email}}", HTMLBody: "<p>{{.email}}</p>"}, nil)
+		db.On("Recipients", int64(7)).Return([]customer.Customer{{Model: gorm.Model{ID: 1}, Email: "a@example.com"}}, nil)
+		dao := MailingDAO{Db: db}
+		ms, err := dao.Preview(context.Background(), 7)
+		require.NoError(t, err)
+		assert.Equal(t, []outbox.Message{{
+			CustomerID: 1, MailingID: 7, Recipient: "a@example.com", Subject: "Hi",
+			Body: "a@example.com", HTMLBody: "<p>a@example.com</p>", Status: outbox.StatusPending,
+		}}, ms)
+		db.AssertExpectations(t)
+	})
+	t.Run("not found", func(t *testing.T) {
+		db := &postgresql.DataBaseMock{}
+		db.On("FirstMailing", int64(7)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+		dao := MailingDAO{Db: db}
+		_, err := dao.Preview(context.Background(), 7)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	})
+}
+
+func TestMailingDAO_RemoveMember(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		db := &postgresql.DataBaseMock{}
+		db.On("RemoveMembers", int64(7), []uint{1}).Return(int64(1), nil)
+		dao := MailingDAO{Db: db}
+		require.NoError(t, dao.RemoveMember(context.Background(), 7, 1))
+	})
+	t.Run("not a member", func(t *testing.T) {
+		db := &postgresql.DataBaseMock{}
+		db.On("RemoveMembers", int64(7), []uint{1}).Return(int64(0), nil)
+		dao := MailingDAO{Db: db}
+		assert.True(t, errors.Is(dao.RemoveMember(context.Background(), 7, 1), ErrNotFound))
+	})
+}
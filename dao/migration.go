@@ -0,0 +1,129 @@
+package dao
+
+import (
+	"api/migration"
+	"api/postgresql"
+)
+
+type (
+	// MigrationDao brings the schema to a version. Each of its methods runs within a transaction holding the advisory
+	// lock of the migrations, so that replicas migrating, or starting, together wait for each other.
+	MigrationDao interface {
+		// Applied retrieves the records of the migrations applied, by version. It may return any *Error.
+		Applied() ([]migration.Record, error)
+
+		// Check tells whether the service may run against the schema, see migration.Check. It may return
+		// migration.ErrUnknownVersion, migration.ErrPending or any *Error.
+		Check() error
+
+		// Up applies every pending migration, and returns the steps taken. It may return migration.ErrUnknownVersion
+		// or any *Error.
+		Up() ([]migration.Step, error)
+
+		// Down undoes the latest migration applied, if any, and returns the steps taken. It may return
+		// migration.ErrUnknownVersion or any *Error.
+		Down() ([]migration.Step, error)
+
+		// To applies or undoes the migrations up to the given version, and returns the steps taken. It may return
+		// migration.ErrUnknownVersion or any *Error.
+		To(version int) ([]migration.Step, error)
+	}
+
+	MigrationDAO struct {
+		Db postgresql.Db
+		// Migrations are the ones known to this binary, by version
+		Migrations []migration.Migration
+	}
+)
+
+func (dao *MigrationDAO) Applied() ([]migration.Record, error) {
+	var rs []migration.Record
+	err := dao.Db.Transaction(func(db postgresql.Db) error {
+		var err error
+		rs, err = applied(db)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (dao *MigrationDAO) Check() error {
+	rs, err := dao.Applied()
+	if err != nil {
+		return err
+	}
+	return migration.Check(dao.Migrations, version(rs))
+}
+
+func (dao *MigrationDAO) Up() ([]migration.Step, error) {
+	return dao.migrate(func(int) int { return migration.Latest(dao.Migrations) })
+}
+
+func (dao *MigrationDAO) Down() ([]migration.Step, error) {
+	return dao.migrate(func(current int) int {
+		if current == 0 {
+			return 0
+		}
+		return current - 1
+	})
+}
+
+func (dao *MigrationDAO) To(version int) ([]migration.Step, error) {
+	return dao.migrate(func(int) int { return version })
+}
+
+// migrate takes the steps from the current version of the schema to the one target tells, all or none of them
+func (dao *MigrationDAO) migrate(target func(current int) int) ([]migration.Step, error) {
+	var steps []migration.Step
+	err := dao.Db.Transaction(func(db postgresql.Db) error {
+		rs, err := applied(db)
+		if err != nil {
+			return err
+		}
+		current := version(rs)
+		if steps, err = migration.Plan(dao.Migrations, current, target(current)); err != nil {
+			return err
+		}
+		for _, s := range steps {
+			if err := db.ExecMigration(s.SQL()); err != nil {
+				return wrap(s.String(), err)
+			}
+			if s.Undo {
+				if tx := db.DeleteMigration(s.Version); tx.Error != nil {
+					return wrap("delete migration", tx.Error)
+				}
+				continue
+			}
+			if tx := db.CreateMigration(&migration.Record{Version: s.Version, Name: s.Name}); tx.Error != nil {
+				return wrap("create migration", tx.Error)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// applied takes the advisory lock of the migrations, and retrieves the records of those applied
+func applied(db postgresql.Db) ([]migration.Record, error) {
+	if tx := db.LockMigrations(); tx.Error != nil {
+		return nil, wrap("lock migrations", tx.Error)
+	}
+	rs, tx := db.FindMigrations()
+	if tx.Error != nil {
+		return nil, wrap("find migrations", tx.Error)
+	}
+	return rs, nil
+}
+
+// version is the one of a schema the given migrations were applied to, by version
+func version(rs []migration.Record) int {
+	if len(rs) == 0 {
+		return 0
+	}
+	return rs[len(rs)-1].Version
+}
@@ -0,0 +1,192 @@
+package dao
+
+import (
+	"api/migration"
+	"api/postgresql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = []migration.Migration{
+	{Version: 1, Name: "one", Up: "up 1", Down: "down 1"},
+	{Version: 2, Name: "two", Up: "up 2", Down: "down 2"},
+}
+
+func TestMigrationDAO_Migrate(t *testing.T) {
+	records := func(versions ...int) []migration.Record {
+		rs := []migration.Record{}
+		for _, v := range versions {
+			rs = append(rs, migration.Record{Version: v, Name: testMigrations[v-1].Name})
+		}
+		return rs
+	}
+	tests := map[string]struct {
+		migrate       func(dao *MigrationDAO) ([]migration.Step, error)
+		db            *postgresql.DataBaseMock
+		expectedSteps []string
+		withError     error
+	}{
+		"up from scratch": {
+			migrate: (*MigrationDAO).Up,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(), nil)
+				m.On("ExecMigration", "up 1").Return(nil).Once()
+				m.On("ExecMigration", "up 2").Return(nil).Once()
+				m.On("CreateMigration", &migration.Record{Version: 1, Name: "one"}).Return(nil).Once()
+				m.On("CreateMigration", &migration.Record{Version: 2, Name: "two"}).Return(nil).Once()
+				return m
+			}(),
+			expectedSteps: []string{"apply 1_one", "apply 2_two"},
+		},
+		"up to date": {
+			migrate: (*MigrationDAO).Up,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(1, 2), nil)
+				return m
+			}(),
+		},
+		"down": {
+			migrate: (*MigrationDAO).Down,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(1, 2), nil)
+				m.On("ExecMigration", "down 2").Return(nil).Once()
+				m.On("DeleteMigration", 2).Return(nil).Once()
+				return m
+			}(),
+			expectedSteps: []string{"undo 2_two"},
+		},
+		"down from scratch": {
+			migrate: (*MigrationDAO).Down,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(), nil)
+				return m
+			}(),
+		},
+		"to 0": {
+			migrate: func(dao *MigrationDAO) ([]migration.Step, error) { return dao.To(0) },
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(1, 2), nil)
+				m.On("ExecMigration", "down 2").Return(nil).Once()
+				m.On("ExecMigration", "down 1").Return(nil).Once()
+				m.On("DeleteMigration", 2).Return(nil).Once()
+				m.On("DeleteMigration", 1).Return(nil).Once()
+				return m
+			}(),
+			expectedSteps: []string{"undo 2_two", "undo 1_one"},
+		},
+		"to an unknown version": {
+			migrate: func(dao *MigrationDAO) ([]migration.Step, error) { return dao.To(3) },
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(), nil)
+				return m
+			}(),
+			withError: migration.ErrUnknownVersion,
+		},
+		"lock fails": {
+			migrate: (*MigrationDAO).Up,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(errors.New("an error"))
+				return m
+			}(),
+			withError: &Error{},
+		},
+		"migration fails": {
+			migrate: (*MigrationDAO).Up,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(), nil)
+				m.On("ExecMigration", "up 1").Return(errors.New("syntax error")).Once()
+				return m
+			}(),
+			withError: &Error{},
+		},
+		"record fails": {
+			migrate: (*MigrationDAO).Up,
+			db: func() *postgresql.DataBaseMock {
+				m := &postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("LockMigrations").Return(nil)
+				m.On("FindMigrations").Return(records(), nil)
+				m.On("ExecMigration", "up 1").Return(nil).Once()
+				m.On("CreateMigration", mock.Anything).Return(errors.New("an error")).Once()
+				return m
+			}(),
+			withError: &Error{},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := &MigrationDAO{Db: test.db, Migrations: testMigrations}
+			steps, err := test.migrate(dao)
+			test.db.AssertExpectations(t)
+			if test.withError != nil {
+				if e, ok := test.withError.(*Error); ok {
+					assert.ErrorAs(t, err, &e)
+					return
+				}
+				assert.ErrorIs(t, err, test.withError)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, s := range steps {
+				names = append(names, s.String())
+			}
+			assert.Equal(t, test.expectedSteps, names)
+		})
+	}
+}
+
+func TestMigrationDAO_Check(t *testing.T) {
+	tests := map[string]struct {
+		applied   []migration.Record
+		withError error
+	}{
+		"up to date": {applied: []migration.Record{{Version: 1}, {Version: 2}}},
+		"pending":    {applied: []migration.Record{{Version: 1}}, withError: migration.ErrPending},
+		"unknown": {
+			applied:   []migration.Record{{Version: 1}, {Version: 2}, {Version: 3}},
+			withError: migration.ErrUnknownVersion,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := &postgresql.DataBaseMock{}
+			m.On("Transaction").Return()
+			m.On("LockMigrations").Return(nil)
+			m.On("FindMigrations").Return(test.applied, nil)
+			err := (&MigrationDAO{Db: m, Migrations: testMigrations}).Check()
+			if test.withError != nil {
+				assert.ErrorIs(t, err, test.withError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
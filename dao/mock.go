@@ -2,6 +2,15 @@ package dao
 
 import (
 	"api/customer"
+	"api/job"
+	"api/leader"
+	"api/mailing"
+	"api/migration"
+	"api/outbox"
+	"api/suppression"
+	"api/webhook"
+	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -12,23 +21,28 @@ type (
 	}
 )
 
-func (dao *CustomerDaoMock) Create(c *customer.Customer) error {
-	args := dao.Called(c)
+func (dao *CustomerDaoMock) Create(ctx context.Context, requestID string, c *customer.Customer) error {
+	args := dao.Called(ctx, requestID, c)
 	return args.Error(0)
 }
 
-func (dao *CustomerDaoMock) MigrateModels() error {
+func (dao *CustomerDaoMock) CreateBatch(ctx context.Context, requestID string, cs []*customer.Customer) (int, error) {
+	args := dao.Called(ctx, requestID, cs)
+	return args.Int(0), args.Error(1)
+}
+
+func (dao *CustomerDaoMock) Close() error {
 	args := dao.Called()
 	return args.Error(0)
 }
 
-func (dao *CustomerDaoMock) Delete(c *customer.Customer, id int64) error {
-	args := dao.Called(c, id)
+func (dao *CustomerDaoMock) Delete(ctx context.Context, requestID string, c *customer.Customer, id int64) error {
+	args := dao.Called(ctx, requestID, c, id)
 	return args.Error(0)
 }
 
-func (dao *CustomerDaoMock) First(id int64) (*customer.Customer, error) {
-	args := dao.Called(id)
+func (dao *CustomerDaoMock) First(ctx context.Context, id int64) (*customer.Customer, error) {
+	args := dao.Called(ctx, id)
 	first := args.Get(0)
 	if first == nil {
 		return nil, args.Error(1)
@@ -36,17 +50,351 @@ func (dao *CustomerDaoMock) First(id int64) (*customer.Customer, error) {
 	return first.(*customer.Customer), args.Error(1)
 }
 
-func (dao *CustomerDaoMock) Find() ([]customer.Customer, error) {
-	args := dao.Called()
-	return args.Get(0).([]customer.Customer), args.Error(1)
+func (dao *CustomerDaoMock) Find(ctx context.Context, params FindParams) ([]customer.Customer, string, error) {
+	args := dao.Called(ctx, params)
+	return args.Get(0).([]customer.Customer), args.String(1), args.Error(2)
+}
+
+func (dao *CustomerDaoMock) DeleteExpired(ctx context.Context, operationID string,
+	period time.Duration) (int64, error) {
+	args := dao.Called(ctx, operationID, period)
+	return args.Get(0).(int64), args.Error(1)
 }
 
-func (dao *CustomerDaoMock) DeleteOld(seconds int) (int64, error) {
-	args := dao.Called(seconds)
+func (dao *CustomerDaoMock) Purge(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	args := dao.Called(ctx, before, batchSize)
 	return args.Get(0).(int64), args.Error(1)
 }
 
-func (dao *CustomerDaoMock) DeleteByMailingID(mailingID int64) (int64, error) {
-	args := dao.Called(mailingID)
+func (dao *CustomerDaoMock) Update(ctx context.Context, c *customer.Customer, updatedAt time.Time) error {
+	args := dao.Called(ctx, c, updatedAt)
+	return args.Error(0)
+}
+
+func (dao *CustomerDaoMock) Restore(ctx context.Context, id int64) error {
+	args := dao.Called(ctx, id)
+	return args.Error(0)
+}
+
+type OutboxDaoMock struct {
+	mock.Mock
+}
+
+func (dao *OutboxDaoMock) Dispatch(ctx context.Context, limit int, policy outbox.RetryPolicy,
+	action outbox.PostSendAction, send func(context.Context, *outbox.Message) error) (int, int, error) {
+	args := dao.Called(ctx, limit, policy, action, send)
+	return args.Int(0), args.Int(1), args.Error(2)
+}
+
+func (dao *OutboxDaoMock) DeadLetters(ctx context.Context, mailingID int64,
+	params PageParams) ([]outbox.DeadLetter, string, error) {
+	args := dao.Called(ctx, mailingID, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]outbox.DeadLetter), args.String(1), nil
+}
+
+func (dao *OutboxDaoMock) Requeue(ctx context.Context, mailingID int64, ids []uint, requestID string) (int64, error) {
+	args := dao.Called(ctx, mailingID, ids, requestID)
 	return args.Get(0).(int64), args.Error(1)
 }
+
+func (dao *OutboxDaoMock) Counts(ctx context.Context, mailingID int64) (map[string]int64, error) {
+	args := dao.Called(ctx, mailingID)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]int64), nil
+}
+
+func (dao *OutboxDaoMock) Messages(ctx context.Context, params MessageFindParams) ([]outbox.Message, string, error) {
+	args := dao.Called(ctx, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]outbox.Message), args.String(1), nil
+}
+
+type MailingDaoMock struct {
+	mock.Mock
+}
+
+func (dao *MailingDaoMock) Create(ctx context.Context, m *mailing.Mailing) error {
+	args := dao.Called(ctx, m)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) First(ctx context.Context, id int64) (*mailing.Mailing, error) {
+	args := dao.Called(ctx, id)
+	first := args.Get(0)
+	if first == nil {
+		return nil, args.Error(1)
+	}
+	return first.(*mailing.Mailing), args.Error(1)
+}
+
+func (dao *MailingDaoMock) Find(ctx context.Context, params MailingFindParams) ([]mailing.Mailing, string, error) {
+	args := dao.Called(ctx, params)
+	return args.Get(0).([]mailing.Mailing), args.String(1), args.Error(2)
+}
+
+func (dao *MailingDaoMock) Update(ctx context.Context, m *mailing.Mailing) error {
+	args := dao.Called(ctx, m)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Delete(ctx context.Context, id int64) error {
+	args := dao.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Send(ctx context.Context, operationID, requestID string, id int64) (int64, error) {
+	args := dao.Called(ctx, operationID, requestID, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (dao *MailingDaoMock) Schedule(ctx context.Context, id int64, at time.Time) error {
+	args := dao.Called(ctx, id, at)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Unschedule(ctx context.Context, id int64) error {
+	args := dao.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Due(ctx context.Context, now time.Time) ([]mailing.Mailing, error) {
+	args := dao.Called(ctx, now)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]mailing.Mailing), nil
+}
+
+func (dao *MailingDaoMock) SendDue(ctx context.Context, operationID string, id int64, now time.Time) (int64, error) {
+	args := dao.Called(ctx, operationID, id, now)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (dao *MailingDaoMock) Fail(ctx context.Context, id int64, reason string) error {
+	args := dao.Called(ctx, id, reason)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Cancel(ctx context.Context, id int64) error {
+	args := dao.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (dao *MailingDaoMock) Complete(ctx context.Context) (int64, error) {
+	args := dao.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (dao *MailingDaoMock) Preview(ctx context.Context, id int64) ([]outbox.Message, error) {
+	args := dao.Called(ctx, id)
+	return args.Get(0).([]outbox.Message), args.Error(1)
+}
+
+func (dao *MailingDaoMock) AddMembers(ctx context.Context, id int64, customerIDs []uint) (int64, error) {
+	args := dao.Called(ctx, id, customerIDs)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (dao *MailingDaoMock) RemoveMember(ctx context.Context, id int64, customerID uint) error {
+	args := dao.Called(ctx, id, customerID)
+	return args.Error(0)
+}
+
+type SuppressionDaoMock struct {
+	mock.Mock
+}
+
+func (dao *SuppressionDaoMock) Create(ctx context.Context, s *suppression.Suppression) error {
+	args := dao.Called(ctx, s)
+	return args.Error(0)
+}
+
+func (dao *SuppressionDaoMock) First(ctx context.Context, email string) (*suppression.Suppression, error) {
+	args := dao.Called(ctx, email)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*suppression.Suppression), nil
+}
+
+func (dao *SuppressionDaoMock) Find(ctx context.Context, params PageParams) ([]suppression.Suppression, string, error) {
+	args := dao.Called(ctx, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]suppression.Suppression), args.String(1), nil
+}
+
+func (dao *SuppressionDaoMock) Delete(ctx context.Context, email string) error {
+	args := dao.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (dao *SuppressionDaoMock) Recipient(ctx context.Context, customerID uint) (string, bool, error) {
+	args := dao.Called(ctx, customerID)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (dao *SuppressionDaoMock) Unsubscribe(ctx context.Context, customerID uint,
+	mailingID int64) (*suppression.Suppression, error) {
+	args := dao.Called(ctx, customerID, mailingID)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*suppression.Suppression), nil
+}
+
+type WebhookDaoMock struct {
+	mock.Mock
+}
+
+func (dao *WebhookDaoMock) Create(ctx context.Context, s *webhook.Subscription) error {
+	args := dao.Called(ctx, s)
+	return args.Error(0)
+}
+
+func (dao *WebhookDaoMock) First(ctx context.Context, id uint) (*webhook.Subscription, error) {
+	args := dao.Called(ctx, id)
+	first := args.Get(0)
+	if first == nil {
+		return nil, args.Error(1)
+	}
+	return first.(*webhook.Subscription), args.Error(1)
+}
+
+func (dao *WebhookDaoMock) Find(ctx context.Context, params PageParams) ([]webhook.Subscription, string, error) {
+	args := dao.Called(ctx, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]webhook.Subscription), args.String(1), nil
+}
+
+func (dao *WebhookDaoMock) Update(ctx context.Context, s *webhook.Subscription) error {
+	args := dao.Called(ctx, s)
+	return args.Error(0)
+}
+
+func (dao *WebhookDaoMock) Delete(ctx context.Context, id uint) error {
+	args := dao.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (dao *WebhookDaoMock) Deliveries(ctx context.Context, subscriptionID uint,
+	params DeliveryFindParams) ([]webhook.Delivery, string, error) {
+	args := dao.Called(ctx, subscriptionID, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]webhook.Delivery), args.String(1), nil
+}
+
+func (dao *WebhookDaoMock) Deliver(ctx context.Context, limit int, policy outbox.RetryPolicy,
+	send func(context.Context, *webhook.Subscription, *webhook.Delivery) (int, error)) (int, int, error) {
+	args := dao.Called(ctx, limit, policy, send)
+	return args.Int(0), args.Int(1), args.Error(2)
+}
+
+type LeaseDaoMock struct {
+	mock.Mock
+}
+
+func (dao *LeaseDaoMock) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	args := dao.Called(ctx, name, holder, ttl)
+	return args.Bool(0), args.Error(1)
+}
+
+func (dao *LeaseDaoMock) Release(ctx context.Context, name, holder string) error {
+	return dao.Called(ctx, name, holder).Error(0)
+}
+
+func (dao *LeaseDaoMock) First(ctx context.Context, name string) (*leader.Lease, error) {
+	args := dao.Called(ctx, name)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*leader.Lease), nil
+}
+
+type JobDaoMock struct {
+	mock.Mock
+}
+
+func (dao *JobDaoMock) Settings(ctx context.Context) ([]job.Settings, error) {
+	args := dao.Called(ctx)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]job.Settings), nil
+}
+
+func (dao *JobDaoMock) SaveSettings(ctx context.Context, s *job.Settings) error {
+	return dao.Called(ctx, s).Error(0)
+}
+
+func (dao *JobDaoMock) Record(ctx context.Context, r *job.Run) error {
+	return dao.Called(ctx, r).Error(0)
+}
+
+func (dao *JobDaoMock) Runs(ctx context.Context, tag string, params PageParams) ([]job.Run, string, error) {
+	args := dao.Called(ctx, tag, params)
+	if args.Error(2) != nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).([]job.Run), args.String(1), nil
+}
+
+func (dao *JobDaoMock) LastRuns(ctx context.Context) (map[string]job.Run, error) {
+	args := dao.Called(ctx)
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]job.Run), nil
+}
+
+func (dao *JobDaoMock) Prune(ctx context.Context, before time.Time) (int64, error) {
+	args := dao.Called(ctx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MigrationDaoMock struct {
+	mock.Mock
+}
+
+func (dao *MigrationDaoMock) Applied() ([]migration.Record, error) {
+	args := dao.Called()
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]migration.Record), nil
+}
+
+func (dao *MigrationDaoMock) Check() error {
+	return dao.Called().Error(0)
+}
+
+func (dao *MigrationDaoMock) Up() ([]migration.Step, error) {
+	return dao.steps(dao.Called())
+}
+
+func (dao *MigrationDaoMock) Down() ([]migration.Step, error) {
+	return dao.steps(dao.Called())
+}
+
+func (dao *MigrationDaoMock) To(version int) ([]migration.Step, error) {
+	return dao.steps(dao.Called(version))
+}
+
+func (dao *MigrationDaoMock) steps(args mock.Arguments) ([]migration.Step, error) {
+	if args.Error(1) != nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]migration.Step), nil
+}
@@ -0,0 +1,273 @@
+package dao
+
+import (
+	"api/customer"
+	"api/mailing"
+	"api/outbox"
+	"api/postgresql"
+	"api/suppression"
+	"api/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recordTimeout bounds the recording of what became of a message or a delivery, once sent
+const recordTimeout = 5 * time.Second
+
+type (
+	OutboxDao interface {
+		// Dispatch hands up to limit pending messages due for an attempt over to send, one by one, and records
+		// whether they were sent. Each message is claimed for outbox.ClaimLease, and sent outside any transaction.
+		// Once sent, or failed, it is recorded in a transaction of its own, even if ctx is done meanwhile. The action
+		// is applied to the customer of every sent message. Failed messages are attempted again as the policy says,
+		// and copied to the dead letters once it gives up on them. Messages failing with outbox.ErrBounced are not
+		// attempted again, and their recipient is suppressed.
+		// It returns how many messages were sent and how many failed, and may return an *Error.
+		Dispatch(ctx context.Context, limit int, policy outbox.RetryPolicy, action outbox.PostSendAction,
+			send func(context.Context, *outbox.Message) error) (int, int, error)
+
+		// DeadLetters retrieves a page of the dead letters of a mailing, along with the cursor of the next page,
+		// empty on the last one. It may return ErrNotFound, ErrInvalidQuery or any other *Error.
+		DeadLetters(ctx context.Context, mailingID int64, params PageParams) ([]outbox.DeadLetter, string, error)
+
+		// Requeue makes the messages of the given dead letters of a mailing, or of all of them if ids is nil, pending
+		// again, and removes the dead letters. The messages are traced by requestID from now on.
+		// It returns how many messages were requeued, and may return ErrNotFound, ErrInvalidState or any other *Error.
+		Requeue(ctx context.Context, mailingID int64, ids []uint, requestID string) (int64, error)
+
+		// Counts counts the messages of a mailing by status, every status included. It may return an *Error.
+		Counts(ctx context.Context, mailingID int64) (map[string]int64, error)
+
+		// Messages retrieves a page of the messages of a mailing or a customer, even a deleted one, along with the
+		// cursor of the next page, empty on the last one. It may return ErrNotFound, ErrInvalidQuery or any other
+		// *Error.
+		Messages(ctx context.Context, params MessageFindParams) ([]outbox.Message, string, error)
+	}
+
+	// PageParams holds the pagination of a call listing rows by id
+	PageParams struct {
+		// Cursor is the opaque value returned as next cursor by the previous page.
+		Cursor string
+		Limit  int
+	}
+
+	// MessageFindParams holds the filter and pagination of an OutboxDao.Messages call
+	MessageFindParams struct {
+		MailingID  *int64
+		CustomerID *uint
+		Status     string
+		// Cursor is the opaque value returned as next cursor by the previous page.
+		Cursor string
+		Limit  int
+	}
+
+	OutboxDAO struct {
+		Db postgresql.Db
+	}
+
+	// detached is a context keeping the values of its parent, but neither its deadline nor its cancellation
+	detached struct {
+		context.Context
+	}
+)
+
+func (dao *OutboxDAO) Dispatch(ctx context.Context, limit int, policy outbox.RetryPolicy, action outbox.PostSendAction,
+	send func(context.Context, *outbox.Message) error) (int, int, error) {
+	sent, failed := 0, 0
+	for i := 0; i < limit; i++ {
+		m, tx := dao.Db.WithContext(ctx).ClaimNext(outbox.ClaimLease)
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			break
+		}
+		if tx.Error != nil {
+			return sent, failed, wrap("claim", tx.Error)
+		}
+
+		// a crash from here on leaves the message claimed until the lease is over, so that it is sent again rather
+		// than lost
+		sendErr := send(ctx, &m)
+		// the outcome is recorded even if ctx is done meanwhile, lest a message sent be sent again
+		record, cancel := context.WithTimeout(detach(ctx), recordTimeout)
+		err := dao.Db.WithContext(record).Transaction(func(db postgresql.Db) error {
+			if errors.Is(sendErr, outbox.ErrBounced) {
+				return bounce(db, &m, sendErr.Error())
+			}
+			if sendErr != nil {
+				return retry(db, policy, &m, sendErr.Error())
+			}
+			if err := db.MarkSent(m.ID).Error; err != nil {
+				return err
+			}
+			if action != outbox.PostSendDelete {
+				return nil
+			}
+			var c customer.Customer
+			tx := db.Delete(&c, int64(m.CustomerID))
+			if tx.Error != nil || tx.RowsAffected == 0 {
+				return tx.Error
+			}
+			return enqueue(db, webhook.EventCustomerDeleted, m.RequestID, &c)
+		})
+		cancel()
+		if err != nil {
+			return sent, failed, wrap("dispatch", err)
+		}
+		if sendErr != nil {
+			failed++
+		} else {
+			sent++
+		}
+	}
+	return sent, failed, nil
+}
+
+func (dao *OutboxDAO) DeadLetters(ctx context.Context, mailingID int64,
+	params PageParams) ([]outbox.DeadLetter, string, error) {
+	limit, after, err := page(params)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, tx := dao.Db.WithContext(ctx).FirstMailing(mailingID); tx.Error != nil {
+		return nil, "", wrap("first mailing", tx.Error)
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ls, tx := dao.Db.WithContext(ctx).FindDeadLetters(mailingID, after, limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find dead letters", tx.Error)
+	}
+	if len(ls) <= limit {
+		return ls, "", nil
+	}
+	ls = ls[:limit]
+	return ls, idCursor(ls[limit-1].ID), nil
+}
+
+func (dao *OutboxDAO) Requeue(ctx context.Context, mailingID int64, ids []uint, requestID string) (int64, error) {
+	var requeued int64
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		m, tx := db.FirstMailing(mailingID)
+		if tx.Error != nil {
+			return wrap("first mailing", tx.Error)
+		}
+		if m.Status != mailing.StatusSending && m.Status != mailing.StatusSent {
+			return fmt.Errorf("%w: the messages of a %s mailing cannot be requeued", ErrInvalidState, m.Status)
+		}
+
+		tx = db.Requeue(mailingID, ids, requestID)
+		if tx.Error != nil {
+			return wrap("requeue", tx.Error)
+		}
+		requeued = tx.RowsAffected
+		return wrap("delete dead letters", db.DeleteDeadLetters(mailingID, ids).Error)
+	})
+	return requeued, err
+}
+
+func (dao *OutboxDAO) Counts(ctx context.Context, mailingID int64) (map[string]int64, error) {
+	found, tx := dao.Db.WithContext(ctx).CountMessages(mailingID)
+	if tx.Error != nil {
+		return nil, wrap("count messages", tx.Error)
+	}
+	counts := make(map[string]int64, len(outbox.Statuses))
+	for _, s := range outbox.Statuses {
+		counts[s] = found[s]
+	}
+	return counts, nil
+}
+
+func (dao *OutboxDAO) Messages(ctx context.Context, params MessageFindParams) ([]outbox.Message, string, error) {
+	limit, after, err := page(PageParams{Cursor: params.Cursor, Limit: params.Limit})
+	if err != nil {
+		return nil, "", err
+	}
+	if params.Status != "" && !isStatus(params.Status) {
+		return nil, "", fmt.Errorf("%w: unknown status %s", ErrInvalidQuery, params.Status)
+	}
+	if params.MailingID != nil {
+		if _, tx := dao.Db.WithContext(ctx).FirstMailing(*params.MailingID); tx.Error != nil {
+			return nil, "", wrap("first mailing", tx.Error)
+		}
+	}
+	if params.CustomerID != nil {
+		if _, tx := dao.Db.WithContext(ctx).FirstWithDeleted(int64(*params.CustomerID)); tx.Error != nil {
+			return nil, "", wrap("first customer", tx.Error)
+		}
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ms, tx := dao.Db.WithContext(ctx).FindMessages(postgresql.MessageQuery{
+		MailingID:  params.MailingID,
+		CustomerID: params.CustomerID,
+		Status:     params.Status,
+		After:      after,
+		Limit:      limit + 1,
+	})
+	if tx.Error != nil {
+		return nil, "", wrap("find messages", tx.Error)
+	}
+	if len(ms) <= limit {
+		return ms, "", nil
+	}
+	ms = ms[:limit]
+	return ms, idCursor(ms[limit-1].ID), nil
+}
+
+// isStatus tells whether s is a status of outbox.Message
+func isStatus(s string) bool {
+	for _, status := range outbox.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retry records a failed attempt to send a message: it is left pending until its next attempt is due, or copied to
+// the dead letters if the policy gives up on it.
+func retry(db postgresql.Db, policy outbox.RetryPolicy, m *outbox.Message, reason string) error {
+	attempts := m.Attempts + 1
+	if !policy.Exhausted(attempts) {
+		at := time.Now().Add(policy.Backoff(attempts))
+		return db.Reschedule(m.ID, attempts, reason, at).Error
+	}
+	if err := db.MarkFailed(m.ID, attempts, reason).Error; err != nil {
+		return err
+	}
+	letter := outbox.NewDeadLetter(m, attempts, reason)
+	return db.CreateDeadLetter(&letter).Error
+}
+
+// bounce records that the recipient of a message was rejected for good, and suppresses it unless it already is
+func bounce(db postgresql.Db, m *outbox.Message, reason string) error {
+	if err := db.MarkBounced(m.ID, m.Attempts+1, reason).Error; err != nil {
+		return err
+	}
+	email := suppression.Normalize(m.Recipient)
+	_, tx := db.FirstSuppression(email)
+	if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return tx.Error
+	}
+	return suppress(db, &suppression.Suppression{Email: email, Reason: suppression.ReasonBounced, MailingID: m.MailingID})
+}
+
+// detach returns a context holding the values of ctx, which is never done
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
@@ -0,0 +1,519 @@
+package dao
+
+import (
+	"api/customer"
+	"api/mailing"
+	"api/outbox"
+	"api/postgresql"
+	"api/suppression"
+	"api/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestOutboxDAO_Dispatch(t *testing.T) {
+	message := outbox.Message{ID: 1, CustomerID: 5, Recipient: "a@example.com", Status: outbox.StatusPending}
+	lastAttempt := outbox.Message{ID: 1, OperationID: "op", RequestID: "req", CustomerID: 5, MailingID: 7,
+		Recipient: "a@example.com", Subject: "Hi", Status: outbox.StatusPending, Attempts: 2}
+	policy := outbox.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	tests := map[string]struct {
+		db             *postgresql.DataBaseMock
+		limit          int
+		action         outbox.PostSendAction
+		sendErr        error
+		expectedSent   int
+		expectedFailed int
+		withError      error
+	}{
+		"sent and deleted": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(outbox.Message{}, gorm.ErrRecordNotFound).Once()
+				m.On("Transaction").Return().Once()
+				m.On("MarkSent", uint(1)).Return(nil)
+				m.On("Delete", &customer.Customer{}, int64(5)).Return(int64(1), nil)
+				m.On("Subscribers", webhook.EventCustomerDeleted).Return([]webhook.Subscription(nil), nil)
+				return &m
+			}(),
+			limit:        10,
+			action:       outbox.PostSendDelete,
+			expectedSent: 1,
+		},
+		"sent and kept": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(outbox.Message{}, gorm.ErrRecordNotFound).Once()
+				m.On("Transaction").Return().Once()
+				m.On("MarkSent", uint(1)).Return(nil)
+				return &m
+			}(),
+			limit:        10,
+			action:       outbox.PostSendKeep,
+			expectedSent: 1,
+		},
+		"send failure, rescheduled": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("Reschedule", uint(1), 1, "550 mailbox unavailable", mock.MatchedBy(func(at time.Time) bool {
+					wait := time.Until(at)
+					return wait > 29*time.Second && wait <= time.Minute
+				})).Return(nil)
+				return &m
+			}(),
+			limit:          1,
+			action:         outbox.PostSendDelete,
+			sendErr:        errors.New("550 mailbox unavailable"),
+			expectedFailed: 1,
+		},
+		"send failure, dead letter": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(lastAttempt, nil).Once()
+				m.On("MarkFailed", uint(1), 3, "550 mailbox unavailable").Return(nil)
+				m.On("CreateDeadLetter", &outbox.DeadLetter{MessageID: 1, OperationID: "op", RequestID: "req",
+					CustomerID: 5, MailingID: 7, Recipient: "a@example.com", Subject: "Hi", Attempts: 3,
+					Error: "550 mailbox unavailable"}).Return(nil)
+				return &m
+			}(),
+			limit:          1,
+			action:         outbox.PostSendDelete,
+			sendErr:        errors.New("550 mailbox unavailable"),
+			expectedFailed: 1,
+		},
+		"bounced": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(lastAttempt, nil).Once()
+				m.On("MarkBounced", uint(1), 3, "bounced: 550 no such user").Return(nil)
+				m.On("FirstSuppression", "a@example.com").Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("CreateSuppression", &suppression.Suppression{Email: "a@example.com",
+					Reason: suppression.ReasonBounced, MailingID: 7}).Return(nil)
+				m.On("SuppressPending", "a@example.com").Return(int64(0), nil)
+				return &m
+			}(),
+			limit:          1,
+			action:         outbox.PostSendDelete,
+			sendErr:        fmt.Errorf("%w: 550 no such user", outbox.ErrBounced),
+			expectedFailed: 1,
+		},
+		"bounced, suppressed already": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("MarkBounced", uint(1), 1, "bounced: 550 no such user").Return(nil)
+				m.On("FirstSuppression", "a@example.com").Return(suppression.Suppression{Email: "a@example.com"}, nil)
+				return &m
+			}(),
+			limit:          1,
+			action:         outbox.PostSendDelete,
+			sendErr:        fmt.Errorf("%w: 550 no such user", outbox.ErrBounced),
+			expectedFailed: 1,
+		},
+		"limit reached": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Twice()
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Twice()
+				m.On("MarkSent", uint(1)).Return(nil).Twice()
+				return &m
+			}(),
+			limit:        2,
+			action:       outbox.PostSendKeep,
+			expectedSent: 2,
+		},
+		"nothing pending, no transaction": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimNext", outbox.ClaimLease).Return(outbox.Message{}, gorm.ErrRecordNotFound).Once()
+				return &m
+			}(),
+			limit: 10,
+		},
+		"claim error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimNext", outbox.ClaimLease).Return(outbox.Message{}, fmt.Errorf("an error")).Once()
+				return &m
+			}(),
+			limit:     10,
+			withError: ErrPg,
+		},
+		"dead letter error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(lastAttempt, nil).Once()
+				m.On("MarkFailed", uint(1), 3, "550 mailbox unavailable").Return(nil)
+				m.On("CreateDeadLetter", mock.Anything).Return(fmt.Errorf("an error"))
+				return &m
+			}(),
+			limit:     1,
+			sendErr:   errors.New("550 mailbox unavailable"),
+			withError: ErrPg,
+		},
+		"bounce error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("MarkBounced", uint(1), 1, "bounced: 550 no such user").Return(fmt.Errorf("an error"))
+				return &m
+			}(),
+			limit:     1,
+			sendErr:   fmt.Errorf("%w: 550 no such user", outbox.ErrBounced),
+			withError: ErrPg,
+		},
+		"post-send error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return().Once()
+				m.On("ClaimNext", outbox.ClaimLease).Return(message, nil).Once()
+				m.On("MarkSent", uint(1)).Return(nil)
+				m.On("Delete", &customer.Customer{}, int64(5)).Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			limit:     10,
+			action:    outbox.PostSendDelete,
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var sent []string
+			send := func(_ context.Context, m *outbox.Message) error {
+				sent = append(sent, m.Recipient)
+				return test.sendErr
+			}
+
+			dao := OutboxDAO{Db: test.db}
+			sentCount, failedCount, err := dao.Dispatch(context.Background(), test.limit, policy, test.action, send)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedSent, sentCount)
+			assert.Equal(t, test.expectedFailed, failedCount)
+			assert.Len(t, sent, test.expectedSent+test.expectedFailed)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOutboxDAO_Dispatch_cancelled(t *testing.T) {
+	m := &postgresql.DataBaseMock{}
+	m.On("ClaimNext", outbox.ClaimLease).Return(outbox.Message{ID: 1, CustomerID: 5}, nil).Once()
+	m.On("Transaction").Return().Once()
+	m.On("MarkSent", uint(1)).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the run is cancelled, e.g. by a shutdown, once the message is sent but before it is recorded
+	send := func(context.Context, *outbox.Message) error {
+		cancel()
+		return nil
+	}
+
+	sent, failed, err := (&OutboxDAO{Db: m}).Dispatch(ctx, 1, outbox.DefaultRetryPolicy, outbox.PostSendKeep, send)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, 0, failed)
+	m.AssertExpectations(t)
+}
+
+func TestOutboxDAO_DeadLetters(t *testing.T) {
+	letters := []outbox.DeadLetter{{ID: 1, MailingID: 7}, {ID: 2, MailingID: 7}, {ID: 3, MailingID: 7}}
+
+	tests := map[string]struct {
+		db           *postgresql.DataBaseMock
+		params       PageParams
+		expected     []outbox.DeadLetter
+		expectedNext string
+		withError    error
+	}{
+		"last page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindDeadLetters", int64(7), uint(0), DefaultLimit+1).Return(letters, nil)
+				return &m
+			}(),
+			expected: letters,
+		},
+		"next page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindDeadLetters", int64(7), uint(1), 3).Return(letters, nil)
+				return &m
+			}(),
+			params:       PageParams{Cursor: idCursor(1), Limit: 2},
+			expected:     letters[:2],
+			expectedNext: idCursor(2),
+		},
+		"malformed cursor": {
+			db:        &postgresql.DataBaseMock{},
+			params:    PageParams{Cursor: "%%"},
+			withError: ErrInvalidQuery,
+		},
+		"mailing not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindDeadLetters", int64(7), uint(0), DefaultLimit+1).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := OutboxDAO{Db: test.db}
+			found, next, err := dao.DeadLetters(context.Background(), 7, test.params)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, found)
+			assert.Equal(t, test.expectedNext, next)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOutboxDAO_Requeue(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		ids       []uint
+		requeued  int64
+		withError error
+	}{
+		"all of them": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSent}, nil)
+				m.On("Requeue", int64(7), []uint(nil), "req").Return(int64(3), nil)
+				m.On("DeleteDeadLetters", int64(7), []uint(nil)).Return(int64(3), nil)
+				return &m
+			}(),
+			requeued: 3,
+		},
+		"some of them": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSending}, nil)
+				m.On("Requeue", int64(7), []uint{2}, "req").Return(int64(1), nil)
+				m.On("DeleteDeadLetters", int64(7), []uint{2}).Return(int64(1), nil)
+				return &m
+			}(),
+			ids:      []uint{2},
+			requeued: 1,
+		},
+		"cancelled mailing": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusCancelled}, nil)
+				return &m
+			}(),
+			withError: ErrInvalidState,
+		},
+		"mailing not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7, Status: mailing.StatusSent}, nil)
+				m.On("Requeue", int64(7), []uint(nil), "req").Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := OutboxDAO{Db: test.db}
+			requeued, err := dao.Requeue(context.Background(), 7, test.ids, "req")
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.requeued, requeued)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOutboxDAO_Counts(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		expected  map[string]int64
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("CountMessages", int64(7)).
+					Return(map[string]int64{outbox.StatusSent: 3, outbox.StatusBounced: 1}, nil)
+				return &m
+			}(),
+			expected: map[string]int64{
+				outbox.StatusPending:    0,
+				outbox.StatusSent:       3,
+				outbox.StatusFailed:     0,
+				outbox.StatusBounced:    1,
+				outbox.StatusSuppressed: 0,
+				outbox.StatusCancelled:  0,
+			},
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("CountMessages", int64(7)).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := OutboxDAO{Db: test.db}
+			counts, err := dao.Counts(context.Background(), 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, counts)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOutboxDAO_Messages(t *testing.T) {
+	mailingID, customerID := int64(7), uint(5)
+	messages := []outbox.Message{{ID: 1, MailingID: 7}, {ID: 2, MailingID: 7}, {ID: 3, MailingID: 7}}
+
+	tests := map[string]struct {
+		db           *postgresql.DataBaseMock
+		params       MessageFindParams
+		expected     []outbox.Message
+		expectedNext string
+		withError    error
+	}{
+		"mailing, last page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindMessages", postgresql.MessageQuery{MailingID: &mailingID, Limit: DefaultLimit + 1}).
+					Return(messages, nil)
+				return &m
+			}(),
+			params:   MessageFindParams{MailingID: &mailingID},
+			expected: messages,
+		},
+		"mailing, next page by status": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindMessages", postgresql.MessageQuery{MailingID: &mailingID, Status: outbox.StatusSent,
+					After: 1, Limit: 3}).Return(messages, nil)
+				return &m
+			}(),
+			params: MessageFindParams{MailingID: &mailingID, Status: outbox.StatusSent, Cursor: idCursor(1),
+				Limit: 2},
+			expected:     messages[:2],
+			expectedNext: idCursor(2),
+		},
+		"deleted customer": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstWithDeleted", int64(5)).Return(customer.Customer{Model: gorm.Model{ID: 5}}, nil)
+				m.On("FindMessages", postgresql.MessageQuery{CustomerID: &customerID, Limit: DefaultLimit + 1}).
+					Return(messages[:1], nil)
+				return &m
+			}(),
+			params:   MessageFindParams{CustomerID: &customerID},
+			expected: messages[:1],
+		},
+		"unknown status": {
+			db:        &postgresql.DataBaseMock{},
+			params:    MessageFindParams{MailingID: &mailingID, Status: "queued"},
+			withError: ErrInvalidQuery,
+		},
+		"customer not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstWithDeleted", int64(5)).Return(customer.Customer{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			params:    MessageFindParams{CustomerID: &customerID},
+			withError: ErrNotFound,
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstMailing", int64(7)).Return(mailing.Mailing{ID: 7}, nil)
+				m.On("FindMessages", mock.Anything).Return(nil, fmt.Errorf("an error"))
+				return &m
+			}(),
+			params:    MessageFindParams{MailingID: &mailingID},
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := OutboxDAO{Db: test.db}
+			found, next, err := dao.Messages(context.Background(), test.params)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, found)
+			assert.Equal(t, test.expectedNext, next)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
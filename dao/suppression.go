@@ -0,0 +1,141 @@
+package dao
+
+import (
+	"api/postgresql"
+	"api/suppression"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type (
+	SuppressionDao interface {
+		// Create suppresses an email address, normalizing it, and stops the messages pending to it.
+		// It may return ErrConflict if it is suppressed already, or any other *Error.
+		Create(ctx context.Context, s *suppression.Suppression) error
+
+		// First retrieves the suppression of an email address. It may return ErrNotFound or any other *Error.
+		First(ctx context.Context, email string) (*suppression.Suppression, error)
+
+		// Find retrieves a page of suppressions ordered by email address, along with the cursor of the next page,
+		// empty on the last one. It may return ErrInvalidQuery or an *Error.
+		Find(ctx context.Context, params PageParams) ([]suppression.Suppression, string, error)
+
+		// Delete lifts the suppression of an email address. It may return ErrNotFound or any other *Error.
+		Delete(ctx context.Context, email string) error
+
+		// Recipient returns the email address of a customer, even if it was deleted, and whether it is suppressed.
+		// It may return ErrNotFound or any other *Error.
+		Recipient(ctx context.Context, customerID uint) (string, bool, error)
+
+		// Unsubscribe suppresses the email address of a customer, even if it was deleted, on behalf of a mailing.
+		// Unsubscribing twice is not an error. It returns the suppression, and may return ErrNotFound or any
+		// other *Error.
+		Unsubscribe(ctx context.Context, customerID uint, mailingID int64) (*suppression.Suppression, error)
+	}
+
+	SuppressionDAO struct {
+		Db postgresql.Db
+	}
+)
+
+func (dao *SuppressionDAO) Create(ctx context.Context, s *suppression.Suppression) error {
+	s.Email = suppression.Normalize(s.Email)
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		return suppress(db, s)
+	})
+}
+
+func (dao *SuppressionDAO) First(ctx context.Context, email string) (*suppression.Suppression, error) {
+	s, tx := dao.Db.WithContext(ctx).FirstSuppression(suppression.Normalize(email))
+	if tx.Error != nil {
+		return nil, wrap("first suppression", tx.Error)
+	}
+	return &s, nil
+}
+
+func (dao *SuppressionDAO) Find(ctx context.Context, params PageParams) ([]suppression.Suppression, string, error) {
+	limit := params.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 0 || limit > MaxLimit {
+		return nil, "", fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidQuery, MaxLimit)
+	}
+	after, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ss, tx := dao.Db.WithContext(ctx).FindSuppressions(string(after), limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find suppressions", tx.Error)
+	}
+	if len(ss) <= limit {
+		return ss, "", nil
+	}
+	ss = ss[:limit]
+	return ss, base64.RawURLEncoding.EncodeToString([]byte(ss[limit-1].Email)), nil
+}
+
+func (dao *SuppressionDAO) Delete(ctx context.Context, email string) error {
+	tx := dao.Db.WithContext(ctx).DeleteSuppression(suppression.Normalize(email))
+	if tx.Error != nil {
+		return wrap("delete suppression", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return &Error{Kind: ErrNotFound, Op: "delete suppression", Err: fmt.Errorf("%s is not suppressed", email)}
+	}
+	return nil
+}
+
+func (dao *SuppressionDAO) Recipient(ctx context.Context, customerID uint) (string, bool, error) {
+	c, tx := dao.Db.WithContext(ctx).FirstWithDeleted(int64(customerID))
+	if tx.Error != nil {
+		return "", false, wrap("first customer", tx.Error)
+	}
+	_, tx = dao.Db.WithContext(ctx).FirstSuppression(suppression.Normalize(c.Email))
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return c.Email, false, nil
+	}
+	return c.Email, tx.Error == nil, wrap("first suppression", tx.Error)
+}
+
+func (dao *SuppressionDAO) Unsubscribe(ctx context.Context, customerID uint,
+	mailingID int64) (*suppression.Suppression, error) {
+	var s suppression.Suppression
+	err := dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		c, tx := db.FirstWithDeleted(int64(customerID))
+		if tx.Error != nil {
+			return wrap("first customer", tx.Error)
+		}
+		email := suppression.Normalize(c.Email)
+
+		found, tx := db.FirstSuppression(email)
+		if tx.Error == nil {
+			s = found
+			return nil
+		}
+		if !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			return wrap("first suppression", tx.Error)
+		}
+		s = suppression.Suppression{Email: email, Reason: suppression.ReasonUnsubscribed, MailingID: mailingID}
+		return suppress(db, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// suppress stores a suppression and marks the messages pending to its email address as suppressed
+func suppress(db postgresql.Db, s *suppression.Suppression) error {
+	if err := db.CreateSuppression(s).Error; err != nil {
+		return wrap("create suppression", err)
+	}
+	return wrap("suppress pending", db.SuppressPending(s.Email).Error)
+}
@@ -0,0 +1,274 @@
+package dao
+
+import (
+	"api/customer"
+	"api/postgresql"
+	"api/suppression"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestSuppressionDAO_Create(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CreateSuppression", &suppression.Suppression{Email: "hello@example.com", Reason: "manual"}).Return(nil)
+				m.On("SuppressPending", "hello@example.com").Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"already suppressed": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CreateSuppression", &suppression.Suppression{Email: "hello@example.com", Reason: "manual"}).
+					Return(&pgconn.PgError{Code: "23505", ConstraintName: "suppressions_pkey"})
+				return &m
+			}(),
+			withError: ErrConflict,
+		},
+		"cancel error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("CreateSuppression", &suppression.Suppression{Email: "hello@example.com", Reason: "manual"}).Return(nil)
+				m.On("SuppressPending", "hello@example.com").Return(int64(0), fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := SuppressionDAO{Db: test.db}
+			err := dao.Create(context.Background(), &suppression.Suppression{Email: " Hello@Example.com", Reason: "manual"})
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSuppressionDAO_Find(t *testing.T) {
+	suppressions := []suppression.Suppression{{Email: "a@example.com"}, {Email: "b@example.com"}, {Email: "c@example.com"}}
+
+	tests := map[string]struct {
+		db           *postgresql.DataBaseMock
+		params       PageParams
+		expected     []suppression.Suppression
+		expectedNext string
+		withError    error
+	}{
+		"last page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindSuppressions", "", DefaultLimit+1).Return(suppressions, nil)
+				return &m
+			}(),
+			expected: suppressions,
+		},
+		"next page": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FindSuppressions", "0@example.com", 3).Return(suppressions, nil)
+				return &m
+			}(),
+			params:       PageParams{Cursor: base64.RawURLEncoding.EncodeToString([]byte("0@example.com")), Limit: 2},
+			expected:     suppressions[:2],
+			expectedNext: base64.RawURLEncoding.EncodeToString([]byte("b@example.com")),
+		},
+		"malformed cursor": {
+			db:        &postgresql.DataBaseMock{},
+			params:    PageParams{Cursor: "%%"},
+			withError: ErrInvalidQuery,
+		},
+		"invalid limit": {
+			db:        &postgresql.DataBaseMock{},
+			params:    PageParams{Limit: MaxLimit + 1},
+			withError: ErrInvalidQuery,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := SuppressionDAO{Db: test.db}
+			found, next, err := dao.Find(context.Background(), test.params)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, found)
+			assert.Equal(t, test.expectedNext, next)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSuppressionDAO_Delete(t *testing.T) {
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("DeleteSuppression", "hello@example.com").Return(int64(1), nil)
+				return &m
+			}(),
+		},
+		"not suppressed": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("DeleteSuppression", "hello@example.com").Return(int64(0), nil)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := SuppressionDAO{Db: test.db}
+			err := dao.Delete(context.Background(), "Hello@example.com")
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSuppressionDAO_Unsubscribe(t *testing.T) {
+	deleted := customer.Customer{Model: gorm.Model{ID: 5}, Email: "Hello@example.com"}
+	expected := &suppression.Suppression{Email: "hello@example.com", Reason: suppression.ReasonUnsubscribed, MailingID: 7}
+
+	tests := map[string]struct {
+		db        *postgresql.DataBaseMock
+		withError error
+	}{
+		"OK": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstWithDeleted", int64(5)).Return(deleted, nil)
+				m.On("FirstSuppression", "hello@example.com").Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				m.On("CreateSuppression", expected).Return(nil)
+				m.On("SuppressPending", "hello@example.com").Return(int64(0), nil)
+				return &m
+			}(),
+		},
+		"unsubscribed already": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstWithDeleted", int64(5)).Return(deleted, nil)
+				m.On("FirstSuppression", "hello@example.com").Return(*expected, nil)
+				return &m
+			}(),
+		},
+		"customer not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstWithDeleted", int64(5)).Return(customer.Customer{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+		"database error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("Transaction").Return()
+				m.On("FirstWithDeleted", int64(5)).Return(deleted, nil)
+				m.On("FirstSuppression", "hello@example.com").Return(suppression.Suppression{}, fmt.Errorf("an error"))
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := SuppressionDAO{Db: test.db}
+			s, err := dao.Unsubscribe(context.Background(), 5, 7)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, expected, s)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSuppressionDAO_Recipient(t *testing.T) {
+	deleted := customer.Customer{Model: gorm.Model{ID: 5}, Email: "hello@example.com"}
+
+	tests := map[string]struct {
+		db         *postgresql.DataBaseMock
+		suppressed bool
+		withError  error
+	}{
+		"subscribed": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstWithDeleted", int64(5)).Return(deleted, nil)
+				m.On("FirstSuppression", "hello@example.com").Return(suppression.Suppression{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+		},
+		"suppressed": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstWithDeleted", int64(5)).Return(deleted, nil)
+				m.On("FirstSuppression", "hello@example.com").Return(suppression.Suppression{Email: "hello@example.com"}, nil)
+				return &m
+			}(),
+			suppressed: true,
+		},
+		"customer not found": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("FirstWithDeleted", int64(5)).Return(customer.Customer{}, gorm.ErrRecordNotFound)
+				return &m
+			}(),
+			withError: ErrNotFound,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			dao := SuppressionDAO{Db: test.db}
+			email, suppressed, err := dao.Recipient(context.Background(), 5)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "hello@example.com", email)
+			assert.Equal(t, test.suppressed, suppressed)
+			test.db.AssertExpectations(t)
+		})
+	}
+}
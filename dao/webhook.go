@@ -0,0 +1,233 @@
+package dao
+
+import (
+	"api/outbox"
+	"api/postgresql"
+	"api/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	WebhookDao interface {
+		// Create creates a new webhook.Subscription. It may return an *Error.
+		Create(ctx context.Context, s *webhook.Subscription) error
+
+		// First retrieves a webhook.Subscription by primary key. It may return ErrNotFound or any other *Error.
+		First(ctx context.Context, id uint) (*webhook.Subscription, error)
+
+		// Find retrieves a page of webhook subscriptions, along with the cursor of the next page, empty on the last
+		// one. It may return ErrInvalidQuery or an *Error.
+		Find(ctx context.Context, params PageParams) ([]webhook.Subscription, string, error)
+
+		// Update overwrites the URL, events and secret of a webhook.Subscription.
+		// It may return ErrNotFound or any other *Error.
+		Update(ctx context.Context, s *webhook.Subscription) error
+
+		// Delete deletes a webhook.Subscription along with its deliveries.
+		// It may return ErrNotFound or any other *Error.
+		Delete(ctx context.Context, id uint) error
+
+		// Deliveries retrieves a page of the deliveries to a subscription, along with the cursor of the next page,
+		// empty on the last one. It may return ErrNotFound, ErrInvalidQuery or any other *Error.
+		Deliveries(ctx context.Context, subscriptionID uint, params DeliveryFindParams) ([]webhook.Delivery, string,
+			error)
+
+		// Deliver hands up to limit pending deliveries due for an attempt over to send, one by one, and records the
+		// status their subscriber answered with. Each delivery is claimed for webhook.ClaimLease, and sent outside any
+		// transaction. Its outcome is then recorded, even if ctx is done meanwhile. Failed deliveries are attempted
+		// again as the policy says, and marked failed once it gives up on them.
+		// It returns how many deliveries were delivered and how many failed, and may return an *Error.
+		Deliver(ctx context.Context, limit int, policy outbox.RetryPolicy,
+			send func(context.Context, *webhook.Subscription, *webhook.Delivery) (int, error)) (int, int, error)
+	}
+
+	// DeliveryFindParams holds the filter and pagination of a WebhookDao.Deliveries call
+	DeliveryFindParams struct {
+		Status string
+		// Cursor is the opaque value returned as next cursor by the previous page.
+		Cursor string
+		Limit  int
+	}
+
+	WebhookDAO struct {
+		Db postgresql.Db
+	}
+)
+
+func (dao *WebhookDAO) Create(ctx context.Context, s *webhook.Subscription) error {
+	return wrap("create subscription", dao.Db.WithContext(ctx).CreateSubscription(s).Error)
+}
+
+func (dao *WebhookDAO) First(ctx context.Context, id uint) (*webhook.Subscription, error) {
+	s, tx := dao.Db.WithContext(ctx).FirstSubscription(id)
+	if tx.Error != nil {
+		return nil, wrap("first subscription", tx.Error)
+	}
+	return &s, nil
+}
+
+func (dao *WebhookDAO) Find(ctx context.Context, params PageParams) ([]webhook.Subscription, string, error) {
+	limit, after, err := page(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ss, tx := dao.Db.WithContext(ctx).FindSubscriptions(after, limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find subscriptions", tx.Error)
+	}
+	if len(ss) <= limit {
+		return ss, "", nil
+	}
+	ss = ss[:limit]
+	return ss, idCursor(ss[limit-1].ID), nil
+}
+
+func (dao *WebhookDAO) Update(ctx context.Context, s *webhook.Subscription) error {
+	tx := dao.Db.WithContext(ctx).UpdateSubscription(s)
+	if tx.Error != nil {
+		return wrap("update subscription", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return &Error{Kind: ErrNotFound, Op: "update subscription", Err: fmt.Errorf("no subscription with id %d", s.ID)}
+	}
+	return nil
+}
+
+func (dao *WebhookDAO) Delete(ctx context.Context, id uint) error {
+	return dao.Db.WithContext(ctx).Transaction(func(db postgresql.Db) error {
+		tx := db.DeleteSubscription(id)
+		if tx.Error != nil {
+			return wrap("delete subscription", tx.Error)
+		}
+		if tx.RowsAffected == 0 {
+			return &Error{Kind: ErrNotFound, Op: "delete subscription", Err: fmt.Errorf("no subscription with id %d", id)}
+		}
+		return wrap("delete deliveries", db.DeleteDeliveries(id).Error)
+	})
+}
+
+func (dao *WebhookDAO) Deliveries(ctx context.Context, subscriptionID uint,
+	params DeliveryFindParams) ([]webhook.Delivery, string, error) {
+	limit, after, err := page(PageParams{Cursor: params.Cursor, Limit: params.Limit})
+	if err != nil {
+		return nil, "", err
+	}
+	if params.Status != "" && !isDeliveryStatus(params.Status) {
+		return nil, "", fmt.Errorf("%w: unknown status %s", ErrInvalidQuery, params.Status)
+	}
+	if _, tx := dao.Db.WithContext(ctx).FirstSubscription(subscriptionID); tx.Error != nil {
+		return nil, "", wrap("first subscription", tx.Error)
+	}
+
+	// one extra row is requested to know whether there is a next page
+	ds, tx := dao.Db.WithContext(ctx).FindDeliveries(subscriptionID, params.Status, after, limit+1)
+	if tx.Error != nil {
+		return nil, "", wrap("find deliveries", tx.Error)
+	}
+	if len(ds) <= limit {
+		return ds, "", nil
+	}
+	ds = ds[:limit]
+	return ds, idCursor(ds[limit-1].ID), nil
+}
+
+func (dao *WebhookDAO) Deliver(ctx context.Context, limit int, policy outbox.RetryPolicy,
+	send func(context.Context, *webhook.Subscription, *webhook.Delivery) (int, error)) (int, int, error) {
+	delivered, failed := 0, 0
+	for i := 0; i < limit; i++ {
+		d, tx := dao.Db.WithContext(ctx).ClaimDelivery(webhook.ClaimLease)
+		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			break
+		}
+		if tx.Error != nil {
+			return delivered, failed, wrap("claim delivery", tx.Error)
+		}
+		s, tx := dao.Db.WithContext(ctx).FirstSubscription(d.SubscriptionID)
+		if tx.Error != nil {
+			return delivered, failed, wrap("first subscription", tx.Error)
+		}
+
+		// a crash from here on leaves the delivery claimed until the lease is over, so that the event is delivered
+		// again rather than lost
+		status, sendErr := send(ctx, &s, &d)
+		// the outcome is recorded even if ctx is done meanwhile, lest an event delivered be delivered again
+		record, cancel := context.WithTimeout(detach(ctx), recordTimeout)
+		err := dao.Db.WithContext(record).Transaction(func(db postgresql.Db) error {
+			return recordDelivery(db, policy, &d, status, sendErr)
+		})
+		cancel()
+		if err != nil {
+			return delivered, failed, wrap("deliver", err)
+		}
+		if sendErr != nil {
+			failed++
+		} else {
+			delivered++
+		}
+	}
+	return delivered, failed, nil
+}
+
+// enqueue queues a delivery of an event of the given type about every one of data to each subscriber of that type,
+// traced by requestID
+func enqueue(db postgresql.Db, eventType, requestID string, data ...interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	subscribers, tx := db.Subscribers(eventType)
+	if tx.Error != nil {
+		return wrap("subscribers", tx.Error)
+	}
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	ds := make([]webhook.Delivery, 0, len(data)*len(subscribers))
+	for _, d := range data {
+		event, err := webhook.NewEvent(eventType, d)
+		if err != nil {
+			return err
+		}
+		for _, s := range subscribers {
+			ds = append(ds, webhook.Delivery{
+				SubscriptionID: s.ID,
+				EventType:      eventType,
+				Event:          event,
+				RequestID:      requestID,
+				Status:         webhook.StatusPending,
+			})
+		}
+	}
+	return wrap("enqueue deliveries", db.CreateDeliveries(ds).Error)
+}
+
+// recordDelivery records the status the subscriber of a delivery answered with, and the error of sending it if any.
+// Failed deliveries are left pending until their next attempt is due, unless the policy gives up on them.
+func recordDelivery(db postgresql.Db, policy outbox.RetryPolicy, d *webhook.Delivery, status int, sendErr error) error {
+	attempts := d.Attempts + 1
+	if sendErr == nil {
+		return db.MarkDelivered(d.ID, attempts, status).Error
+	}
+	if !policy.Exhausted(attempts) {
+		at := time.Now().Add(policy.Backoff(attempts))
+		return db.RescheduleDelivery(d.ID, attempts, status, sendErr.Error(), at).Error
+	}
+	return db.MarkDeliveryFailed(d.ID, attempts, status, sendErr.Error()).Error
+}
+
+// isDeliveryStatus tells whether s is a status of webhook.Delivery
+func isDeliveryStatus(s string) bool {
+	for _, status := range webhook.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package dao
+
+import (
+	"api/outbox"
+	"api/postgresql"
+	"api/webhook"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestWebhookDAO_Deliver(t *testing.T) {
+	delivery := webhook.Delivery{ID: 1, SubscriptionID: 2, Status: webhook.StatusPending}
+	lastAttempt := webhook.Delivery{ID: 1, SubscriptionID: 2, Status: webhook.StatusPending, Attempts: 2}
+	subscription := webhook.Subscription{ID: 2, URL: "https://example.com/hook"}
+	policy := outbox.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+	tests := map[string]struct {
+		db                *postgresql.DataBaseMock
+		status            int
+		sendErr           error
+		expectedDelivered int
+		expectedFailed    int
+		withError         error
+	}{
+		"delivered": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(delivery, nil).Once()
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{}, gorm.ErrRecordNotFound).Once()
+				m.On("FirstSubscription", uint(2)).Return(subscription, nil)
+				m.On("Transaction").Return().Once()
+				m.On("MarkDelivered", uint(1), 1, 204).Return(nil)
+				return &m
+			}(),
+			status:            204,
+			expectedDelivered: 1,
+		},
+		"rescheduled": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(delivery, nil).Once()
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{}, gorm.ErrRecordNotFound).Once()
+				m.On("FirstSubscription", uint(2)).Return(subscription, nil)
+				m.On("Transaction").Return().Once()
+				m.On("RescheduleDelivery", uint(1), 1, 500, "an error", mock.Anything).Return(nil)
+				return &m
+			}(),
+			status:         500,
+			sendErr:        errors.New("an error"),
+			expectedFailed: 1,
+		},
+		"given up on": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(lastAttempt, nil).Once()
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{}, gorm.ErrRecordNotFound).Once()
+				m.On("FirstSubscription", uint(2)).Return(subscription, nil)
+				m.On("Transaction").Return().Once()
+				m.On("MarkDeliveryFailed", uint(1), 3, 0, "an error").Return(nil)
+				return &m
+			}(),
+			sendErr:        errors.New("an error"),
+			expectedFailed: 1,
+		},
+		"nothing pending, no transaction": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{}, gorm.ErrRecordNotFound).Once()
+				return &m
+			}(),
+		},
+		"claim error": {
+			db: func() *postgresql.DataBaseMock {
+				m := postgresql.DataBaseMock{}
+				m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{}, errors.New("an error")).Once()
+				return &m
+			}(),
+			withError: ErrPg,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var sent []uint
+			send := func(_ context.Context, _ *webhook.Subscription, d *webhook.Delivery) (int, error) {
+				sent = append(sent, d.ID)
+				return test.status, test.sendErr
+			}
+
+			dao := WebhookDAO{Db: test.db}
+			delivered, failed, err := dao.Deliver(context.Background(), 10, policy, send)
+			test.db.AssertExpectations(t)
+			if test.withError != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.withError))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedDelivered, delivered)
+			assert.Equal(t, test.expectedFailed, failed)
+			assert.Len(t, sent, test.expectedDelivered+test.expectedFailed)
+		})
+	}
+}
+
+func TestWebhookDAO_Deliver_cancelled(t *testing.T) {
+	m := &postgresql.DataBaseMock{}
+	m.On("ClaimDelivery", webhook.ClaimLease).Return(webhook.Delivery{ID: 1, SubscriptionID: 2}, nil).Once()
+	m.On("FirstSubscription", uint(2)).Return(webhook.Subscription{ID: 2}, nil)
+	m.On("Transaction").Return().Once()
+	m.On("MarkDelivered", uint(1), 1, 200).Return(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the run is cancelled once the event is delivered, but before it is recorded
+	send := func(context.Context, *webhook.Subscription, *webhook.Delivery) (int, error) {
+		cancel()
+		return 200, nil
+	}
+
+	delivered, _, err := (&WebhookDAO{Db: m}).Deliver(ctx, 1, outbox.DefaultRetryPolicy, send)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	m.AssertExpectations(t)
+}
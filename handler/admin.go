@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"api/cron"
+	"api/dao"
+	"api/job"
+	"api/leader"
+	"api/logging"
+	"api/problem"
+	"api/retention"
+	"api/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	adminHandler interface {
+		// PurgeCustomers handles POST /admin/purge
+		PurgeCustomers(*gin.Context)
+		// GetLeader handles GET /admin/leader
+		GetLeader(*gin.Context)
+		// FindJobs handles GET /admin/jobs
+		FindJobs(*gin.Context)
+		// GetJob handles GET /admin/jobs/:tag
+		GetJob(*gin.Context)
+		// RunJob handles POST /admin/jobs/:tag/run
+		RunJob(*gin.Context)
+		// PauseJob handles POST /admin/jobs/:tag/pause
+		PauseJob(*gin.Context)
+		// ResumeJob handles POST /admin/jobs/:tag/resume
+		ResumeJob(*gin.Context)
+		// SetJobInterval handles PUT /admin/jobs/:tag/interval
+		SetJobInterval(*gin.Context)
+		// FindJobRuns handles GET /admin/jobs/:tag/runs
+		FindJobRuns(*gin.Context)
+	}
+
+	// PurgeRequest is the optional body of POST /admin/purge. Without a grace period, the configured one applies.
+	PurgeRequest struct {
+		GracePeriod *string `json:"grace_period"`
+	}
+
+	// PurgeResponse tells how many customers were purged, those deleted before Before
+	PurgeResponse struct {
+		Purged int64     `json:"purged"`
+		Before time.Time `json:"before"`
+	}
+
+	// LeaderResponse tells which replica leads the cron jobs, until when unless it renews its lease, and whether it
+	// is the one answering
+	LeaderResponse struct {
+		leader.Lease
+		Replica string `json:"replica"`
+		Leading bool   `json:"leading"`
+	}
+
+	// FindJobsResponse lists the cron jobs as scheduled by the replica answering, telling whether it leads
+	FindJobsResponse struct {
+		Replica string     `json:"replica"`
+		Leading bool       `json:"leading"`
+		Jobs    []job.Info `json:"data"`
+	}
+
+	// JobIntervalRequest is the body of PUT /admin/jobs/:tag/interval
+	JobIntervalRequest struct {
+		// Interval is a whole number of seconds, as understood by time.ParseDuration, e.g. "30s"
+		Interval string `json:"interval"`
+	}
+
+	// FindJobRunsRequest holds the query parameters of GET /admin/jobs/:tag/runs
+	FindJobRunsRequest struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindJobRunsResponse is a page of the runs of a job, the latest first. NextCursor is empty on the last page.
+	FindJobRunsResponse struct {
+		Runs       []job.Run `json:"data"`
+		NextCursor string    `json:"next_cursor,omitempty"`
+	}
+
+	// AdminHandler serves the maintenance operations of the service
+	AdminHandler struct {
+		Customers dao.CustomerDao
+		Leases    dao.LeaseDao
+		// Runs is the history of the cron jobs
+		Runs  dao.JobDao
+		Log   *logging.Logger
+		Purge retention.Purge
+		// Replica identifies this replica among the ones campaigning for leadership
+		Replica string
+		// Jobs are the cron jobs scheduled by this replica
+		Jobs *cron.Jobs
+	}
+)
+
+// NewAdminHandler returns an AdminHandler on the data access objects of store, managing jobs, scheduled by replica,
+// and purging by purge unless told otherwise
+func NewAdminHandler(store *dao.Store, log *logging.Logger, purge retention.Purge, replica string,
+	jobs *cron.Jobs) *AdminHandler {
+	return &AdminHandler{
+		Customers: store.Customers,
+		Leases:    store.Leases,
+		Runs:      store.Jobs,
+		Log:       log,
+		Purge:     purge,
+		Replica:   replica,
+		Jobs:      jobs,
+	}
+}
+
+// PurgeCustomers deletes for good the customers soft deleted longer than the grace period ago, right away
+func (a *AdminHandler) PurgeCustomers(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request PurgeRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	grace := a.Purge.GracePeriod
+	if request.GracePeriod != nil {
+		d, err := time.ParseDuration(*request.GracePeriod)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("grace_period must not be negative")
+		}
+		if err != nil {
+			a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+			problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+			return
+		}
+		grace = d
+	}
+
+	before := time.Now().Add(-grace).UTC()
+	purged, err := a.Customers.Purge(ctx.Request.Context(), before, a.Purge.BatchSize)
+	if purged != 0 {
+		a.Log.Info.Printf("%s: purged %d customers deleted before %s", requestID, purged,
+			before.Format(time.RFC3339))
+	}
+	if err != nil {
+		a.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, PurgeResponse{Purged: purged, Before: before})
+}
+
+// GetLeader answers with the lease of the cron jobs, 404 if no replica ever led them
+func (a *AdminHandler) GetLeader(ctx *gin.Context) {
+	l, err := a.Leases.First(ctx.Request.Context(), leader.CronLease)
+	if err != nil {
+		a.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, LeaderResponse{
+		Lease:   *l,
+		Replica: a.Replica,
+		Leading: l.Holder == a.Replica && !l.Expired(time.Now()),
+	})
+}
+
+func (a *AdminHandler) FindJobs(ctx *gin.Context) {
+	infos, err := a.Jobs.List(ctx.Request.Context())
+	if err != nil {
+		a.Log.Error.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindJobsResponse{
+		Replica: a.Replica,
+		Leading: a.Jobs.Elector.Leading(),
+		Jobs:    infos,
+	})
+}
+
+func (a *AdminHandler) GetJob(ctx *gin.Context) {
+	a.answerJob(ctx, http.StatusOK)
+}
+
+// RunJob runs a job right away on the replica answering, in the background, provided it leads. Otherwise the
+// replica leading, if any, is told to ask it instead.
+func (a *AdminHandler) RunJob(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	tag := ctx.Params.ByName("tag")
+	err := a.Jobs.Trigger(tag)
+	if errors.Is(err, cron.ErrNotLeading) {
+		l, leaseErr := a.Leases.First(ctx.Request.Context(), leader.CronLease)
+		if leaseErr == nil && !l.Expired(time.Now()) {
+			err = fmt.Errorf("%w, %s does", err, l.Holder)
+		}
+	}
+	if err != nil {
+		a.abortJob(ctx, err)
+		return
+	}
+	a.Log.Info.Printf("%s: job %s triggered", requestID, tag)
+
+	a.answerJob(ctx, http.StatusAccepted)
+}
+
+func (a *AdminHandler) PauseJob(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	tag := ctx.Params.ByName("tag")
+	if err := a.Jobs.Pause(ctx.Request.Context(), tag); err != nil {
+		a.abortJob(ctx, err)
+		return
+	}
+	a.Log.Info.Printf("%s: job %s paused", requestID, tag)
+
+	a.answerJob(ctx, http.StatusOK)
+}
+
+func (a *AdminHandler) ResumeJob(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	tag := ctx.Params.ByName("tag")
+	if err := a.Jobs.Resume(ctx.Request.Context(), tag); err != nil {
+		a.abortJob(ctx, err)
+		return
+	}
+	a.Log.Info.Printf("%s: job %s resumed", requestID, tag)
+
+	a.answerJob(ctx, http.StatusOK)
+}
+
+func (a *AdminHandler) SetJobInterval(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request JobIntervalRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	interval, err := time.ParseDuration(request.Interval)
+	if err == nil && (interval < time.Second || interval%time.Second != 0) {
+		err = fmt.Errorf("interval must be a whole number of seconds, at least one")
+	}
+	if err != nil {
+		a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	tag := ctx.Params.ByName("tag")
+	if err := a.Jobs.SetInterval(ctx.Request.Context(), tag, interval); err != nil {
+		a.abortJob(ctx, err)
+		return
+	}
+	a.Log.Info.Printf("%s: job %s rescheduled every %s", requestID, tag, interval)
+
+	a.answerJob(ctx, http.StatusOK)
+}
+
+func (a *AdminHandler) FindJobRuns(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	tag := ctx.Params.ByName("tag")
+	if !a.Jobs.Has(tag) {
+		a.abortJob(ctx, fmt.Errorf("%w %s", cron.ErrUnknownJob, tag))
+		return
+	}
+
+	var request FindJobRunsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	runs, next, err := a.Runs.Runs(ctx.Request.Context(), tag,
+		dao.PageParams{Cursor: request.Cursor, Limit: request.Limit})
+	if err != nil {
+		a.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if runs == nil {
+		runs = []job.Run{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindJobRunsResponse{Runs: runs, NextCursor: next})
+}
+
+// answerJob answers with the given status and the job of the tag path parameter
+func (a *AdminHandler) answerJob(ctx *gin.Context, status int) {
+	info, err := a.Jobs.Get(ctx.Request.Context(), ctx.Params.ByName("tag"))
+	if err != nil {
+		a.abortJob(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(status, info)
+}
+
+// abortJob reports an error of cron.Jobs: 404 for unknown jobs, 409 for jobs running already or triggered on a
+// replica which does not lead
+func (a *AdminHandler) abortJob(ctx *gin.Context, err error) {
+	a.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+	switch {
+	case errors.Is(err, cron.ErrUnknownJob):
+		problem.AbortWithStatus(ctx, http.StatusNotFound, err)
+	case errors.Is(err, cron.ErrRunning), errors.Is(err, cron.ErrNotLeading):
+		problem.AbortWithStatus(ctx, http.StatusConflict, err)
+	case errors.Is(err, cron.ErrStopped):
+		problem.AbortWithStatus(ctx, http.StatusServiceUnavailable, err)
+	default:
+		problem.Abort(ctx, err)
+	}
+}
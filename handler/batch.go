@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"api/customer"
+	"api/dao"
+	"api/problem"
+	"api/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// BatchModeAtomic creates either all the customers of a batch or none of them
+	BatchModeAtomic = "atomic"
+	// BatchModeBestEffort creates every valid customer of a batch, independently of the others
+	BatchModeBestEffort = "best_effort"
+
+	// MaxBatchSize is the maximum number of customers in a batch
+	MaxBatchSize = 1000
+
+	BatchStatusCreated    = "created"
+	BatchStatusInvalid    = "invalid"
+	BatchStatusDuplicate  = "duplicate"
+	BatchStatusSuppressed = "suppressed"
+	BatchStatusFailed     = "failed"
+	// BatchStatusSkipped is reported in atomic mode for the valid customers of a batch that was not created
+	BatchStatusSkipped = "skipped"
+)
+
+type (
+	// BatchItemResult reports what happened to one customer of a batch
+	BatchItemResult struct {
+		Index  int               `json:"index"`
+		Status string            `json:"status"`
+		ID     uint              `json:"id,omitempty"`
+		Errors map[string]string `json:"errors,omitempty"`
+		Error  string            `json:"error,omitempty"`
+	}
+
+	// BatchResponse is returned by POST /api/clients/batch, with one result per customer in the request order
+	BatchResponse struct {
+		Created int               `json:"created"`
+		Results []BatchItemResult `json:"results"`
+	}
+)
+
+func (c *CustomerHandler) CreateCustomers(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	mode := ctx.DefaultQuery("mode", BatchModeAtomic)
+	if mode != BatchModeAtomic && mode != BatchModeBestEffort {
+		err := fmt.Errorf("unknown mode %q", mode)
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var customers []*customer.Customer
+	if err := ctx.ShouldBindJSON(&customers); err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if len(customers) == 0 || len(customers) > MaxBatchSize {
+		err := fmt.Errorf("a batch must hold between 1 and %d customers", MaxBatchSize)
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	results := make([]BatchItemResult, len(customers))
+	var valid []*customer.Customer
+	for i, cust := range customers {
+		results[i].Index = i
+		if cust == nil {
+			results[i].Status = BatchStatusInvalid
+			results[i].Error = "customer cannot be null"
+			continue
+		}
+		if err := cust.Validate(); err != nil {
+			results[i].Status = BatchStatusInvalid
+			results[i].Errors = problem.FieldErrors(err)
+			continue
+		}
+		valid = append(valid, cust)
+	}
+
+	var status int
+	if mode == BatchModeAtomic {
+		status = c.createAtomic(ctx.Request.Context(), requestID, customers, valid, results)
+	} else {
+		status = c.createBestEffort(ctx.Request.Context(), requestID, customers, results)
+	}
+
+	response := BatchResponse{Results: results}
+	for _, result := range results {
+		if result.Status == BatchStatusCreated {
+			response.Created++
+		}
+	}
+	c.Log.Info.Printf("%s: batch of %d customers, %d created", requestID, len(customers), response.Created)
+
+	ctx.IndentedJSON(status, response)
+}
+
+// createAtomic creates the batch in a single transaction, provided all the customers are valid
+func (c *CustomerHandler) createAtomic(ctx context.Context, requestID string, customers, valid []*customer.Customer,
+	results []BatchItemResult) int {
+	if len(valid) < len(customers) {
+		markSkipped(results)
+		return http.StatusBadRequest
+	}
+
+	failed, err := c.Customers.CreateBatch(ctx, requestID, customers)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if failed >= 0 {
+			results[failed].Status, results[failed].Error = batchError(err)
+			switch results[failed].Status {
+			case BatchStatusDuplicate:
+				status = http.StatusConflict
+			case BatchStatusSuppressed:
+				status = http.StatusUnprocessableEntity
+			}
+		}
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		markSkipped(results)
+		return status
+	}
+
+	for i, cust := range customers {
+		results[i].Status = BatchStatusCreated
+		results[i].ID = cust.ID
+	}
+	return http.StatusCreated
+}
+
+// createBestEffort creates each valid customer on its own
+func (c *CustomerHandler) createBestEffort(ctx context.Context, requestID string, customers []*customer.Customer,
+	results []BatchItemResult) int {
+	status := http.StatusCreated
+	for i, cust := range customers {
+		if results[i].Status != "" {
+			status = http.StatusMultiStatus
+			continue
+		}
+		if err := c.Customers.Create(ctx, requestID, cust); err != nil {
+			c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+			results[i].Status, results[i].Error = batchError(err)
+			status = http.StatusMultiStatus
+			continue
+		}
+		results[i].Status = BatchStatusCreated
+		results[i].ID = cust.ID
+	}
+	return status
+}
+
+// markSkipped reports every customer without status as skipped
+func markSkipped(results []BatchItemResult) {
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = BatchStatusSkipped
+		}
+	}
+}
+
+// batchError returns the status and error message of a customer that could not be created
+func batchError(err error) (string, string) {
+	if errors.Is(err, dao.ErrConflict) {
+		return BatchStatusDuplicate, problem.Detail(err)
+	}
+	if errors.Is(err, dao.ErrSuppressed) {
+		return BatchStatusSuppressed, problem.Detail(err)
+	}
+	return BatchStatusFailed, problem.Detail(err)
+}
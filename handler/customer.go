@@ -4,14 +4,19 @@ import (
 	"api/customer"
 	"api/dao"
 	"api/logging"
+	"api/problem"
+	"api/tools"
 	"api/tracing"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm/logger"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type (
@@ -24,71 +29,120 @@ type (
 		DeleteCustomer(*gin.Context)
 		// FindCustomers handles GET /api/clients
 		FindCustomers(*gin.Context)
-		// MailClients handles POST /api/clients/send to delete all clients with the same mailing ID
+		// MailClients handles POST /api/clients/send to queue a message for all clients with the same mailing ID,
+		// or only to preview them with dry_run=true
 		MailClients(*gin.Context)
+		// ReplaceCustomer handles PUT /api/clients/:id
+		ReplaceCustomer(*gin.Context)
+		// PatchCustomer handles PATCH /api/clients/:id with a JSON Merge Patch (RFC 7396)
+		PatchCustomer(*gin.Context)
+		// CreateCustomers handles POST /api/clients/batch
+		CreateCustomers(*gin.Context)
+		// RestoreCustomer handles POST /api/clients/:id/restore
+		RestoreCustomer(*gin.Context)
+		// FindMessages handles GET /api/clients/:id/messages
+		FindMessages(*gin.Context)
 	}
 
 	MailClientsRequest struct {
 		MailingID int64 `json:"mailing_id"`
+		// SendAt schedules the mailing instead of sending it right away
+		SendAt *time.Time `json:"send_at"`
 	}
 
+	// FindCustomersRequest holds the query parameters of GET /api/clients
+	FindCustomersRequest struct {
+		Limit          int        `form:"limit"`
+		Cursor         string     `form:"cursor"`
+		Sort           string     `form:"sort"`
+		Email          string     `form:"email"`
+		Title          string     `form:"title"`
+		MailingID      *int64     `form:"mailing_id"`
+		CreatedAfter   *time.Time `form:"created_after"`
+		CreatedBefore  *time.Time `form:"created_before"`
+		IncludeDeleted string     `form:"include_deleted"`
+	}
+
+	// FindCustomersResponse is a page of customers. NextCursor is empty on the last page.
+	FindCustomersResponse struct {
+		Customers  []customer.Customer `json:"data"`
+		NextCursor string              `json:"next_cursor,omitempty"`
+	}
+
+	// MailClientsResponse identifies the operation a mailing was queued under
+	MailClientsResponse struct {
+		OperationID string `json:"operation_id"`
+		Queued      int64  `json:"queued"`
+	}
+
+	// CustomerHandler serves the customers, and the mailings sent to them
 	CustomerHandler struct {
+		Customers dao.CustomerDao
+		Mailings  dao.MailingDao
+		Outbox    dao.OutboxDao
+		Log       *logging.Logger
 	}
 )
 
+// NewCustomerHandler returns a CustomerHandler on the data access objects of store
+func NewCustomerHandler(store *dao.Store, log *logging.Logger) *CustomerHandler {
+	return &CustomerHandler{Customers: store.Customers, Mailings: store.Mailings, Outbox: store.Outbox, Log: log}
+}
+
 func (c *CustomerHandler) GetCustomer(ctx *gin.Context) {
 	id, err := strconv.ParseInt(ctx.Params.ByName("id"), 10, 64)
 	if err != nil {
-		logging.WarnLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		c.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	cust, err := dao.DAO.First(id)
-	if errors.Is(err, logger.ErrRecordNotFound) {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	cust, err := c.Customers.First(ctx.Request.Context(), id)
+	if errors.Is(err, dao.ErrNotFound) {
+		problem.Abort(ctx, err)
 		return
 	}
 	if err != nil {
-		logging.ErrorLogger.Printf("error querying the DB: %s\n", err.Error())
-		ctx.AbortWithStatus(http.StatusInternalServerError)
+		c.Log.Error.Printf("error querying the DB: %s\n", err.Error())
+		problem.Abort(ctx, err)
 		return
 	}
 
+	ctx.Header("ETag", cust.ETag())
 	ctx.IndentedJSON(http.StatusOK, cust)
 }
 
 func (c *CustomerHandler) CreateCustomer(ctx *gin.Context) {
 	var newCustomer customer.Customer
-	if err := ctx.BindJSON(&newCustomer); err != nil {
-		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+	if err := ctx.ShouldBindJSON(&newCustomer); err != nil {
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
 		return
 	}
 
 	if err := newCustomer.Validate(); err != nil {
-		logging.WarnLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		c.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
 		return
 	}
 
-	err := dao.DAO.Create(&newCustomer)
+	err := c.Customers.Create(ctx.Request.Context(), ctx.Request.Header.Get(tracing.XRequestID), &newCustomer)
 	if err != nil {
-		if errors.Is(err, dao.ErrPgIndex) {
-			logging.WarnLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		if errors.Is(err, dao.ErrConflict) || errors.Is(err, dao.ErrSuppressed) {
+			c.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+			problem.Abort(ctx, err)
 			return
 		}
-		logging.ErrorLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+		c.Log.Error.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
 		return
 	}
 
 	js, err := json.Marshal(newCustomer)
 	if err != nil {
-		_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+		problem.Abort(ctx, err)
 		return
 	}
-	logging.InfoLogger.Printf("%s : %s", ctx.Request.Header.Get(tracing.XRequestID), string(js))
+	c.Log.Info.Printf("%s : %s", ctx.Request.Header.Get(tracing.XRequestID), string(js))
 
 	ctx.Status(http.StatusCreated)
 }
@@ -96,49 +150,220 @@ func (c *CustomerHandler) CreateCustomer(ctx *gin.Context) {
 func (c *CustomerHandler) DeleteCustomer(ctx *gin.Context) {
 	id, err := strconv.ParseInt(ctx.Params.ByName("id"), 10, 64)
 	if err != nil {
-		logging.WarnLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		c.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
 		return
 	}
 
-	err = dao.DAO.Delete(&customer.Customer{}, id)
+	err = c.Customers.Delete(ctx.Request.Context(), ctx.Request.Header.Get(tracing.XRequestID), &customer.Customer{}, id)
 	if err != nil {
-		logging.ErrorLogger.Printf("error deleting from the DB: %s\n", err.Error())
-		ctx.AbortWithStatus(http.StatusInternalServerError)
+		c.Log.Error.Printf("error deleting from the DB: %s\n", err.Error())
+		problem.Abort(ctx, err)
+		return
 	}
 
 	ctx.Status(http.StatusNoContent)
 }
 
+func (c *CustomerHandler) RestoreCustomer(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, err := strconv.ParseInt(ctx.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	err = c.Customers.Restore(ctx.Request.Context(), id)
+	if errors.Is(err, dao.ErrNotFound) {
+		problem.Abort(ctx, err)
+		return
+	}
+	if err != nil {
+		c.Log.Error.Printf("%s: error restoring customer %d: %s", requestID, id, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	c.Log.Info.Printf("%s: customer %d restored", requestID, id)
+
+	cust, err := c.Customers.First(ctx.Request.Context(), id)
+	if err != nil {
+		c.Log.Error.Printf("%s: error querying the DB: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.Header("ETag", cust.ETag())
+	ctx.IndentedJSON(http.StatusOK, cust)
+}
+
 func (c *CustomerHandler) FindCustomers(ctx *gin.Context) {
-	// todo pagination
-	customers, err := dao.DAO.Find()
+	findCustomers(ctx, c.Customers, c.Log, nil)
+}
+
+// findCustomers answers with a page of the customers matching the query parameters,
+// restricted to the members of a mailing if memberOf is set
+func findCustomers(ctx *gin.Context, customerDao dao.CustomerDao, log *logging.Logger, memberOf *int64) {
+	var request FindCustomersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	customers, next, err := customerDao.Find(ctx.Request.Context(), dao.FindParams{
+		Email:          request.Email,
+		Title:          request.Title,
+		MailingID:      request.MailingID,
+		MemberOf:       memberOf,
+		CreatedAfter:   request.CreatedAfter,
+		CreatedBefore:  request.CreatedBefore,
+		IncludeDeleted: request.IncludeDeleted,
+		Sort:           request.Sort,
+		Cursor:         request.Cursor,
+		Limit:          request.Limit,
+	})
+	if errors.Is(err, dao.ErrInvalidQuery) {
+		log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
 	if err != nil {
-		logging.WarnLogger.Printf("error querying the DB: %s\n", err.Error())
-		ctx.AbortWithStatus(http.StatusInternalServerError)
+		log.Error.Printf("error querying the DB: %s\n", err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if customers == nil {
+		customers = []customer.Customer{}
 	}
 
-	ctx.IndentedJSON(http.StatusOK, customers)
+	ctx.IndentedJSON(http.StatusOK, FindCustomersResponse{Customers: customers, NextCursor: next})
+}
+
+func (c *CustomerHandler) ReplaceCustomer(ctx *gin.Context) {
+	c.updateCustomer(ctx, func(current *customer.Customer) (*customer.Customer, error) {
+		var replacement customer.Customer
+		if err := ctx.ShouldBindJSON(&replacement); err != nil {
+			return nil, err
+		}
+		replacement.Model = current.Model
+		return &replacement, nil
+	})
 }
 
-func (c *CustomerHandler) MailClients(ctx *gin.Context) {
-	var request MailClientsRequest
-	if err := ctx.BindJSON(&request); err != nil {
-		logging.WarnLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+func (c *CustomerHandler) PatchCustomer(ctx *gin.Context) {
+	switch ctx.ContentType() {
+	case "application/merge-patch+json", binding.MIMEJSON:
+	default:
+		problem.AbortWithStatus(ctx, http.StatusUnsupportedMediaType,
+			fmt.Errorf("content type must be application/merge-patch+json, not %q", ctx.ContentType()))
 		return
 	}
 
-	// sending message
-	logging.InfoLogger.Printf("deleting all customers with mailing id %d", request.MailingID)
+	c.updateCustomer(ctx, func(current *customer.Customer) (*customer.Customer, error) {
+		patch, err := ctx.GetRawData()
+		if err != nil {
+			return nil, err
+		}
+		doc, err := json.Marshal(current)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = tools.MergePatch(doc, patch); err != nil {
+			return nil, err
+		}
+		var patched customer.Customer
+		if err := json.Unmarshal(doc, &patched); err != nil {
+			return nil, err
+		}
+		patched.Model = current.Model
+		return &patched, nil
+	})
+}
 
-	rows, err := dao.DAO.DeleteByMailingID(request.MailingID)
+// updateCustomer loads the customer given in the path, checks the If-Match precondition and stores the
+// customer returned by apply. apply errors are reported as bad requests.
+func (c *CustomerHandler) updateCustomer(ctx *gin.Context, apply func(*customer.Customer) (*customer.Customer, error)) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, err := strconv.ParseInt(ctx.Params.ByName("id"), 10, 64)
 	if err != nil {
-		logging.ErrorLogger.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
-		_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
 		return
 	}
-	logging.InfoLogger.Printf("%d customers were deleted", rows)
 
-	ctx.Status(http.StatusNoContent)
+	if strings.TrimSpace(ctx.GetHeader("If-Match")) == "" {
+		c.Log.Warn.Printf("%s: customer %d: If-Match is missing", requestID, id)
+		problem.AbortWithStatus(ctx, http.StatusPreconditionRequired,
+			errors.New("If-Match is required, holding the current ETag, or *"))
+		return
+	}
+
+	current, err := c.Customers.First(ctx.Request.Context(), id)
+	if errors.Is(err, dao.ErrNotFound) {
+		problem.Abort(ctx, err)
+		return
+	}
+	if err != nil {
+		c.Log.Error.Printf("%s: error querying the DB: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if !ifMatch(ctx.GetHeader("If-Match"), current.ETag()) {
+		c.Log.Warn.Printf("%s: customer %d: If-Match %s does not match %s", requestID, id, ctx.GetHeader("If-Match"), current.ETag())
+		ctx.Header("ETag", current.ETag())
+		problem.AbortWithStatus(ctx, http.StatusPreconditionFailed, errors.New("If-Match does not match the current ETag"))
+		return
+	}
+
+	updated, err := apply(current)
+	if err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := updated.Validate(); err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	err = c.Customers.Update(ctx.Request.Context(), updated, current.UpdatedAt)
+	if errors.Is(err, dao.ErrStale) || errors.Is(err, dao.ErrConflict) {
+		c.Log.Warn.Printf("%s: customer %d: %s", requestID, id, err.Error())
+		if errors.Is(err, dao.ErrStale) {
+			// the client is told the version it lost to, unless that one was deleted meanwhile
+			if latest, err := c.Customers.First(ctx.Request.Context(), id); err == nil {
+				ctx.Header("ETag", latest.ETag())
+			}
+		}
+		problem.Abort(ctx, err)
+		return
+	}
+	if err != nil {
+		c.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.Header("ETag", updated.ETag())
+	ctx.IndentedJSON(http.StatusOK, updated)
+}
+
+// ifMatch evaluates an If-Match header (RFC 7232) against the current entity tag, using strong comparison.
+func ifMatch(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
 }
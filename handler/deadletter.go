@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"api/dao"
+	"api/outbox"
+	"api/problem"
+	"api/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// FindDeadLettersRequest holds the query parameters of GET /api/mailings/:id/dead-letters
+	FindDeadLettersRequest struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindDeadLettersResponse is a page of dead letters. NextCursor is empty on the last page.
+	FindDeadLettersResponse struct {
+		DeadLetters []outbox.DeadLetter `json:"data"`
+		NextCursor  string              `json:"next_cursor,omitempty"`
+	}
+
+	// RequeueRequest is the optional body of POST /api/mailings/:id/dead-letters. Without ids, every dead letter of
+	// the mailing is requeued.
+	RequeueRequest struct {
+		IDs []uint `json:"ids"`
+	}
+
+	// RequeueResponse tells how many messages are pending again
+	RequeueResponse struct {
+		Requeued int64 `json:"requeued"`
+	}
+)
+
+func (m *MailingHandler) FindDeadLetters(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request FindDeadLettersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	letters, next, err := m.Outbox.DeadLetters(ctx.Request.Context(), id,
+		dao.PageParams{Cursor: request.Cursor, Limit: request.Limit})
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if letters == nil {
+		letters = []outbox.DeadLetter{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindDeadLettersResponse{DeadLetters: letters, NextCursor: next})
+}
+
+func (m *MailingHandler) RequeueDeadLetters(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request RequeueRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if request.IDs != nil && (len(request.IDs) == 0 || len(request.IDs) > MaxBatchSize) {
+		err := fmt.Errorf("ids must hold between 1 and %d ids", MaxBatchSize)
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	requeued, err := m.Outbox.Requeue(ctx.Request.Context(), id, request.IDs, requestID)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: %d dead letters of mailing %d requeued", requestID, requeued, id)
+
+	ctx.IndentedJSON(http.StatusAccepted, RequeueResponse{Requeued: requeued})
+}
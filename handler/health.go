@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"api/problem"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	healthHandler interface {
+		// Ready handles GET /ready
+		Ready(*gin.Context)
+	}
+
+	// HealthHandler tells load balancers and orchestrators whether requests should be sent to this replica
+	HealthHandler struct {
+		// draining is set once the replica shuts down
+		draining int32
+	}
+)
+
+// ErrDraining is answered by GET /ready while the replica shuts down
+var ErrDraining = errors.New("shutting down")
+
+// Ready answers 200 unless the replica shuts down, 503 then, so that it is taken out of the load balancer while the
+// requests in flight are drained
+func (h *HealthHandler) Ready(ctx *gin.Context) {
+	if h.Draining() {
+		problem.AbortWithStatus(ctx, http.StatusServiceUnavailable, ErrDraining)
+		return
+	}
+	ctx.String(http.StatusOK, "ready")
+}
+
+// Drain makes Ready fail from now on
+func (h *HealthHandler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Draining tells whether Drain was called
+func (h *HealthHandler) Draining() bool {
+	return atomic.LoadInt32(&h.draining) != 0
+}
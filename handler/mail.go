@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/problem"
+	"api/tools"
+	"api/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// DefaultPreviewSample is the number of rendered messages of a preview, unless told otherwise
+	DefaultPreviewSample = 5
+	// MaxPreviewSample is the biggest number of rendered messages of a preview
+	MaxPreviewSample = 50
+)
+
+type (
+	// PreviewRequest holds the query parameters of a mailing preview
+	PreviewRequest struct {
+		Sample *int `form:"sample"`
+	}
+
+	// PreviewMessage is a message as it would be sent
+	PreviewMessage struct {
+		CustomerID uint   `json:"customer_id"`
+		Recipient  string `json:"recipient"`
+		Subject    string `json:"subject"`
+		Body       string `json:"body"`
+		HTMLBody   string `json:"html_body,omitempty"`
+	}
+
+	// MailingPreview tells who a mailing would be sent to, and what some of them would get
+	MailingPreview struct {
+		MailingID  int64            `json:"mailing_id"`
+		Count      int              `json:"count"`
+		Recipients []string         `json:"recipients"`
+		Sample     []PreviewMessage `json:"sample"`
+	}
+)
+
+func (c *CustomerHandler) MailClients(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request MailClientsRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, fmt.Errorf("dry_run must be a boolean"))
+		return
+	}
+	if dryRun {
+		previewMailing(ctx, c.Mailings, c.Log, request.MailingID)
+		return
+	}
+	if request.SendAt != nil {
+		scheduleMailing(ctx, c.Mailings, c.Log, request.MailingID, *request.SendAt)
+		return
+	}
+	sendMailing(ctx, c.Mailings, c.Log, request.MailingID)
+}
+
+// sendMailing queues the messages of a mailing. They are sent, and their customers deleted, by the outbox dispatcher.
+func sendMailing(ctx *gin.Context, mailings dao.MailingDao, log *logging.Logger, mailingID int64) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	operationID, err := tools.GenerateUUID4()
+	if err != nil {
+		log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	queued, err := mailings.Send(ctx.Request.Context(), operationID, requestID, mailingID)
+	if err != nil {
+		log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	log.Info.Printf("%s: queued %d messages for mailing id %d, operation %s", requestID, queued, mailingID, operationID)
+
+	ctx.IndentedJSON(http.StatusAccepted, MailClientsResponse{OperationID: operationID, Queued: queued})
+}
+
+// scheduleMailing schedules a mailing to be sent at the given time, which must be in the future, by the cron
+// subsystem. It answers with the scheduled mailing.
+func scheduleMailing(ctx *gin.Context, mailings dao.MailingDao, log *logging.Logger, mailingID int64,
+	at time.Time) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	if !at.After(time.Now()) {
+		err := fmt.Errorf("send_at must be in the future")
+		log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := mailings.Schedule(ctx.Request.Context(), mailingID, at); err != nil {
+		log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	log.Info.Printf("%s: mailing id %d scheduled at %s", requestID, mailingID, at.Format(time.RFC3339))
+
+	scheduled, err := mailings.First(ctx.Request.Context(), mailingID)
+	if err != nil {
+		log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusAccepted, scheduled)
+}
+
+// previewMailing answers with the preview of a mailing, rendering the messages of its first sample recipients.
+// Nothing is queued nor sent.
+func previewMailing(ctx *gin.Context, mailings dao.MailingDao, log *logging.Logger, mailingID int64) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	sample, err := previewSample(ctx)
+	if err != nil {
+		log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	messages, err := mailings.Preview(ctx.Request.Context(), mailingID)
+	if err != nil {
+		log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	p := MailingPreview{
+		MailingID:  mailingID,
+		Count:      len(messages),
+		Recipients: make([]string, 0, len(messages)),
+		Sample:     make([]PreviewMessage, 0, sample),
+	}
+	for i, m := range messages {
+		p.Recipients = append(p.Recipients, m.Recipient)
+		if i < sample {
+			p.Sample = append(p.Sample, PreviewMessage{
+				CustomerID: m.CustomerID,
+				Recipient:  m.Recipient,
+				Subject:    m.Subject,
+				Body:       m.Body,
+				HTMLBody:   m.HTMLBody,
+			})
+		}
+	}
+	log.Info.Printf("%s: preview of mailing id %d, %d recipients", requestID, mailingID, p.Count)
+
+	ctx.IndentedJSON(http.StatusOK, p)
+}
+
+// previewSample reads the number of rendered messages asked for in the sample query parameter
+func previewSample(ctx *gin.Context) (int, error) {
+	var request PreviewRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		return 0, err
+	}
+	if request.Sample == nil {
+		return DefaultPreviewSample, nil
+	}
+	if *request.Sample < 0 || *request.Sample > MaxPreviewSample {
+		return 0, fmt.Errorf("sample must be between 0 and %d", MaxPreviewSample)
+	}
+	return *request.Sample, nil
+}
@@ -0,0 +1,429 @@
+package handler
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/mailing"
+	"api/problem"
+	"api/tracing"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	mailingHandler interface {
+		// CreateMailing handles POST /api/mailings
+		CreateMailing(*gin.Context)
+		// GetMailing handles GET /api/mailings/:id
+		GetMailing(*gin.Context)
+		// FindMailings handles GET /api/mailings
+		FindMailings(*gin.Context)
+		// UpdateMailing handles PUT /api/mailings/:id
+		UpdateMailing(*gin.Context)
+		// DeleteMailing handles DELETE /api/mailings/:id
+		DeleteMailing(*gin.Context)
+		// SendMailing handles POST /api/mailings/:id/send
+		SendMailing(*gin.Context)
+		// CancelMailing handles POST /api/mailings/:id/cancel
+		CancelMailing(*gin.Context)
+		// ScheduleMailing handles POST /api/mailings/:id/schedule
+		ScheduleMailing(*gin.Context)
+		// UnscheduleMailing handles DELETE /api/mailings/:id/schedule
+		UnscheduleMailing(*gin.Context)
+		// PreviewMailing handles GET /api/mailings/:id/preview
+		PreviewMailing(*gin.Context)
+		// FindMembers handles GET /api/mailings/:id/members
+		FindMembers(*gin.Context)
+		// AddMembers handles POST /api/mailings/:id/members
+		AddMembers(*gin.Context)
+		// RemoveMember handles DELETE /api/mailings/:id/members/:customer_id
+		RemoveMember(*gin.Context)
+		// FindDeadLetters handles GET /api/mailings/:id/dead-letters
+		FindDeadLetters(*gin.Context)
+		// RequeueDeadLetters handles POST /api/mailings/:id/dead-letters
+		RequeueDeadLetters(*gin.Context)
+		// GetMailingStatus handles GET /api/mailings/:id/status
+		GetMailingStatus(*gin.Context)
+	}
+
+	// MailingRequest is the body of POST and PUT /api/mailings
+	MailingRequest struct {
+		Subject  string `json:"subject"`
+		Body     string `json:"body"`
+		HTMLBody string `json:"html_body"`
+		// RetentionSeconds overrides how long the customers of the mailing are kept, zero keeping them forever
+		RetentionSeconds *int64 `json:"retention_seconds"`
+	}
+
+	// FindMailingsRequest holds the query parameters of GET /api/mailings
+	FindMailingsRequest struct {
+		Status string `form:"status"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindMailingsResponse is a page of mailings. NextCursor is empty on the last page.
+	FindMailingsResponse struct {
+		Mailings   []mailing.Mailing `json:"data"`
+		NextCursor string            `json:"next_cursor,omitempty"`
+	}
+
+	// ScheduleRequest is the body of POST /api/mailings/:id/schedule
+	ScheduleRequest struct {
+		SendAt *time.Time `json:"send_at"`
+	}
+
+	// MembersRequest is the body of POST /api/mailings/:id/members
+	MembersRequest struct {
+		CustomerIDs []uint `json:"customer_ids"`
+	}
+
+	// MembersResponse tells how many customers were not members yet
+	MembersResponse struct {
+		Added int64 `json:"added"`
+	}
+
+	// MailingHandler serves the mailings, their members and their messages
+	MailingHandler struct {
+		Mailings  dao.MailingDao
+		Customers dao.CustomerDao
+		Outbox    dao.OutboxDao
+		Log       *logging.Logger
+	}
+)
+
+// NewMailingHandler returns a MailingHandler on the data access objects of store
+func NewMailingHandler(store *dao.Store, log *logging.Logger) *MailingHandler {
+	return &MailingHandler{Mailings: store.Mailings, Customers: store.Customers, Outbox: store.Outbox, Log: log}
+}
+
+func (m *MailingHandler) CreateMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request MailingRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	newMailing := mailing.Mailing{
+		Subject:          request.Subject,
+		Body:             request.Body,
+		HTMLBody:         request.HTMLBody,
+		RetentionSeconds: request.RetentionSeconds,
+	}
+	if err := newMailing.Validate(); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if err := m.Mailings.Create(ctx.Request.Context(), &newMailing); err != nil {
+		m.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: mailing %d created", requestID, newMailing.ID)
+
+	ctx.IndentedJSON(http.StatusCreated, newMailing)
+}
+
+func (m *MailingHandler) GetMailing(ctx *gin.Context) {
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	found, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, found)
+}
+
+func (m *MailingHandler) FindMailings(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request FindMailingsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	mailings, next, err := m.Mailings.Find(ctx.Request.Context(), dao.MailingFindParams{
+		Status: request.Status,
+		Cursor: request.Cursor,
+		Limit:  request.Limit,
+	})
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if mailings == nil {
+		mailings = []mailing.Mailing{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindMailingsResponse{Mailings: mailings, NextCursor: next})
+}
+
+func (m *MailingHandler) UpdateMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request MailingRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	current, err := m.editableMailing(ctx, id)
+	if err != nil {
+		return
+	}
+	current.Subject, current.Body, current.HTMLBody = request.Subject, request.Body, request.HTMLBody
+	current.RetentionSeconds = request.RetentionSeconds
+	if err := current.Validate(); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if err := m.Mailings.Update(ctx.Request.Context(), current); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: mailing %d updated", requestID, id)
+
+	updated, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, updated)
+}
+
+func (m *MailingHandler) DeleteMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := m.Mailings.Delete(ctx.Request.Context(), id); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: mailing %d deleted", requestID, id)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (m *MailingHandler) SendMailing(ctx *gin.Context) {
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+	sendMailing(ctx, m.Mailings, m.Log, id)
+}
+
+func (m *MailingHandler) CancelMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := m.Mailings.Cancel(ctx.Request.Context(), id); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: mailing %d cancelled", requestID, id)
+
+	cancelled, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, cancelled)
+}
+
+func (m *MailingHandler) ScheduleMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request ScheduleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if request.SendAt == nil {
+		err := fmt.Errorf("send_at is required")
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	scheduleMailing(ctx, m.Mailings, m.Log, id, *request.SendAt)
+}
+
+func (m *MailingHandler) UnscheduleMailing(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := m.Mailings.Unschedule(ctx.Request.Context(), id); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: mailing %d unscheduled", requestID, id)
+
+	unscheduled, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	ctx.IndentedJSON(http.StatusOK, unscheduled)
+}
+
+func (m *MailingHandler) PreviewMailing(ctx *gin.Context) {
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+	previewMailing(ctx, m.Mailings, m.Log, id)
+}
+
+func (m *MailingHandler) FindMembers(ctx *gin.Context) {
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	if _, err := m.Mailings.First(ctx.Request.Context(), id); err != nil {
+		m.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	findCustomers(ctx, m.Customers, m.Log, &id)
+}
+
+func (m *MailingHandler) AddMembers(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request MembersRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if len(request.CustomerIDs) == 0 || len(request.CustomerIDs) > MaxBatchSize {
+		err := fmt.Errorf("customer_ids must hold between 1 and %d ids", MaxBatchSize)
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := m.editableMailing(ctx, id); err != nil {
+		return
+	}
+
+	added, err := m.Mailings.AddMembers(ctx.Request.Context(), id, request.CustomerIDs)
+	if err != nil {
+		m.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: %d members added to mailing %d", requestID, added, id)
+
+	ctx.IndentedJSON(http.StatusOK, MembersResponse{Added: added})
+}
+
+func (m *MailingHandler) RemoveMember(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+	customerID, err := strconv.ParseUint(ctx.Params.ByName("customer_id"), 10, 64)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := m.editableMailing(ctx, id); err != nil {
+		return
+	}
+
+	if err := m.Mailings.RemoveMember(ctx.Request.Context(), id, uint(customerID)); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	m.Log.Info.Printf("%s: customer %d removed from mailing %d", requestID, customerID, id)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// mailingID parses the id path parameter, aborting with 400 if it is not valid
+func (m *MailingHandler) mailingID(ctx *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(ctx.Params.ByName("id"), 10, 64)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return id, true
+}
+
+// editableMailing retrieves a mailing whose subject, body and members may still change.
+// It aborts with the reason why not otherwise, and returns that error.
+func (m *MailingHandler) editableMailing(ctx *gin.Context, id int64) (*mailing.Mailing, error) {
+	found, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err == nil && !found.Editable() {
+		err = fmt.Errorf("%w: a %s mailing cannot be edited", dao.ErrInvalidState, found.Status)
+	}
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"api/dao"
+	"api/outbox"
+	"api/problem"
+	"api/tracing"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// FindMessagesRequest holds the query parameters of GET /api/mailings/:id/status and GET /api/clients/:id/messages
+	FindMessagesRequest struct {
+		Status string `form:"status"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// MailingStatusResponse tells how far a mailing got: how many of its messages are in every status, and a page of
+	// them. NextCursor is empty on the last page.
+	MailingStatusResponse struct {
+		MailingID  int64            `json:"mailing_id"`
+		Status     string           `json:"status"`
+		Counts     map[string]int64 `json:"counts"`
+		Total      int64            `json:"total"`
+		Messages   []outbox.Message `json:"data"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+
+	// FindMessagesResponse is a page of messages. NextCursor is empty on the last page.
+	FindMessagesResponse struct {
+		Messages   []outbox.Message `json:"data"`
+		NextCursor string           `json:"next_cursor,omitempty"`
+	}
+)
+
+func (m *MailingHandler) GetMailingStatus(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := m.mailingID(ctx)
+	if !ok {
+		return
+	}
+
+	var request FindMessagesRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	found, err := m.Mailings.First(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	messages, next, err := m.Outbox.Messages(ctx.Request.Context(), dao.MessageFindParams{
+		MailingID: &id,
+		Status:    request.Status,
+		Cursor:    request.Cursor,
+		Limit:     request.Limit,
+	})
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	counts, err := m.Outbox.Counts(ctx.Request.Context(), id)
+	if err != nil {
+		m.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if messages == nil {
+		messages = []outbox.Message{}
+	}
+
+	response := MailingStatusResponse{
+		MailingID:  id,
+		Status:     found.Status,
+		Counts:     counts,
+		Messages:   messages,
+		NextCursor: next,
+	}
+	for _, count := range counts {
+		response.Total += count
+	}
+	ctx.IndentedJSON(http.StatusOK, response)
+}
+
+// FindMessages lists the messages sent, or meant to be sent, to a customer. Deleted customers have a history too.
+func (c *CustomerHandler) FindMessages(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, err := strconv.ParseUint(ctx.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	var request FindMessagesRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	customerID := uint(id)
+	messages, next, err := c.Outbox.Messages(ctx.Request.Context(), dao.MessageFindParams{
+		CustomerID: &customerID,
+		Status:     request.Status,
+		Cursor:     request.Cursor,
+		Limit:      request.Limit,
+	})
+	if err != nil {
+		c.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if messages == nil {
+		messages = []outbox.Message{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindMessagesResponse{Messages: messages, NextCursor: next})
+}
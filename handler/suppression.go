@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/problem"
+	"api/suppression"
+	"api/tracing"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	suppressionHandler interface {
+		// CreateSuppression handles POST /api/suppressions
+		CreateSuppression(*gin.Context)
+		// GetSuppression handles GET /api/suppressions/:email
+		GetSuppression(*gin.Context)
+		// FindSuppressions handles GET /api/suppressions
+		FindSuppressions(*gin.Context)
+		// DeleteSuppression handles DELETE /api/suppressions/:email
+		DeleteSuppression(*gin.Context)
+	}
+
+	unsubscribeHandler interface {
+		// GetUnsubscribe handles GET /unsubscribe/:token
+		GetUnsubscribe(*gin.Context)
+		// Unsubscribe handles POST /unsubscribe/:token
+		Unsubscribe(*gin.Context)
+	}
+
+	// SuppressionRequest is the body of POST /api/suppressions
+	SuppressionRequest struct {
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+	}
+
+	// FindSuppressionsRequest holds the query parameters of GET /api/suppressions
+	FindSuppressionsRequest struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindSuppressionsResponse is a page of suppressions. NextCursor is empty on the last page.
+	FindSuppressionsResponse struct {
+		Suppressions []suppression.Suppression `json:"data"`
+		NextCursor   string                    `json:"next_cursor,omitempty"`
+	}
+
+	// UnsubscribeResponse tells whether the recipient of an unsubscribe link no longer gets any mail
+	UnsubscribeResponse struct {
+		Email        string `json:"email"`
+		MailingID    int64  `json:"mailing_id"`
+		Unsubscribed bool   `json:"unsubscribed"`
+	}
+
+	// SuppressionHandler serves the email addresses that are never mailed
+	SuppressionHandler struct {
+		Suppressions dao.SuppressionDao
+		Log          *logging.Logger
+	}
+
+	// UnsubscribeHandler serves the unsubscribe links of the mail sent, which are public
+	UnsubscribeHandler struct {
+		Suppressions dao.SuppressionDao
+		Signer       *suppression.Signer
+		Log          *logging.Logger
+	}
+)
+
+// NewSuppressionHandler returns a SuppressionHandler on the data access objects of store
+func NewSuppressionHandler(store *dao.Store, log *logging.Logger) *SuppressionHandler {
+	return &SuppressionHandler{Suppressions: store.Suppressions, Log: log}
+}
+
+// NewUnsubscribeHandler returns an UnsubscribeHandler on the data access objects of store, checking the links with
+// signer
+func NewUnsubscribeHandler(store *dao.Store, signer *suppression.Signer, log *logging.Logger) *UnsubscribeHandler {
+	return &UnsubscribeHandler{Suppressions: store.Suppressions, Signer: signer, Log: log}
+}
+
+func (s *SuppressionHandler) CreateSuppression(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request SuppressionRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	newSuppression := suppression.Suppression{Email: request.Email, Reason: request.Reason}
+	if newSuppression.Reason == "" {
+		newSuppression.Reason = suppression.ReasonManual
+	}
+	if err := newSuppression.Validate(); err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if err := s.Suppressions.Create(ctx.Request.Context(), &newSuppression); err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	s.Log.Info.Printf("%s: %s suppressed", requestID, newSuppression.Email)
+
+	ctx.IndentedJSON(http.StatusCreated, newSuppression)
+}
+
+func (s *SuppressionHandler) GetSuppression(ctx *gin.Context) {
+	found, err := s.Suppressions.First(ctx.Request.Context(), ctx.Params.ByName("email"))
+	if err != nil {
+		s.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, found)
+}
+
+func (s *SuppressionHandler) FindSuppressions(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request FindSuppressionsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	suppressions, next, err := s.Suppressions.Find(ctx.Request.Context(),
+		dao.PageParams{Cursor: request.Cursor, Limit: request.Limit})
+	if err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if suppressions == nil {
+		suppressions = []suppression.Suppression{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindSuppressionsResponse{Suppressions: suppressions, NextCursor: next})
+}
+
+func (s *SuppressionHandler) DeleteSuppression(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	email := ctx.Params.ByName("email")
+	if err := s.Suppressions.Delete(ctx.Request.Context(), email); err != nil {
+		s.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	s.Log.Info.Printf("%s: %s no longer suppressed", requestID, email)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetUnsubscribe tells who an unsubscribe link is for, and whether they unsubscribed already. It changes nothing,
+// so that mail scanners following links do not unsubscribe anybody.
+func (u *UnsubscribeHandler) GetUnsubscribe(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	customerID, mailingID, ok := u.token(ctx)
+	if !ok {
+		return
+	}
+
+	email, suppressed, err := u.Suppressions.Recipient(ctx.Request.Context(), customerID)
+	if err != nil {
+		u.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, UnsubscribeResponse{Email: email, MailingID: mailingID, Unsubscribed: suppressed})
+}
+
+// Unsubscribe suppresses the recipient of an unsubscribe link. It also serves one-click unsubscription (RFC 8058).
+func (u *UnsubscribeHandler) Unsubscribe(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	customerID, mailingID, ok := u.token(ctx)
+	if !ok {
+		return
+	}
+
+	s, err := u.Suppressions.Unsubscribe(ctx.Request.Context(), customerID, mailingID)
+	if err != nil {
+		u.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	u.Log.Info.Printf("%s: customer %d unsubscribed from mailing %d", requestID, customerID, mailingID)
+
+	ctx.IndentedJSON(http.StatusOK, UnsubscribeResponse{Email: s.Email, MailingID: mailingID, Unsubscribed: true})
+}
+
+// token checks the token path parameter, aborting with 404 if it is not valid, and returns the customer and mailing
+// it was issued for
+func (u *UnsubscribeHandler) token(ctx *gin.Context) (uint, int64, bool) {
+	customerID, mailingID, err := u.Signer.Parse(ctx.Params.ByName("token"))
+	if err != nil {
+		u.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusNotFound, err)
+		return 0, 0, false
+	}
+	return customerID, mailingID, true
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"api/dao"
+	"api/logging"
+	"api/problem"
+	"api/tracing"
+	"api/webhook"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	webhookHandler interface {
+		// CreateWebhook handles POST /api/webhooks
+		CreateWebhook(*gin.Context)
+		// GetWebhook handles GET /api/webhooks/:id
+		GetWebhook(*gin.Context)
+		// FindWebhooks handles GET /api/webhooks
+		FindWebhooks(*gin.Context)
+		// UpdateWebhook handles PUT /api/webhooks/:id
+		UpdateWebhook(*gin.Context)
+		// DeleteWebhook handles DELETE /api/webhooks/:id
+		DeleteWebhook(*gin.Context)
+		// FindDeliveries handles GET /api/webhooks/:id/deliveries
+		FindDeliveries(*gin.Context)
+	}
+
+	// WebhookRequest is the body of POST and PUT /api/webhooks. A secret is generated on POST if none is given, and
+	// kept on PUT.
+	WebhookRequest struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	// CreateWebhookResponse is the subscription created, along with its secret, which is never shown again
+	CreateWebhookResponse struct {
+		webhook.Subscription
+		Secret string `json:"secret"`
+	}
+
+	// FindWebhooksRequest holds the query parameters of GET /api/webhooks
+	FindWebhooksRequest struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindWebhooksResponse is a page of webhook subscriptions. NextCursor is empty on the last page.
+	FindWebhooksResponse struct {
+		Subscriptions []webhook.Subscription `json:"data"`
+		NextCursor    string                 `json:"next_cursor,omitempty"`
+	}
+
+	// FindDeliveriesRequest holds the query parameters of GET /api/webhooks/:id/deliveries
+	FindDeliveriesRequest struct {
+		Status string `form:"status"`
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	// FindDeliveriesResponse is a page of webhook deliveries. NextCursor is empty on the last page.
+	FindDeliveriesResponse struct {
+		Deliveries []webhook.Delivery `json:"data"`
+		NextCursor string             `json:"next_cursor,omitempty"`
+	}
+
+	// WebhookHandler serves the webhook subscriptions, and their deliveries
+	WebhookHandler struct {
+		Webhooks dao.WebhookDao
+		Log      *logging.Logger
+	}
+)
+
+// NewWebhookHandler returns a WebhookHandler on the data access objects of store
+func NewWebhookHandler(store *dao.Store, log *logging.Logger) *WebhookHandler {
+	return &WebhookHandler{Webhooks: store.Webhooks, Log: log}
+}
+
+func (w *WebhookHandler) CreateWebhook(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request WebhookRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	s := webhook.Subscription{URL: request.URL, Events: request.Events, Secret: request.Secret}
+	if s.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			w.Log.Error.Printf("%s: %s", requestID, err.Error())
+			problem.Abort(ctx, err)
+			return
+		}
+		s.Secret = secret
+	}
+	if err := s.Validate(); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if err := w.Webhooks.Create(ctx.Request.Context(), &s); err != nil {
+		w.Log.Error.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	w.Log.Info.Printf("%s: webhook subscription %d to %s created", requestID, s.ID, s.URL)
+
+	ctx.IndentedJSON(http.StatusCreated, CreateWebhookResponse{Subscription: s, Secret: s.Secret})
+}
+
+func (w *WebhookHandler) GetWebhook(ctx *gin.Context) {
+	id, ok := w.webhookID(ctx)
+	if !ok {
+		return
+	}
+
+	found, err := w.Webhooks.First(ctx.Request.Context(), id)
+	if err != nil {
+		w.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	ctx.IndentedJSON(http.StatusOK, found)
+}
+
+func (w *WebhookHandler) FindWebhooks(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	var request FindWebhooksRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	subscriptions, next, err := w.Webhooks.Find(ctx.Request.Context(),
+		dao.PageParams{Cursor: request.Cursor, Limit: request.Limit})
+	if err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []webhook.Subscription{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindWebhooksResponse{Subscriptions: subscriptions, NextCursor: next})
+}
+
+func (w *WebhookHandler) UpdateWebhook(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := w.webhookID(ctx)
+	if !ok {
+		return
+	}
+
+	var request WebhookRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	s, err := w.Webhooks.First(ctx.Request.Context(), id)
+	if err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	s.URL, s.Events = request.URL, request.Events
+	if request.Secret != "" {
+		s.Secret = request.Secret
+	}
+	if err := s.Validate(); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+
+	if err := w.Webhooks.Update(ctx.Request.Context(), s); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	w.Log.Info.Printf("%s: webhook subscription %d updated", requestID, id)
+
+	ctx.IndentedJSON(http.StatusOK, s)
+}
+
+func (w *WebhookHandler) DeleteWebhook(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := w.webhookID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := w.Webhooks.Delete(ctx.Request.Context(), id); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	w.Log.Info.Printf("%s: webhook subscription %d deleted", requestID, id)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (w *WebhookHandler) FindDeliveries(ctx *gin.Context) {
+	requestID := ctx.Request.Header.Get(tracing.XRequestID)
+
+	id, ok := w.webhookID(ctx)
+	if !ok {
+		return
+	}
+
+	var request FindDeliveriesRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	deliveries, next, err := w.Webhooks.Deliveries(ctx.Request.Context(), id, dao.DeliveryFindParams{
+		Status: request.Status,
+		Cursor: request.Cursor,
+		Limit:  request.Limit,
+	})
+	if err != nil {
+		w.Log.Warn.Printf("%s: %s", requestID, err.Error())
+		problem.Abort(ctx, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []webhook.Delivery{}
+	}
+
+	ctx.IndentedJSON(http.StatusOK, FindDeliveriesResponse{Deliveries: deliveries, NextCursor: next})
+}
+
+// webhookID reads the id path parameter, aborting with 400 if it is not a webhook subscription id
+func (w *WebhookHandler) webhookID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Params.ByName("id"), 10, 64)
+	if err != nil {
+		w.Log.Warn.Printf("%s: %s", ctx.Request.Header.Get(tracing.XRequestID), err.Error())
+		problem.AbortWithStatus(ctx, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// newSecret generates a random secret to sign the deliveries of a subscription with
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
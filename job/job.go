@@ -0,0 +1,63 @@
+package job
+
+import "time"
+
+// Triggers of a Run
+const (
+	// TriggerSchedule runs were started by the scheduler of the leader
+	TriggerSchedule = "schedule"
+	// TriggerManual runs were asked for through the API
+	TriggerManual = "manual"
+)
+
+// HistoryRetention is how long runs are kept in the history
+const HistoryRetention = 7 * 24 * time.Hour
+
+type (
+	// Settings override how a job is scheduled, on every replica
+	Settings struct {
+		Tag string `json:"tag" gorm:"primaryKey"`
+		// IntervalSeconds overrides the interval the job was registered with, unless it is zero
+		IntervalSeconds int64     `json:"interval_seconds,omitempty"`
+		Paused          bool      `json:"paused"`
+		UpdatedAt       time.Time `json:"updated_at"`
+	}
+
+	// Run records a run of a job, by any replica
+	Run struct {
+		ID uint `json:"id" gorm:"primarykey"`
+		// Job is the tag of the job
+		Job string `json:"job" gorm:"index"`
+		// Replica is the holder name of the replica that ran the job, see leader.NewHolder
+		Replica    string    `json:"replica"`
+		Trigger    string    `json:"trigger"`
+		StartedAt  time.Time `json:"started_at" gorm:"index"`
+		DurationMS int64     `json:"duration_ms"`
+		// Rows is how many rows the job affected: customers expired, messages sent and so on
+		Rows  int64  `json:"rows"`
+		Error string `json:"error,omitempty"`
+	}
+
+	// Info describes a job as scheduled by the replica answering
+	Info struct {
+		Tag string `json:"tag"`
+		// Interval is the time between two runs, as understood by time.ParseDuration
+		Interval string `json:"interval"`
+		Paused   bool   `json:"paused"`
+		// Running tells whether the replica answering is running the job right now
+		Running bool `json:"running"`
+		// NextRun is when the replica answering schedules the job next, which it only runs if it leads. Paused jobs
+		// are not scheduled.
+		NextRun *time.Time `json:"next_run,omitempty"`
+		// LastRun is the last run of the job, by any replica
+		LastRun *Run `json:"last_run,omitempty"`
+	}
+)
+
+func (Settings) TableName() string {
+	return "job_settings"
+}
+
+func (Run) TableName() string {
+	return "job_runs"
+}
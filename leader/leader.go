@@ -0,0 +1,44 @@
+package leader
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+const (
+	// CronLease is the lease held by the replica running the cron jobs
+	CronLease = "cron"
+	// DefaultTTL is how long leadership lasts without being renewed. A replica taking over waits that long at most
+	// after the leader died.
+	DefaultTTL = 15 * time.Second
+)
+
+// Lease grants its holder the leadership of something, the cron jobs say, until it expires. Holders renew their
+// leases before they expire; other replicas take over expired ones.
+type Lease struct {
+	Name       string    `json:"name" gorm:"primaryKey"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Expired tells whether the lease expired at the given time, so that another holder may take it
+func (l *Lease) Expired(at time.Time) bool {
+	return !at.Before(l.ExpiresAt)
+}
+
+// NewHolder returns a name identifying this replica among the others: its host name and a random suffix, so that a
+// restarted replica is not mistaken for the one it replaces
+func NewHolder() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return host + "-" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,31 @@
+package leader
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLease_Expired(t *testing.T) {
+	now := time.Now()
+	l := Lease{Name: CronLease, Holder: "a", ExpiresAt: now}
+
+	assert.False(t, l.Expired(now.Add(-time.Second)))
+	assert.True(t, l.Expired(now))
+	assert.True(t, l.Expired(now.Add(time.Second)))
+}
+
+func TestNewHolder(t *testing.T) {
+	a, err := NewHolder()
+	require.NoError(t, err)
+	b, err := NewHolder()
+	require.NoError(t, err)
+
+	host, _ := os.Hostname()
+	assert.True(t, strings.HasPrefix(a, host+"-"))
+	assert.NotEqual(t, a, b)
+}
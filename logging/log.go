@@ -1,29 +1,58 @@
 package logging
 
 import (
+	"io"
 	"log"
 	"os"
 )
 
-const (
-	GinLog = "gin.log"
-	AppLog = "app.log"
-)
-
 // todo wrap logger usage with an interface
 
-var (
-	InfoLogger  *log.Logger
-	WarnLogger  *log.Logger
-	ErrorLogger *log.Logger
-)
+// Logger logs by severity
+type Logger struct {
+	Info  *log.Logger
+	Warn  *log.Logger
+	Error *log.Logger
+
+	// file is where the logger writes, if opened by Open
+	file *os.File
+}
+
+// New returns a Logger writing to w
+func New(w io.Writer) *Logger {
+	return &Logger{
+		Info:  log.New(w, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
+		Warn:  log.New(w, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile),
+		Error: log.New(w, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
+	}
+}
 
-func init() {
-	f, err := os.OpenFile(AppLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+// Open returns a Logger appending to the named file
+func Open(name string) (*Logger, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	l := New(f)
+	l.file = f
+	return l, nil
+}
+
+// Flush commits what was logged so far to storage, so that nothing is lost when the process exits
+func (l *Logger) Flush() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+// Close flushes and closes the file opened by Open. Nothing may be logged afterwards.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	if err := l.Flush(); err != nil {
+		return err
 	}
-	InfoLogger = log.New(f, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	WarnLogger = log.New(f, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(f, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	return l.file.Close()
 }
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"fmt"
+)
+
+// Drivers accepted in Config.Driver
+const (
+	DriverSMTP     = "smtp"
+	DriverSendmail = "sendmail"
+	DriverMaildir  = "maildir"
+)
+
+// Config selects and sets up a Mailer
+type Config struct {
+	// Driver is DriverSMTP, DriverSendmail or DriverMaildir
+	Driver string
+	// SMTP is used by DriverSMTP
+	SMTP SMTPMailer
+	// SendmailPath is used by DriverSendmail
+	SendmailPath string
+	// MaildirDir is used by DriverMaildir
+	MaildirDir string
+}
+
+// New returns the Mailer chosen in the config
+func New(config Config) (Mailer, error) {
+	switch config.Driver {
+	case DriverSMTP:
+		if config.SMTP.Host == "" || config.SMTP.Port == 0 {
+			return nil, fmt.Errorf("smtp driver needs a host and a port")
+		}
+		smtp := config.SMTP
+		return &smtp, nil
+	case DriverSendmail:
+		return &SendmailMailer{Path: config.SendmailPath}, nil
+	case DriverMaildir:
+		if config.MaildirDir == "" {
+			return nil, fmt.Errorf("maildir driver needs a directory")
+		}
+		return &MaildirMailer{Dir: config.MaildirDir}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", config.Driver)
+}
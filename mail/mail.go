@@ -0,0 +1,151 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrRejected is wrapped by the errors of a Mailer when a recipient was rejected for good, so that sending the
+// message again is pointless
+var ErrRejected = errors.New("recipient rejected")
+
+type (
+	// Mailer delivers messages
+	Mailer interface {
+		// Send delivers the message to all its recipients, giving up once ctx is done
+		Send(ctx context.Context, m *Message) error
+	}
+
+	// Message is a plain text email, with an optional HTML alternative
+	Message struct {
+		From    string
+		To      []string
+		Subject string
+		Body    string
+		// HTML is sent along with Body as multipart/alternative if set
+		HTML string
+		// Headers are added to the message as they are
+		Headers map[string]string
+	}
+)
+
+// Validate checks that the message has a sender and recipients with valid addresses
+func (m *Message) Validate() error {
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return fmt.Errorf("from %q: %w", m.From, err)
+	}
+	if len(m.To) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	for _, to := range m.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("to %q: %w", to, err)
+		}
+	}
+	return nil
+}
+
+// envelope returns the bare addresses of the sender and the recipients, without their display names, as SMTP and
+// sendmail expect them
+func (m *Message) envelope() (from string, to []string, err error) {
+	sender, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", nil, fmt.Errorf("from %q: %w", m.From, err)
+	}
+	to = make([]string, 0, len(m.To))
+	for _, t := range m.To {
+		recipient, err := mail.ParseAddress(t)
+		if err != nil {
+			return "", nil, fmt.Errorf("to %q: %w", t, err)
+		}
+		to = append(to, recipient.Address)
+	}
+	return sender.Address, to, nil
+}
+
+// Bytes renders the message in the Internet Message Format (RFC 5322), with quoted-printable UTF-8 bodies
+func (m *Message) Bytes() ([]byte, error) {
+	headers := map[string]string{
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"From":         m.From,
+		"To":           strings.Join(m.To, ", "),
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"MIME-Version": "1.0",
+	}
+
+	var body bytes.Buffer
+	if m.HTML == "" {
+		headers["Content-Type"] = "text/plain; charset=utf-8"
+		headers["Content-Transfer-Encoding"] = "quoted-printable"
+		if err := writeQuotedPrintable(&body, m.Body); err != nil {
+			return nil, err
+		}
+	} else {
+		w := multipart.NewWriter(&body)
+		headers["Content-Type"] = "multipart/alternative; boundary=" + w.Boundary()
+		// the preferred alternative goes last
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", m.Body},
+			{"text/html; charset=utf-8", m.HTML},
+		} {
+			pw, err := w.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(pw, part.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, value := range m.Headers {
+		headers[key] = value
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		if strings.ContainsAny(key+headers[key], "\r\n") {
+			return nil, fmt.Errorf("header %q contains a line break", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, headers[key])
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable writes text with CRLF line endings, quoted-printable encoded
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
@@ -0,0 +1,136 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Bytes(t *testing.T) {
+	tests := map[string]struct {
+		message         Message
+		expectedSubject string
+		expectedBody    string
+		expectedHeaders map[string]string
+		expectedError   string
+	}{
+		"ascii": {
+			message:         Message{From: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Body: "hello\nworld"},
+			expectedSubject: "Hi",
+			expectedBody:    "hello\r\nworld\r\n",
+		},
+		"utf-8 subject and long body": {
+			message: Message{
+				From: "a@example.com", To: []string{"b@example.com"},
+				Subject: "¡Olé!", Body: string(bytes.Repeat([]byte("ñ"), 100)),
+			},
+			expectedSubject: "¡Olé!",
+			expectedBody:    string(bytes.Repeat([]byte("ñ"), 100)) + "\r\n",
+		},
+		"extra headers": {
+			message: Message{
+				From: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi",
+				Headers: map[string]string{"X-Mailing": "1"},
+			},
+			expectedSubject: "Hi",
+			expectedBody:    "\r\n",
+			expectedHeaders: map[string]string{"X-Mailing": "1"},
+		},
+		"header injection": {
+			message:       Message{From: "a@example.com", To: []string{"b@example.com\r\nBcc: c@example.com"}, Subject: "Hi"},
+			expectedError: `header "To" contains a line break`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			raw, err := test.message.Bytes()
+			if test.expectedError != "" {
+				assert.EqualError(t, err, test.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+
+			r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+			headers, err := r.ReadMIMEHeader()
+			assert.NoError(t, err)
+
+			subject, err := new(mime.WordDecoder).DecodeHeader(headers.Get("Subject"))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedSubject, subject)
+			assert.Equal(t, "quoted-printable", headers.Get("Content-Transfer-Encoding"))
+			for key, value := range test.expectedHeaders {
+				assert.Equal(t, value, headers.Get(key))
+			}
+
+			body, err := io.ReadAll(quotedprintable.NewReader(r.R))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedBody, string(body))
+		})
+	}
+}
+
+func TestMessage_Bytes_multipart(t *testing.T) {
+	message := Message{
+		From: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi",
+		Body: "hello\r\nworld", HTML: "<p>hello</p>",
+	}
+	raw, err := message.Bytes()
+	require.NoError(t, err)
+
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	headers, err := r.ReadMIMEHeader()
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	assert.Empty(t, headers.Get("Content-Transfer-Encoding"))
+
+	parts := multipart.NewReader(r.R, params["boundary"])
+	for _, expected := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "hello\r\nworld\r\n"},
+		{"text/html; charset=utf-8", "<p>hello</p>\r\n"},
+	} {
+		part, err := parts.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, expected.contentType, part.Header.Get("Content-Type"))
+		// multipart.Reader decodes quoted-printable parts on its own
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, expected.body, string(body))
+	}
+	_, err = parts.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMessage_Validate(t *testing.T) {
+	tests := map[string]struct {
+		message       Message
+		expectedError string
+	}{
+		"ok":           {message: Message{From: "a@example.com", To: []string{"b@example.com"}}},
+		"no sender":    {message: Message{To: []string{"b@example.com"}}, expectedError: `from "": mail: no address`},
+		"no recipient": {message: Message{From: "a@example.com"}, expectedError: "no recipients"},
+		"bad recipient": {
+			message:       Message{From: "a@example.com", To: []string{"b"}},
+			expectedError: `to "b": mail: missing '@' or angle-addr`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.message.Validate()
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, test.expectedError)
+		})
+	}
+}
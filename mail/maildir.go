@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// MaildirMailer drops messages into a Maildir instead of delivering them. Meant for local development.
+type MaildirMailer struct {
+	Dir string
+}
+
+var deliveries uint64
+
+func (d *MaildirMailer) Send(ctx context.Context, m *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(d.Dir, sub), 0755); err != nil {
+			return fmt.Errorf("maildir: %w", err)
+		}
+	}
+
+	// see https://cr.yp.to/proto/maildir.html
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&deliveries, 1), hostname)
+
+	tmp := filepath.Join(d.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, msg, 0644); err != nil {
+		return fmt.Errorf("maildir: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(d.Dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("maildir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaildirMailer_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "maildir")
+	mailer := &MaildirMailer{Dir: dir}
+	message := &Message{From: "a@example.com", To: []string{"b@example.com"}, Subject: "Hi", Body: "hello"}
+
+	assert.NoError(t, mailer.Send(context.Background(), message))
+	assert.NoError(t, mailer.Send(context.Background(), message))
+
+	delivered, err := os.ReadDir(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+	assert.Len(t, delivered, 2)
+	pending, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	data, err := os.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: Hi\r\n")
+
+	assert.EqualError(t, mailer.Send(context.Background(), &Message{From: "a@example.com"}), "no recipients")
+}
@@ -0,0 +1,16 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type MailerMock struct {
+	mock.Mock
+}
+
+func (m *MailerMock) Send(ctx context.Context, msg *Message) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DefaultSendmailPath is where the sendmail binary usually lives
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// Exit codes of sendmail (sysexits.h) telling that a recipient does not exist
+const (
+	exitNoUser = 67
+	exitNoHost = 68
+)
+
+// SendmailMailer hands messages over to a sendmail compatible binary
+type SendmailMailer struct {
+	// Path of the binary. Defaults to DefaultSendmailPath
+	Path string
+}
+
+func (s *SendmailMailer) Send(ctx context.Context, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	from, to, err := m.envelope()
+	if err != nil {
+		return err
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	path := s.Path
+	if path == "" {
+		path = DefaultSendmailPath
+	}
+	// -i: a line with a single dot does not end the message, -f: envelope sender
+	args := append([]string{"-i", "-f", from, "--"}, to...)
+	// the binary is killed once ctx is done
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && (exit.ExitCode() == exitNoUser || exit.ExitCode() == exitNoHost) {
+			err = fmt.Errorf("%w: %s", ErrRejected, err.Error())
+		}
+		return fmt.Errorf("sendmail: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendmailMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	// fake sendmail binary recording its arguments and input
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s.args\ncat > %s\n", out, out)
+	path := filepath.Join(dir, "sendmail")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho 'no route' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	unknown := filepath.Join(dir, "unknown")
+	if err := os.WriteFile(unknown, []byte("#!/bin/sh\necho 'user unknown' >&2\nexit 67\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{From: "a@example.com", To: []string{"b@example.com", "c@example.com"}, Subject: "Hi", Body: "hello"}
+
+	t.Run("ok", func(t *testing.T) {
+		assert.NoError(t, (&SendmailMailer{Path: path}).Send(context.Background(), message))
+
+		args, err := os.ReadFile(out + ".args")
+		assert.NoError(t, err)
+		assert.Equal(t, "-i -f a@example.com -- b@example.com c@example.com", strings.TrimSpace(string(args)))
+		data, err := os.ReadFile(out)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "Subject: Hi\r\n")
+	})
+
+	t.Run("display names", func(t *testing.T) {
+		named := &Message{From: `"Example Shop" <a@example.com>`, To: []string{"B <b@example.com>"}, Body: "hello"}
+		assert.NoError(t, (&SendmailMailer{Path: path}).Send(context.Background(), named))
+
+		args, err := os.ReadFile(out + ".args")
+		assert.NoError(t, err)
+		assert.Equal(t, "-i -f a@example.com -- b@example.com", strings.TrimSpace(string(args)))
+		data, err := os.ReadFile(out)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "From: \"Example Shop\" <a@example.com>\r\n")
+	})
+
+	t.Run("binary fails", func(t *testing.T) {
+		err := (&SendmailMailer{Path: failing}).Send(context.Background(), message)
+		assert.EqualError(t, err, "sendmail: exit status 1: no route")
+		assert.False(t, errors.Is(err, ErrRejected))
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		err := (&SendmailMailer{Path: unknown}).Send(context.Background(), message)
+		assert.True(t, errors.Is(err, ErrRejected))
+	})
+}
@@ -0,0 +1,128 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer delivers messages to an SMTP relay, authenticating with PLAIN if Username is set
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// StartTLS requires the connection to be upgraded with STARTTLS before authenticating
+	StartTLS bool
+	// TLSConfig is used on STARTTLS. Defaults to verifying the certificate of Host
+	TLSConfig *tls.Config
+	// Timeout bounds the whole SMTP dialog, as does the context given to Send. Defaults to 30 seconds
+	Timeout time.Duration
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m *Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	from, to, err := m.envelope()
+	if err != nil {
+		return err
+	}
+	msg, err := m.Bytes()
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	// the dialog is cut short once ctx is done, e.g. cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer client.Close()
+
+	if err := s.send(client, from, to, msg); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return client.Quit()
+}
+
+func (s *SMTPMailer) send(client *smtp.Client, from string, to []string, msg []byte) error {
+	if s.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", s.Host)
+		}
+		config := s.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: s.Host}
+		}
+		if err := client.StartTLS(config); err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return rejected(err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return rejected(w.Close())
+}
+
+// rejected wraps a permanent failure reply (5xx) of the server with ErrRejected
+func rejected(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code/100 == 5 {
+		return fmt.Errorf("%w: %s", ErrRejected, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type (
+	// smtpStandIn is an in-process SMTP server, good enough for net/smtp
+	smtpStandIn struct {
+		listener net.Listener
+		// tlsConfig enables STARTTLS if set
+		tlsConfig *tls.Config
+		// rejects is a recipient answered with 550
+		rejects  string
+		received chan received
+	}
+
+	// received is what the stand-in got through one SMTP session
+	received struct {
+		auth string
+		tls  bool
+		from string
+		to   []string
+		data string
+	}
+)
+
+func newSMTPStandIn(t *testing.T, tlsConfig *tls.Config, rejects string) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: listener, tlsConfig: tlsConfig, rejects: rejects, received: make(chan received, 1)}
+	t.Cleanup(func() { _ = listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.session(conn)
+	}
+}
+
+func (s *smtpStandIn) session(conn net.Conn) {
+	var r received
+	defer func() {
+		_ = conn.Close()
+		s.received <- r
+	}()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO":
+			if s.tlsConfig != nil && !r.tls {
+				_ = tp.PrintfLine("250-localhost")
+				_ = tp.PrintfLine("250-STARTTLS")
+			} else {
+				_ = tp.PrintfLine("250-localhost")
+			}
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r.tls = tlsConn, true
+			tp = textproto.NewConn(conn)
+		case "AUTH":
+			fields := strings.Fields(line)
+			credentials, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			r.auth = string(credentials)
+			_ = tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			r.from = address(line)
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			if address(line) == s.rejects {
+				_ = tp.PrintfLine("550 no such user")
+				continue
+			}
+			r.to = append(r.to, address(line))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			r.data = string(data)
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// address extracts the address of MAIL FROM:<a> and RCPT TO:<a> commands
+func address(line string) string {
+	return line[strings.Index(line, "<")+1 : strings.Index(line, ">")]
+}
+
+// selfSigned returns a server config with a certificate for 127.0.0.1, and a client config trusting it
+func selfSigned(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	serverTLS, clientTLS := selfSigned(t)
+	message := &Message{
+		From:    "sender@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Hello",
+		Body:    "first line\nsecond line",
+	}
+
+	tests := map[string]struct {
+		serverTLS     *tls.Config
+		rejects       string
+		mailer        SMTPMailer
+		message       *Message
+		expectedError string
+		expectedAuth  string
+		expectedTLS   bool
+	}{
+		"plain": {
+			mailer:  SMTPMailer{},
+			message: message,
+		},
+		"plain auth on localhost": {
+			mailer:       SMTPMailer{Username: "user", Password: "secret"},
+			message:      message,
+			expectedAuth: "\x00user\x00secret",
+		},
+		"starttls and plain auth": {
+			serverTLS:    serverTLS,
+			mailer:       SMTPMailer{Username: "user", Password: "secret", StartTLS: true, TLSConfig: clientTLS},
+			message:      message,
+			expectedAuth: "\x00user\x00secret",
+			expectedTLS:  true,
+		},
+		"starttls not supported": {
+			mailer:        SMTPMailer{StartTLS: true, TLSConfig: clientTLS},
+			message:       message,
+			expectedError: "smtp: 127.0.0.1 does not support STARTTLS",
+		},
+		"starttls untrusted certificate": {
+			serverTLS:     serverTLS,
+			mailer:        SMTPMailer{StartTLS: true},
+			message:       message,
+			expectedError: "certificate signed by unknown authority",
+		},
+		"recipient rejected": {
+			rejects:       "b@example.com",
+			mailer:        SMTPMailer{},
+			message:       message,
+			expectedError: "smtp: recipient rejected: 550",
+		},
+		"invalid message": {
+			mailer:        SMTPMailer{},
+			message:       &Message{From: "sender@example.com"},
+			expectedError: "no recipients",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := newSMTPStandIn(t, test.serverTLS, test.rejects)
+			mailer := test.mailer
+			mailer.Host, mailer.Port, mailer.Timeout = "127.0.0.1", server.port(), 5*time.Second
+
+			err := mailer.Send(context.Background(), test.message)
+			if test.expectedError != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.expectedError)
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			r := <-server.received
+			assert.Equal(t, test.expectedAuth, r.auth)
+			assert.Equal(t, test.expectedTLS, r.tls)
+			assert.Equal(t, "sender@example.com", r.from)
+			assert.Equal(t, []string{"a@example.com", "b@example.com"}, r.to)
+
+			headers, err := textproto.NewReader(bufio.NewReader(strings.NewReader(r.data))).ReadMIMEHeader()
+			assert.NoError(t, err)
+			assert.Equal(t, "Hello", headers.Get("Subject"))
+			assert.Equal(t, "a@example.com, b@example.com", headers.Get("To"))
+			assert.Contains(t, r.data, "\nfirst line\nsecond line\n")
+		})
+	}
+}
+
+func TestSMTPMailer_Send_displayNames(t *testing.T) {
+	server := newSMTPStandIn(t, nil, "")
+	mailer := &SMTPMailer{Host: "127.0.0.1", Port: server.port(), Timeout: 5 * time.Second}
+
+	err := mailer.Send(context.Background(), &Message{
+		From: `"Example Shop" <sender@example.com>`,
+		To:   []string{"A <a@example.com>", "b@example.com"},
+		Body: "hello",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := <-server.received
+	assert.Equal(t, "sender@example.com", r.from)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, r.to)
+	headers, err := textproto.NewReader(bufio.NewReader(strings.NewReader(r.data))).ReadMIMEHeader()
+	assert.NoError(t, err)
+	assert.Equal(t, `"Example Shop" <sender@example.com>`, headers.Get("From"))
+}
+
+func TestSMTPMailer_Send_cancelled(t *testing.T) {
+	server := newSMTPStandIn(t, nil, "")
+	mailer := &SMTPMailer{Host: "127.0.0.1", Port: server.port(), Timeout: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := mailer.Send(ctx, &Message{From: "sender@example.com", To: []string{"a@example.com"}, Body: "hello"})
+	assert.ErrorIs(t, err, context.Canceled)
+}
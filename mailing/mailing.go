@@ -0,0 +1,98 @@
+package mailing
+
+import (
+	"api/render"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Statuses of a Mailing
+const (
+	// StatusDraft mailings are being written
+	StatusDraft = "draft"
+	// StatusScheduled mailings are ready, waiting to be sent at their SendAt time
+	StatusScheduled = "scheduled"
+	// StatusSending mailings have their messages in the outbox
+	StatusSending = "sending"
+	// StatusSent mailings have no pending message left
+	StatusSent = "sent"
+	// StatusCancelled mailings will not be sent, or not any further
+	StatusCancelled = "cancelled"
+)
+
+// transitions maps every status to the ones a mailing may change to from it
+var transitions = map[string][]string{
+	StatusDraft:     {StatusScheduled, StatusSending, StatusCancelled},
+	StatusScheduled: {StatusDraft, StatusSending, StatusCancelled},
+	StatusSending:   {StatusSent, StatusCancelled},
+}
+
+type (
+	// Mailing is a message sent to all its members. Subject, Body and HTMLBody are templates rendered for each
+	// member, see package render.
+	Mailing struct {
+		ID        uint      `json:"id" gorm:"primarykey"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Subject   string    `json:"subject"`
+		// Body is the plain text body
+		Body string `json:"body"`
+		// HTMLBody is the optional HTML alternative of Body
+		HTMLBody string `json:"html_body,omitempty"`
+		Status   string `json:"status" gorm:"index;not null;default:draft"`
+		// SendAt is when a scheduled mailing is sent
+		SendAt *time.Time `json:"send_at,omitempty" gorm:"index"`
+		// OperationID is the operation the messages were queued under once the mailing was sent
+		OperationID string `json:"operation_id,omitempty"`
+		// RequestID is the X-RequestID of the request that sent the mailing
+		RequestID string `json:"request_id,omitempty"`
+		// Queued is how many messages were queued when the mailing was sent
+		Queued int64 `json:"queued"`
+		// StartedAt is when the mailing was sent, that is, when its messages were queued
+		StartedAt *time.Time `json:"started_at,omitempty"`
+		// Error tells why a scheduled mailing could not be sent, and was turned back into a draft
+		Error string `json:"error,omitempty"`
+		// RetentionSeconds overrides the retention period of the customers of the mailing, zero keeping them forever.
+		// Without it, the global one applies.
+		RetentionSeconds *int64 `json:"retention_seconds,omitempty"`
+	}
+
+	// Member links a customer to a mailing
+	Member struct {
+		MailingID  uint `gorm:"primaryKey"`
+		CustomerID uint `gorm:"primaryKey;index"`
+		CreatedAt  time.Time
+	}
+)
+
+func (Member) TableName() string {
+	return "mailing_members"
+}
+
+func (m *Mailing) Validate() error {
+	return validation.Errors{
+		"subject":           validation.Validate(m.Subject, validation.Required, validation.Length(0, 200), render.Text),
+		"body":              validation.Validate(m.Body, validation.Required, validation.Length(0, 10000), render.Text),
+		"html_body":         validation.Validate(m.HTMLBody, validation.Length(0, 100000), render.HTML),
+		"retention_seconds": validation.Validate(m.RetentionSeconds, validation.Min(int64(0))),
+	}.Filter()
+}
+
+// Editable tells whether the subject and body of the mailing may still change
+func (m *Mailing) Editable() bool {
+	return m.Status == StatusDraft || m.Status == StatusScheduled
+}
+
+// From returns the statuses a mailing may change to the given one from
+func From(to string) []string {
+	var from []string
+	for status, next := range transitions {
+		for _, n := range next {
+			if n == to {
+				from = append(from, status)
+			}
+		}
+	}
+	return from
+}
@@ -0,0 +1,66 @@
+package mailing
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrom(t *testing.T) {
+	tests := map[string][]string{
+		StatusDraft:     {StatusScheduled},
+		StatusScheduled: {StatusDraft},
+		StatusSending:   {StatusDraft, StatusScheduled},
+		StatusSent:      {StatusSending},
+		StatusCancelled: {StatusDraft, StatusScheduled, StatusSending},
+	}
+	for to, expected := range tests {
+		t.Run(to, func(t *testing.T) {
+			from := From(to)
+			sort.Strings(from)
+			assert.Equal(t, expected, from)
+		})
+	}
+}
+
+func TestMailing_Validate(t *testing.T) {
+	day, never, negative := int64(86400), int64(0), int64(-1)
+	tests := map[string]struct {
+		mailing   Mailing
+		withError bool
+	}{
+		"OK":         {mailing: Mailing{Subject: "Hi", Body: "hello"}},
+		"no subject": {mailing: Mailing{Body: "hello"}, withError: true},
+		"no body":    {mailing: Mailing{Subject: "Hi"}, withError: true},
+		"templates":  {mailing: Mailing{Subject: "Hi {{.title}}", Body: "{{.email}}", HTMLBody: "<p>{{.email}}</p>"}},
+		"bad subject": {
+			mailing:   Mailing{Subject: "Hi {{.title", Body: "hello"},
+			withError: true,
+		},
+		"unknown field": {
+			mailing:   Mailing{Subject: "Hi", Body: "{{.name}}"},
+			withError: true,
+		},
+		"retention":    {mailing: Mailing{Subject: "Hi", Body: "hello", RetentionSeconds: &day}},
+		"never expire": {mailing: Mailing{Subject: "Hi", Body: "hello", RetentionSeconds: &never}},
+		"negative retention": {
+			mailing:   Mailing{Subject: "Hi", Body: "hello", RetentionSeconds: &negative},
+			withError: true,
+		},
+		"bad html": {
+			mailing:   Mailing{Subject: "Hi", Body: "hello", HTMLBody: "{{end}}"},
+			withError: true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.mailing.Validate()
+			if test.withError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"api/config"
+	"api/dao"
+	"api/migration"
+	"api/postgresql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+var errMigrateUsage = errors.New("usage: migrate up|down|status|to <version>")
+
+// runMigrate runs the migrate command against the database of cfg
+func runMigrate(cfg config.Config, args []string, out io.Writer) error {
+	migrations, err := migration.All()
+	if err != nil {
+		return err
+	}
+	db, err := postgresql.Open(cfg.Database.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return migrate(&dao.MigrationDAO{Db: db, Migrations: migrations}, migrations, args, out)
+}
+
+// migrate runs the migrate command: up applies every pending migration, down undoes the latest one applied, to
+// <version> applies or undoes the migrations up to that version, and status lists the migrations, applied or not.
+func migrate(migrations dao.MigrationDao, known []migration.Migration, args []string, out io.Writer) error {
+	var steps []migration.Step
+	var err error
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		steps, err = migrations.Up()
+	case len(args) == 1 && args[0] == "down":
+		steps, err = migrations.Down()
+	case len(args) == 2 && args[0] == "to":
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return errMigrateUsage
+		}
+		steps, err = migrations.To(version)
+	case len(args) == 1 && args[0] == "status":
+		return status(migrations, known, out)
+	default:
+		return errMigrateUsage
+	}
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		fmt.Fprintln(out, "nothing to do")
+	}
+	for _, s := range steps {
+		fmt.Fprintln(out, s)
+	}
+	return nil
+}
+
+// status writes the version of the schema, and whether each migration is applied
+func status(migrations dao.MigrationDao, known []migration.Migration, out io.Writer) error {
+	applied, err := migrations.Applied()
+	if err != nil {
+		return err
+	}
+	current := 0
+	byVersion := map[int]migration.Record{}
+	for _, r := range applied {
+		byVersion[r.Version] = r
+		current = r.Version
+	}
+	fmt.Fprintf(out, "schema version %d, latest %d\n", current, migration.Latest(known))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, m := range known {
+		state := "pending"
+		if r, ok := byVersion[m.Version]; ok {
+			state = "applied " + r.AppliedAt.UTC().Format(time.RFC3339)
+			delete(byVersion, m.Version)
+		}
+		fmt.Fprintf(w, "%d_%s\t%s\n", m.Version, m.Name, state)
+	}
+	// versions applied by a newer release, which this one cannot undo
+	for _, r := range applied {
+		if _, ok := byVersion[r.Version]; ok {
+			fmt.Fprintf(w, "%d_%s\tunknown\n", r.Version, r.Name)
+		}
+	}
+	return w.Flush()
+}
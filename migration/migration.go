@@ -0,0 +1,163 @@
+package migration
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sqlFiles holds the migrations of the service, named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var (
+	// ErrUnknownVersion is returned for a schema version no migration leads to, e.g. one applied by a newer release
+	ErrUnknownVersion = errors.New("unknown schema version")
+	// ErrPending is returned when the schema is behind the latest migration
+	ErrPending = errors.New("pending migrations")
+)
+
+// fileName matches the name of a migration file
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type (
+	// Migration is a numbered change of the schema. Up applies it, Down undoes it.
+	Migration struct {
+		Version int
+		Name    string
+		Up      string
+		Down    string
+	}
+
+	// Step is a migration to apply, or to undo
+	Step struct {
+		Migration
+		Undo bool
+	}
+
+	// Record tells that a migration was applied
+	Record struct {
+		Version   int       `json:"version" gorm:"primaryKey;autoIncrement:false"`
+		Name      string    `json:"name" gorm:"not null"`
+		AppliedAt time.Time `json:"applied_at" gorm:"not null;default:now()"`
+	}
+)
+
+func (Record) TableName() string {
+	return "schema_migrations"
+}
+
+// All returns the migrations embedded in the binary, by version
+func All() ([]Migration, error) {
+	return Load(sqlFiles, "sql")
+}
+
+// Load reads the migrations of a directory, by version. Versions must follow each other from 1, each with its up and
+// down files.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration: %s is not named <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration: version %d is named both %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(a, b int) bool { return migrations[a].Version < migrations[b].Version })
+	for i, m := range migrations {
+		switch {
+		case m.Version != i+1:
+			return nil, fmt.Errorf("migration: version %d is missing", i+1)
+		case m.Up == "" || m.Down == "":
+			return nil, fmt.Errorf("migration: version %d lacks its up or down file", m.Version)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest is the version of the schema once every migration is applied
+func Latest(migrations []Migration) int {
+	return len(migrations)
+}
+
+// Plan returns the steps leading the schema from the current version to the target one: the migrations in between
+// are applied in ascending order when going up, and undone in descending order when going down. It may return
+// ErrUnknownVersion.
+func Plan(migrations []Migration, current, target int) ([]Step, error) {
+	latest := Latest(migrations)
+	if current < 0 || current > latest {
+		return nil, fmt.Errorf("%w %d: the latest migration is %d", ErrUnknownVersion, current, latest)
+	}
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("%w %d: versions go from 0 to %d", ErrUnknownVersion, target, latest)
+	}
+	var steps []Step
+	for v := current + 1; v <= target; v++ {
+		steps = append(steps, Step{Migration: migrations[v-1]})
+	}
+	for v := current; v > target; v-- {
+		steps = append(steps, Step{Migration: migrations[v-1], Undo: true})
+	}
+	return steps, nil
+}
+
+// Check tells whether the service may run against a schema at the current version: it must be the latest one. It
+// returns ErrUnknownVersion if the schema is ahead, and ErrPending if it is behind.
+func Check(migrations []Migration, current int) error {
+	latest := Latest(migrations)
+	switch {
+	case current > latest:
+		return fmt.Errorf("%w %d: the latest migration known is %d", ErrUnknownVersion, current, latest)
+	case current < latest:
+		return fmt.Errorf("%w: the schema is at version %d, run migrate up to reach %d", ErrPending, current, latest)
+	}
+	return nil
+}
+
+// SQL is what the step runs
+func (s Step) SQL() string {
+	if s.Undo {
+		return s.Down
+	}
+	return s.Up
+}
+
+func (s Step) String() string {
+	if s.Undo {
+		return fmt.Sprintf("undo %d_%s", s.Version, s.Name)
+	}
+	return fmt.Sprintf("apply %d_%s", s.Version, s.Name)
+}
@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+}
+
+func TestLoad(t *testing.T) {
+	file := func(content string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(content)} }
+	tests := map[string]struct {
+		files            fstest.MapFS
+		expectedVersions []int
+		withError        string
+	}{
+		"OK": {
+			files: fstest.MapFS{
+				"sql/0002_two.down.sql": file("down 2"),
+				"sql/0001_one.up.sql":   file("up 1"),
+				"sql/0001_one.down.sql": file("down 1"),
+				"sql/0002_two.up.sql":   file("up 2"),
+			},
+			expectedVersions: []int{1, 2},
+		},
+		"badly named": {
+			files:     fstest.MapFS{"sql/one.up.sql": file("up 1")},
+			withError: "is not named",
+		},
+		"missing version": {
+			files: fstest.MapFS{
+				"sql/0002_two.up.sql":   file("up 2"),
+				"sql/0002_two.down.sql": file("down 2"),
+			},
+			withError: "version 1 is missing",
+		},
+		"missing down": {
+			files:     fstest.MapFS{"sql/0001_one.up.sql": file("up 1")},
+			withError: "lacks its up or down file",
+		},
+		"named twice": {
+			files: fstest.MapFS{
+				"sql/0001_one.up.sql":   file("up 1"),
+				"sql/0001_uno.down.sql": file("down 1"),
+			},
+			withError: "is named both",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := Load(test.files, "sql")
+			if test.withError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.withError)
+				return
+			}
+			require.NoError(t, err)
+			var versions []int
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			assert.Equal(t, test.expectedVersions, versions)
+			assert.Equal(t, "up 1", migrations[0].Up)
+			assert.Equal(t, "down 2", migrations[1].Down)
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "one", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "two", Up: "up 2", Down: "down 2"},
+		{Version: 3, Name: "three", Up: "up 3", Down: "down 3"},
+	}
+	tests := map[string]struct {
+		current, target int
+		expectedSQL     []string
+		withError       error
+	}{
+		"up":              {current: 0, target: 3, expectedSQL: []string{"up 1", "up 2", "up 3"}},
+		"up partly":       {current: 1, target: 2, expectedSQL: []string{"up 2"}},
+		"down":            {current: 3, target: 1, expectedSQL: []string{"down 3", "down 2"}},
+		"nothing to do":   {current: 2, target: 2},
+		"unknown current": {current: 4, target: 3, withError: ErrUnknownVersion},
+		"unknown target":  {current: 0, target: 4, withError: ErrUnknownVersion},
+		"negative target": {current: 1, target: -1, withError: ErrUnknownVersion},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			steps, err := Plan(migrations, test.current, test.target)
+			if test.withError != nil {
+				assert.ErrorIs(t, err, test.withError)
+				return
+			}
+			require.NoError(t, err)
+			var sql []string
+			for _, s := range steps {
+				sql = append(sql, s.SQL())
+			}
+			assert.Equal(t, test.expectedSQL, sql)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}}
+	assert.NoError(t, Check(migrations, 2))
+	assert.ErrorIs(t, Check(migrations, 1), ErrPending)
+	assert.ErrorIs(t, Check(migrations, 3), ErrUnknownVersion)
+}
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS job_runs;
+DROP TABLE IF EXISTS job_settings;
+DROP TABLE IF EXISTS leases;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS suppressions;
+DROP TABLE IF EXISTS mailing_members;
+DROP TABLE IF EXISTS mailings;
+DROP TABLE IF EXISTS dead_letters;
+DROP TABLE IF EXISTS outbox_messages;
+DROP TABLE IF EXISTS customers;
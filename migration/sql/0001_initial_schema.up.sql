@@ -0,0 +1,147 @@
+-- The schema as created by gorm AutoMigrate, which preceded versioned migrations. Every statement is a no-op on a
+-- database it created, so that such databases are brought under migrations as they are.
+
+CREATE TABLE IF NOT EXISTS customers (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    email      text,
+    title      text,
+    content    text,
+    mailing_id bigint,
+    expires_at timestamptz,
+    legal_hold boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_customers_deleted_at ON customers (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_multi ON customers (email, title, content, mailing_id);
+CREATE INDEX IF NOT EXISTS idx_customers_expires_at ON customers (expires_at);
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id              bigserial PRIMARY KEY,
+    operation_id    text,
+    request_id      text,
+    customer_id     bigint,
+    mailing_id      bigint,
+    recipient       text,
+    subject         text,
+    body            text,
+    html_body       text,
+    status          text,
+    error           text,
+    attempts        bigint,
+    next_attempt_at timestamptz,
+    sent_at         timestamptz,
+    created_at      timestamptz,
+    updated_at      timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_operation_id ON outbox_messages (operation_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_customer_id ON outbox_messages (customer_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_mailing_id ON outbox_messages (mailing_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_status ON outbox_messages (status);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id           bigserial PRIMARY KEY,
+    message_id   bigint,
+    operation_id text,
+    request_id   text,
+    customer_id  bigint,
+    mailing_id   bigint,
+    recipient    text,
+    subject      text,
+    attempts     bigint,
+    error        text,
+    created_at   timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dead_letters_message_id ON dead_letters (message_id);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_mailing_id ON dead_letters (mailing_id);
+
+CREATE TABLE IF NOT EXISTS mailings (
+    id                bigserial PRIMARY KEY,
+    created_at        timestamptz,
+    updated_at        timestamptz,
+    subject           text,
+    body              text,
+    html_body         text,
+    status            text NOT NULL DEFAULT 'draft',
+    send_at           timestamptz,
+    operation_id      text,
+    request_id        text,
+    queued            bigint,
+    started_at        timestamptz,
+    error             text,
+    retention_seconds bigint
+);
+CREATE INDEX IF NOT EXISTS idx_mailings_status ON mailings (status);
+CREATE INDEX IF NOT EXISTS idx_mailings_send_at ON mailings (send_at);
+
+CREATE TABLE IF NOT EXISTS mailing_members (
+    mailing_id  bigint,
+    customer_id bigint,
+    created_at  timestamptz,
+    PRIMARY KEY (mailing_id, customer_id)
+);
+CREATE INDEX IF NOT EXISTS idx_mailing_members_customer_id ON mailing_members (customer_id);
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    email      text PRIMARY KEY,
+    reason     text,
+    mailing_id bigint,
+    created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id         bigserial PRIMARY KEY,
+    url        text,
+    events     text,
+    secret     text,
+    created_at timestamptz,
+    updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              bigserial PRIMARY KEY,
+    subscription_id bigint,
+    event_type      text,
+    event           text,
+    request_id      text,
+    status          text,
+    attempts        bigint,
+    response_status bigint,
+    error           text,
+    next_attempt_at timestamptz,
+    delivered_at    timestamptz,
+    created_at      timestamptz,
+    updated_at      timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status ON webhook_deliveries (status);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS leases (
+    name        text PRIMARY KEY,
+    holder      text,
+    acquired_at timestamptz,
+    expires_at  timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS job_settings (
+    tag              text PRIMARY KEY,
+    interval_seconds bigint,
+    paused           boolean,
+    updated_at       timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS job_runs (
+    id          bigserial PRIMARY KEY,
+    job         text,
+    replica     text,
+    trigger     text,
+    started_at  timestamptz,
+    duration_ms bigint,
+    rows        bigint,
+    error       text
+);
+CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs (job);
+CREATE INDEX IF NOT EXISTS idx_job_runs_started_at ON job_runs (started_at);
@@ -0,0 +1,2 @@
+-- The mailings and members backfilled are kept: they cannot be told apart from the ones created since, and may have
+-- been edited or sent.
@@ -0,0 +1,21 @@
+-- Mailings used to be the mailing_id, title and content repeated on every customer. Every mailing_id without a
+-- mailing becomes a draft one, written like its first customer, and every customer becomes a member of the mailing
+-- of its mailing_id, so that mailings sent before versioned migrations can be sent the same way.
+
+INSERT INTO mailings (id, created_at, updated_at, subject, body, status)
+SELECT DISTINCT ON (mailing_id) mailing_id, now(), now(), title, content, 'draft'
+FROM customers
+WHERE deleted_at IS NULL
+  AND mailing_id <> 0
+  AND mailing_id NOT IN (SELECT id FROM mailings)
+ORDER BY mailing_id, id;
+
+-- the ids taken above are not handed out by the sequence of mailings
+SELECT setval(pg_get_serial_sequence('mailings', 'id'), COALESCE((SELECT MAX(id) FROM mailings), 0) + 1, false);
+
+INSERT INTO mailing_members (mailing_id, customer_id, created_at)
+SELECT mailing_id, id, now()
+FROM customers
+WHERE deleted_at IS NULL
+  AND mailing_id IN (SELECT id FROM mailings)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,140 @@
+package outbox
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Statuses of a Message
+const (
+	// StatusPending messages are waiting for the dispatcher
+	StatusPending = "pending"
+	// StatusSent messages were accepted by the mailer
+	StatusSent = "sent"
+	// StatusFailed messages were rejected by the mailer on every attempt, and copied to the dead letters
+	StatusFailed = "failed"
+	// StatusBounced messages were rejected for good by the mailer, which suppressed their recipient
+	StatusBounced = "bounced"
+	// StatusSuppressed messages were not sent because their recipient is suppressed
+	StatusSuppressed = "suppressed"
+	// StatusCancelled messages were pending when their mailing was cancelled
+	StatusCancelled = "cancelled"
+)
+
+// Statuses lists every status of a Message
+var Statuses = []string{StatusPending, StatusSent, StatusFailed, StatusBounced, StatusSuppressed, StatusCancelled}
+
+// ErrBounced is wrapped by the errors of sending a message when its recipient was rejected for good, so that it is
+// not attempted again
+var ErrBounced = errors.New("bounced")
+
+// ClaimLease is how long a message claimed by a dispatcher is kept from the others while it is being sent. A
+// dispatcher dying meanwhile leaves the message to be sent again once the lease is over.
+const ClaimLease = 5 * time.Minute
+
+// PostSendAction is what happens to a customer once its message was sent
+type PostSendAction string
+
+const (
+	// PostSendDelete soft deletes the customer
+	PostSendDelete PostSendAction = "delete"
+	// PostSendKeep leaves the customer untouched
+	PostSendKeep PostSendAction = "keep"
+)
+
+// RetryPolicy tells how many times, and how often, sending a message is attempted
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts after which a message is given up on
+	MaxAttempts int
+	// BaseDelay is the wait after the first failed attempt, doubled after every other one
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between two attempts
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy attempts to send a message 5 times, waiting up to 1, 2, 4 and 8 minutes in between
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+// Exhausted tells whether a message that failed its given attempt, counting from 1, is to be given up on
+func (p RetryPolicy) Exhausted(attempt int) bool {
+	return attempt >= p.MaxAttempts
+}
+
+// Backoff returns how long to wait after the given failed attempt, counting from 1. The exponential delay is
+// jittered, between half of it and all of it, so that messages failing together are not retried together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// Message is a mail waiting in the outbox to be sent to one member of a mailing. Messages are kept once sent or given
+// up on: they are the log of what every member of a mailing got.
+// Subject and bodies are rendered from the mailing templates when it is sent.
+type Message struct {
+	ID          uint   `json:"id" gorm:"primarykey"`
+	OperationID string `json:"operation_id" gorm:"index"`
+	// RequestID is the X-RequestID of the request that queued the message, to trace it in the logs
+	RequestID  string `json:"request_id,omitempty"`
+	CustomerID uint   `json:"customer_id" gorm:"index"`
+	MailingID  int64  `json:"mailing_id" gorm:"index"`
+	Recipient  string `json:"recipient"`
+	Subject    string `json:"subject"`
+	// Body and HTMLBody are left out of the message log
+	Body     string `json:"-"`
+	HTMLBody string `json:"-"`
+	Status   string `json:"status" gorm:"index"`
+	Error    string `json:"error,omitempty"`
+	// Attempts counts the failed attempts to send the message
+	Attempts int `json:"attempts"`
+	// NextAttemptAt is when a failed message may be attempted again, nil if it never failed
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" gorm:"index"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+// DeadLetter is a copy of a Message that failed on every attempt, kept for inspection until it is requeued
+type DeadLetter struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	MessageID   uint      `json:"message_id" gorm:"uniqueIndex"`
+	OperationID string    `json:"operation_id"`
+	RequestID   string    `json:"request_id,omitempty"`
+	CustomerID  uint      `json:"customer_id"`
+	MailingID   int64     `json:"mailing_id" gorm:"index"`
+	Recipient   string    `json:"recipient"`
+	Subject     string    `json:"subject"`
+	Attempts    int       `json:"attempts"`
+	Error       string    `json:"error"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NewDeadLetter copies a message given up on after the given attempts, the last one failing for reason
+func NewDeadLetter(m *Message, attempts int, reason string) DeadLetter {
+	return DeadLetter{
+		MessageID:   m.ID,
+		OperationID: m.OperationID,
+		RequestID:   m.RequestID,
+		CustomerID:  m.CustomerID,
+		MailingID:   m.MailingID,
+		Recipient:   m.Recipient,
+		Subject:     m.Subject,
+		Attempts:    attempts,
+		Error:       reason,
+	}
+}
+
+func (DeadLetter) TableName() string {
+	return "dead_letters"
+}
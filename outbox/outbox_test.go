@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	tests := map[string]struct {
+		attempt  int
+		expected time.Duration
+	}{
+		"first attempt":  {attempt: 1, expected: time.Minute},
+		"second attempt": {attempt: 2, expected: 2 * time.Minute},
+		"third attempt":  {attempt: 3, expected: 4 * time.Minute},
+		"capped":         {attempt: 5, expected: 10 * time.Minute},
+		"far beyond":     {attempt: 100, expected: 10 * time.Minute},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d := policy.Backoff(test.attempt)
+				assert.GreaterOrEqual(t, int64(d), int64(test.expected/2))
+				assert.LessOrEqual(t, int64(d), int64(test.expected))
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	assert.False(t, policy.Exhausted(1))
+	assert.False(t, policy.Exhausted(2))
+	assert.True(t, policy.Exhausted(3))
+	assert.True(t, policy.Exhausted(4))
+}
@@ -1,3 +1,5 @@
 create database customer;
 
-create schema roberto;
\ No newline at end of file
+create schema roberto;
+
+-- the tables are created by `api migrate up`
@@ -0,0 +1,57 @@
+package postgresql
+
+import (
+	"api/job"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobDb is the part of Db dealing with the settings and history of the cron jobs
+type JobDb interface {
+	// SaveJobSettings inserts or overwrites the settings of a job
+	SaveJobSettings(*job.Settings) *gorm.DB
+	// FindJobSettings retrieves the settings of every job
+	FindJobSettings() ([]job.Settings, *gorm.DB)
+	// CreateJobRun inserts a run of a job
+	CreateJobRun(*job.Run) *gorm.DB
+	// FindJobRuns retrieves up to limit runs of a job with an id lower than before, unless it is zero, the latest
+	// first
+	FindJobRuns(tag string, before uint, limit int) ([]job.Run, *gorm.DB)
+	// LastJobRuns retrieves the latest run of every job
+	LastJobRuns() ([]job.Run, *gorm.DB)
+	// DeleteJobRuns removes the runs started before the given time
+	DeleteJobRuns(before time.Time) *gorm.DB
+}
+
+func (d *DBase) SaveJobSettings(s *job.Settings) *gorm.DB {
+	return d.Tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(s)
+}
+
+func (d *DBase) FindJobSettings() (ss []job.Settings, tx *gorm.DB) {
+	tx = d.Tx.Find(&ss)
+	return
+}
+
+func (d *DBase) CreateJobRun(r *job.Run) *gorm.DB {
+	return d.Tx.Create(r)
+}
+
+func (d *DBase) FindJobRuns(tag string, before uint, limit int) (rs []job.Run, tx *gorm.DB) {
+	tx = d.Tx.Where("job = ?", tag)
+	if before > 0 {
+		tx = tx.Where("id < ?", before)
+	}
+	tx = tx.Order("id DESC").Limit(limit).Find(&rs)
+	return
+}
+
+func (d *DBase) LastJobRuns() (rs []job.Run, tx *gorm.DB) {
+	tx = d.Tx.Raw("SELECT DISTINCT ON (job) * FROM job_runs ORDER BY job, id DESC").Scan(&rs)
+	return
+}
+
+func (d *DBase) DeleteJobRuns(before time.Time) *gorm.DB {
+	return d.Tx.Where("started_at < ?", before).Delete(&job.Run{})
+}
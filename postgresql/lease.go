@@ -0,0 +1,43 @@
+package postgresql
+
+import (
+	"api/leader"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LeaseDb is the part of Db dealing with the leases of leadership
+type LeaseDb interface {
+	// AcquireLease takes a lease for holder until ttl from now, provided it is expired or held by holder already,
+	// who renews it then. It returns the lease taken, or RowsAffected zero if it is held by somebody else.
+	AcquireLease(name, holder string, ttl time.Duration) (leader.Lease, *gorm.DB)
+	// ReleaseLease gives up a lease, provided it is held by holder
+	ReleaseLease(name, holder string) *gorm.DB
+	// FirstLease retrieves a lease by name
+	FirstLease(name string) (leader.Lease, *gorm.DB)
+}
+
+// acquireLease upserts a lease, the time of the database being the only one leases are compared with
+const acquireLease = `INSERT INTO leases (name, holder, acquired_at, expires_at)
+VALUES (?, ?, NOW(), NOW() + make_interval(secs => ?))
+ON CONFLICT (name) DO UPDATE SET
+	holder = EXCLUDED.holder,
+	acquired_at = CASE WHEN leases.holder = EXCLUDED.holder THEN leases.acquired_at ELSE EXCLUDED.acquired_at END,
+	expires_at = EXCLUDED.expires_at
+WHERE leases.holder = EXCLUDED.holder OR leases.expires_at <= NOW()
+RETURNING *`
+
+func (d *DBase) AcquireLease(name, holder string, ttl time.Duration) (l leader.Lease, tx *gorm.DB) {
+	tx = d.Tx.Raw(acquireLease, name, holder, ttl.Seconds()).Scan(&l)
+	return
+}
+
+func (d *DBase) ReleaseLease(name, holder string) *gorm.DB {
+	return d.Tx.Where("name = ? AND holder = ?", name, holder).Delete(&leader.Lease{})
+}
+
+func (d *DBase) FirstLease(name string) (l leader.Lease, tx *gorm.DB) {
+	tx = d.Tx.Where("name = ?", name).First(&l)
+	return
+}
@@ -0,0 +1,152 @@
+package postgresql
+
+import (
+	"api/mailing"
+	"api/outbox"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MailingDb is the part of Db dealing with mailings and their members
+type MailingDb interface {
+	// CreateMailing inserts a new mailing
+	CreateMailing(*mailing.Mailing) *gorm.DB
+	// FirstMailing retrieves a mailing by primary key
+	FirstMailing(int64) (mailing.Mailing, *gorm.DB)
+	// FindMailings retrieves up to limit mailings with an id greater than after, optionally in the given status
+	FindMailings(status string, after uint, limit int) ([]mailing.Mailing, *gorm.DB)
+	// UpdateMailing overwrites the subject, bodies and retention of a mailing, provided they are still editable
+	UpdateMailing(*mailing.Mailing) *gorm.DB
+	// DeleteMailing removes a mailing, provided it is in one of the given statuses
+	DeleteMailing(id int64, statuses []string) *gorm.DB
+	// SetMailingStatus changes the status of a mailing, provided it may change to it from the current one
+	SetMailingStatus(id int64, to string) *gorm.DB
+	// ScheduleMailing schedules a draft or scheduled mailing to be sent at the given time
+	ScheduleMailing(id int64, at time.Time) *gorm.DB
+	// UnscheduleMailing turns a scheduled mailing back into a draft
+	UnscheduleMailing(id int64) *gorm.DB
+	// FindDueMailings retrieves the scheduled mailings due to be sent at the given time, the earliest first
+	FindDueMailings(now time.Time) ([]mailing.Mailing, *gorm.DB)
+	// StartDueMailing changes a mailing to sending, provided it is still scheduled and due at the given time
+	StartDueMailing(id int64, now time.Time) *gorm.DB
+	// RecordSend records the operation the messages of a mailing were queued under, the request that queued them,
+	// and how many
+	RecordSend(id int64, operationID, requestID string, queued int64) *gorm.DB
+	// FailMailing turns a scheduled mailing that could not be sent back into a draft, recording why
+	FailMailing(id int64, reason string) *gorm.DB
+	// CompleteMailings marks as sent the sending mailings without pending messages, and returns them
+	CompleteMailings() ([]mailing.Mailing, *gorm.DB)
+	// AddMembers links the given existing customers to a mailing. Customers already linked are skipped.
+	AddMembers(mailingID int64, customerIDs []uint) *gorm.DB
+	// RemoveMembers unlinks the given customers from a mailing, or all of them if customerIDs is nil
+	RemoveMembers(mailingID int64, customerIDs []uint) *gorm.DB
+	// DeleteMemberships unlinks the given customers from every mailing
+	DeleteMemberships(customerIDs []uint) *gorm.DB
+}
+
+func (d *DBase) CreateMailing(m *mailing.Mailing) *gorm.DB {
+	return d.Tx.Create(m)
+}
+
+func (d *DBase) FirstMailing(id int64) (m mailing.Mailing, tx *gorm.DB) {
+	tx = d.Tx.First(&m, id)
+	return
+}
+
+func (d *DBase) FindMailings(status string, after uint, limit int) (ms []mailing.Mailing, tx *gorm.DB) {
+	tx = d.Tx.Where("id > ?", after)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	tx = tx.Order("id").Limit(limit).Find(&ms)
+	return
+}
+
+func (d *DBase) UpdateMailing(m *mailing.Mailing) *gorm.DB {
+	return d.Tx.Model(m).
+		Where("status IN ?", []string{mailing.StatusDraft, mailing.StatusScheduled}).
+		Select("subject", "body", "html_body", "retention_seconds").
+		Updates(m)
+}
+
+func (d *DBase) DeleteMailing(id int64, statuses []string) *gorm.DB {
+	return d.Tx.Where("status IN ?", statuses).Delete(&mailing.Mailing{}, id)
+}
+
+func (d *DBase) SetMailingStatus(id int64, to string) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ? AND status IN ?", id, mailing.From(to)).
+		Update("status", to)
+}
+
+func (d *DBase) ScheduleMailing(id int64, at time.Time) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ? AND status IN ?", id, []string{mailing.StatusDraft, mailing.StatusScheduled}).
+		Updates(map[string]interface{}{"status": mailing.StatusScheduled, "send_at": at, "error": ""})
+}
+
+func (d *DBase) UnscheduleMailing(id int64) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ? AND status = ?", id, mailing.StatusScheduled).
+		Updates(map[string]interface{}{"status": mailing.StatusDraft, "send_at": nil})
+}
+
+func (d *DBase) FindDueMailings(now time.Time) (ms []mailing.Mailing, tx *gorm.DB) {
+	tx = d.Tx.Where("status = ? AND send_at <= ?", mailing.StatusScheduled, now).Order("send_at, id").Find(&ms)
+	return
+}
+
+func (d *DBase) StartDueMailing(id int64, now time.Time) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ? AND status = ? AND send_at <= ?", id, mailing.StatusScheduled, now).
+		Update("status", mailing.StatusSending)
+}
+
+func (d *DBase) RecordSend(id int64, operationID, requestID string, queued int64) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"operation_id": operationID,
+			"request_id":   requestID,
+			"queued":       queued,
+			"started_at":   d.Tx.NowFunc(),
+			"error":        "",
+		})
+}
+
+func (d *DBase) FailMailing(id int64, reason string) *gorm.DB {
+	return d.Tx.Model(&mailing.Mailing{}).
+		Where("id = ? AND status = ?", id, mailing.StatusScheduled).
+		Updates(map[string]interface{}{"status": mailing.StatusDraft, "send_at": nil, "error": reason})
+}
+
+func (d *DBase) CompleteMailings() (ms []mailing.Mailing, tx *gorm.DB) {
+	tx = d.Tx.Model(&ms).
+		Clauses(clause.Returning{}).
+		Where("status = ?", mailing.StatusSending).
+		Where("NOT EXISTS (SELECT 1 FROM outbox_messages o WHERE o.mailing_id = mailings.id AND o.status = ?)",
+			outbox.StatusPending).
+		Update("status", mailing.StatusSent)
+	return
+}
+
+func (d *DBase) AddMembers(mailingID int64, customerIDs []uint) *gorm.DB {
+	return d.Tx.Exec(`INSERT INTO mailing_members (mailing_id, customer_id, created_at)
+		SELECT ?, c.id, NOW() FROM customers c
+		WHERE c.id IN ? AND c.deleted_at IS NULL
+		ON CONFLICT DO NOTHING`, mailingID, customerIDs)
+}
+
+func (d *DBase) RemoveMembers(mailingID int64, customerIDs []uint) *gorm.DB {
+	tx := d.Tx.Where("mailing_id = ?", mailingID)
+	if customerIDs != nil {
+		tx = tx.Where("customer_id IN ?", customerIDs)
+	}
+	return tx.Delete(&mailing.Member{})
+}
+
+func (d *DBase) DeleteMemberships(customerIDs []uint) *gorm.DB {
+	return d.Tx.Where("customer_id IN ?", customerIDs).Delete(&mailing.Member{})
+}
@@ -0,0 +1,55 @@
+package postgresql
+
+import (
+	"api/migration"
+
+	"gorm.io/gorm"
+)
+
+// lockMigrations takes the advisory lock of the migrations until the end of the transaction, then creates the table
+// recording them unless it exists, which concurrent transactions would race to do otherwise
+const lockMigrations = `SELECT pg_advisory_xact_lock(hashtext('schema_migrations'));
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    bigint PRIMARY KEY,
+    name       text NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// MigrationDb is the part of Db dealing with the versions of the schema. Its methods are meant to run within a
+// transaction, LockMigrations first.
+type MigrationDb interface {
+	// LockMigrations waits for the other transactions migrating the schema to be over, and keeps new ones waiting
+	// until the end of this one
+	LockMigrations() *gorm.DB
+	// FindMigrations retrieves the records of the migrations applied, by version
+	FindMigrations() ([]migration.Record, *gorm.DB)
+	// ExecMigration runs the statements of a migration
+	ExecMigration(sql string) error
+	// CreateMigration records a migration as applied
+	CreateMigration(*migration.Record) *gorm.DB
+	// DeleteMigration removes the record of an undone migration
+	DeleteMigration(version int) *gorm.DB
+}
+
+func (d *DBase) LockMigrations() *gorm.DB {
+	return d.Tx.Exec(lockMigrations)
+}
+
+func (d *DBase) FindMigrations() (rs []migration.Record, tx *gorm.DB) {
+	tx = d.Tx.Order("version").Find(&rs)
+	return
+}
+
+// ExecMigration runs the statements as they are written, bypassing gorm, which would take ? and @ for placeholders
+func (d *DBase) ExecMigration(sql string) error {
+	_, err := d.Tx.Statement.ConnPool.ExecContext(d.Tx.Statement.Context, sql)
+	return err
+}
+
+func (d *DBase) CreateMigration(r *migration.Record) *gorm.DB {
+	return d.Tx.Create(r)
+}
+
+func (d *DBase) DeleteMigration(version int) *gorm.DB {
+	return d.Tx.Delete(&migration.Record{}, version)
+}
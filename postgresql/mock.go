@@ -2,36 +2,48 @@ package postgresql
 
 import (
 	"api/customer"
+	"api/job"
+	"api/leader"
+	"api/mailing"
+	"api/migration"
+	"api/outbox"
+	"api/suppression"
+	"api/webhook"
+	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"gorm.io/gorm"
 )
 
-type DataBaseMock struct {
-	mock.Mock
-}
+type (
+	DataBaseMock struct {
+		mock.Mock
+	}
 
-func (d *DataBaseMock) DeleteByMailingID(mailingID int64) *gorm.DB {
-	args := d.Called(mailingID)
-	return &gorm.DB{
-		RowsAffected: args.Get(0).(int64),
-		Error:        args.Error(1),
+	// contextMock is a DataBaseMock bound to a context, whose transactions fail once it is done, as they would
+	contextMock struct {
+		*DataBaseMock
+		ctx context.Context
 	}
-}
+)
 
 func (d *DataBaseMock) Create(customer *customer.Customer) *gorm.DB {
 	args := d.Called(customer)
 	return &gorm.DB{Error: args.Error(0)}
 }
 
-func (d *DataBaseMock) Migrate(customer *customer.Customer) error {
-	args := d.Called(customer)
+func (d *DataBaseMock) Close() error {
+	args := d.Called()
 	return args.Error(0)
 }
 
 func (d *DataBaseMock) Delete(customer *customer.Customer, id int64) *gorm.DB {
 	args := d.Called(customer, id)
-	return &gorm.DB{Error: args.Error(0)}
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
 }
 
 func (d *DataBaseMock) First(id int64) (c customer.Customer, tx *gorm.DB) {
@@ -43,17 +55,553 @@ func (d *DataBaseMock) First(id int64) (c customer.Customer, tx *gorm.DB) {
 	return
 }
 
-func (d *DataBaseMock) Find() ([]customer.Customer, *gorm.DB) {
-	args := d.Called()
+func (d *DataBaseMock) Find(q FindQuery) ([]customer.Customer, *gorm.DB) {
+	args := d.Called(q)
 	return args.Get(0).([]customer.Customer), &gorm.DB{
 		Error: args.Error(1),
 	}
 }
 
-func (d *DataBaseMock) DeleteOld(seconds int) *gorm.DB {
-	args := d.Called(seconds)
+func (d *DataBaseMock) DeleteExpired(period time.Duration) (cs []customer.Customer, tx *gorm.DB) {
+	args := d.Called(period)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		cs = args.Get(0).([]customer.Customer)
+		tx.RowsAffected = int64(len(cs))
+	}
+	return
+}
+
+func (d *DataBaseMock) Update(c *customer.Customer, updatedAt time.Time) *gorm.DB {
+	args := d.Called(c, updatedAt)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+// Transaction runs fn against the mock itself
+func (d *DataBaseMock) Transaction(fn func(Db) error) error {
+	d.Called()
+	return fn(d)
+}
+
+// WithContext returns the mock bound to ctx: its transactions fail once ctx is done, its other statements never do
+func (d *DataBaseMock) WithContext(ctx context.Context) Db {
+	return &contextMock{DataBaseMock: d, ctx: ctx}
+}
+
+// Transaction runs fn against the mock itself, unless the context of the mock is done
+func (d *contextMock) Transaction(fn func(Db) error) error {
+	d.Called()
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	return fn(d.DataBaseMock)
+}
+
+func (d *DataBaseMock) Restore(id int64) *gorm.DB {
+	args := d.Called(id)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) Recipients(mailingID int64) ([]customer.Customer, *gorm.DB) {
+	args := d.Called(mailingID)
+	return args.Get(0).([]customer.Customer), &gorm.DB{Error: args.Error(1)}
+}
+
+func (d *DataBaseMock) SuppressedMembers(mailingID int64) ([]customer.Customer, *gorm.DB) {
+	args := d.Called(mailingID)
+	return args.Get(0).([]customer.Customer), &gorm.DB{Error: args.Error(1)}
+}
+
+func (d *DataBaseMock) CreateMessages(ms []outbox.Message) *gorm.DB {
+	args := d.Called(ms)
+	return &gorm.DB{
+		RowsAffected: int64(len(ms)),
+		Error:        args.Error(0),
+	}
+}
+
+func (d *DataBaseMock) ClaimNext(lease time.Duration) (m outbox.Message, tx *gorm.DB) {
+	args := d.Called(lease)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		m = args.Get(0).(outbox.Message)
+	}
+	return
+}
+
+func (d *DataBaseMock) MarkSent(id uint) *gorm.DB {
+	args := d.Called(id)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) Reschedule(id uint, attempts int, reason string, at time.Time) *gorm.DB {
+	args := d.Called(id, attempts, reason, at)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) MarkFailed(id uint, attempts int, reason string) *gorm.DB {
+	args := d.Called(id, attempts, reason)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) MarkBounced(id uint, attempts int, reason string) *gorm.DB {
+	args := d.Called(id, attempts, reason)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindMessages(q MessageQuery) (ms []outbox.Message, tx *gorm.DB) {
+	args := d.Called(q)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ms = args.Get(0).([]outbox.Message)
+	}
+	return
+}
+
+func (d *DataBaseMock) CountMessages(mailingID int64) (counts map[string]int64, tx *gorm.DB) {
+	args := d.Called(mailingID)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		counts = args.Get(0).(map[string]int64)
+	}
+	return
+}
+
+func (d *DataBaseMock) CreateDeadLetter(l *outbox.DeadLetter) *gorm.DB {
+	args := d.Called(l)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindDeadLetters(mailingID int64, after uint, limit int) (ls []outbox.DeadLetter, tx *gorm.DB) {
+	args := d.Called(mailingID, after, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ls = args.Get(0).([]outbox.DeadLetter)
+	}
+	return
+}
+
+func (d *DataBaseMock) Requeue(mailingID int64, ids []uint, requestID string) *gorm.DB {
+	args := d.Called(mailingID, ids, requestID)
 	return &gorm.DB{
 		RowsAffected: args.Get(0).(int64),
 		Error:        args.Error(1),
 	}
 }
+
+func (d *DataBaseMock) DeleteDeadLetters(mailingID int64, ids []uint) *gorm.DB {
+	args := d.Called(mailingID, ids)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) CancelPending(mailingID int64) *gorm.DB {
+	args := d.Called(mailingID)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) CreateMailing(m *mailing.Mailing) *gorm.DB {
+	args := d.Called(m)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FirstMailing(id int64) (m mailing.Mailing, tx *gorm.DB) {
+	args := d.Called(id)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		m = args.Get(0).(mailing.Mailing)
+	}
+	return
+}
+
+func (d *DataBaseMock) FindMailings(status string, after uint, limit int) ([]mailing.Mailing, *gorm.DB) {
+	args := d.Called(status, after, limit)
+	return args.Get(0).([]mailing.Mailing), &gorm.DB{Error: args.Error(1)}
+}
+
+func (d *DataBaseMock) UpdateMailing(m *mailing.Mailing) *gorm.DB {
+	args := d.Called(m)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) DeleteMailing(id int64, statuses []string) *gorm.DB {
+	args := d.Called(id, statuses)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) SetMailingStatus(id int64, to string) *gorm.DB {
+	args := d.Called(id, to)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) ScheduleMailing(id int64, at time.Time) *gorm.DB {
+	args := d.Called(id, at)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) UnscheduleMailing(id int64) *gorm.DB {
+	args := d.Called(id)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) FindDueMailings(now time.Time) (ms []mailing.Mailing, tx *gorm.DB) {
+	args := d.Called(now)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ms = args.Get(0).([]mailing.Mailing)
+	}
+	return
+}
+
+func (d *DataBaseMock) StartDueMailing(id int64, now time.Time) *gorm.DB {
+	args := d.Called(id, now)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) RecordSend(id int64, operationID, requestID string, queued int64) *gorm.DB {
+	args := d.Called(id, operationID, requestID, queued)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FailMailing(id int64, reason string) *gorm.DB {
+	args := d.Called(id, reason)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) CompleteMailings() (ms []mailing.Mailing, tx *gorm.DB) {
+	args := d.Called()
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ms = args.Get(0).([]mailing.Mailing)
+		tx.RowsAffected = int64(len(ms))
+	}
+	return
+}
+
+func (d *DataBaseMock) AddMembers(mailingID int64, customerIDs []uint) *gorm.DB {
+	args := d.Called(mailingID, customerIDs)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) RemoveMembers(mailingID int64, customerIDs []uint) *gorm.DB {
+	args := d.Called(mailingID, customerIDs)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) CreateSuppression(s *suppression.Suppression) *gorm.DB {
+	args := d.Called(s)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FirstSuppression(email string) (s suppression.Suppression, tx *gorm.DB) {
+	args := d.Called(email)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		s = args.Get(0).(suppression.Suppression)
+	}
+	return
+}
+
+func (d *DataBaseMock) FindSuppressions(after string, limit int) (ss []suppression.Suppression, tx *gorm.DB) {
+	args := d.Called(after, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ss = args.Get(0).([]suppression.Suppression)
+	}
+	return
+}
+
+func (d *DataBaseMock) DeleteSuppression(email string) *gorm.DB {
+	args := d.Called(email)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) SuppressPending(email string) *gorm.DB {
+	args := d.Called(email)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) FirstWithDeleted(id int64) (c customer.Customer, tx *gorm.DB) {
+	args := d.Called(id)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		c = args.Get(0).(customer.Customer)
+	}
+	return
+}
+
+func (d *DataBaseMock) CreateSubscription(s *webhook.Subscription) *gorm.DB {
+	args := d.Called(s)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FirstSubscription(id uint) (s webhook.Subscription, tx *gorm.DB) {
+	args := d.Called(id)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		s = args.Get(0).(webhook.Subscription)
+	}
+	return
+}
+
+func (d *DataBaseMock) FindSubscriptions(after uint, limit int) (ss []webhook.Subscription, tx *gorm.DB) {
+	args := d.Called(after, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ss = args.Get(0).([]webhook.Subscription)
+	}
+	return
+}
+
+func (d *DataBaseMock) UpdateSubscription(s *webhook.Subscription) *gorm.DB {
+	args := d.Called(s)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) DeleteSubscription(id uint) *gorm.DB {
+	args := d.Called(id)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) Subscribers(eventType string) (ss []webhook.Subscription, tx *gorm.DB) {
+	args := d.Called(eventType)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ss = args.Get(0).([]webhook.Subscription)
+	}
+	return
+}
+
+func (d *DataBaseMock) CreateDeliveries(ds []webhook.Delivery) *gorm.DB {
+	args := d.Called(ds)
+	return &gorm.DB{
+		RowsAffected: int64(len(ds)),
+		Error:        args.Error(0),
+	}
+}
+
+func (d *DataBaseMock) ClaimDelivery(lease time.Duration) (dl webhook.Delivery, tx *gorm.DB) {
+	args := d.Called(lease)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		dl = args.Get(0).(webhook.Delivery)
+	}
+	return
+}
+
+func (d *DataBaseMock) MarkDelivered(id uint, attempts, status int) *gorm.DB {
+	args := d.Called(id, attempts, status)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) RescheduleDelivery(id uint, attempts, status int, reason string, at time.Time) *gorm.DB {
+	args := d.Called(id, attempts, status, reason, at)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) MarkDeliveryFailed(id uint, attempts, status int, reason string) *gorm.DB {
+	args := d.Called(id, attempts, status, reason)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindDeliveries(subscriptionID uint, status string, after uint,
+	limit int) (ds []webhook.Delivery, tx *gorm.DB) {
+	args := d.Called(subscriptionID, status, after, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ds = args.Get(0).([]webhook.Delivery)
+	}
+	return
+}
+
+func (d *DataBaseMock) DeleteDeliveries(subscriptionID uint) *gorm.DB {
+	args := d.Called(subscriptionID)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) Purge(before time.Time, limit int) (ids []uint, tx *gorm.DB) {
+	args := d.Called(before, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ids = args.Get(0).([]uint)
+		tx.RowsAffected = int64(len(ids))
+	}
+	return
+}
+
+func (d *DataBaseMock) DeleteMemberships(customerIDs []uint) *gorm.DB {
+	args := d.Called(customerIDs)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) DeleteMessagesTo(customerIDs []uint) *gorm.DB {
+	args := d.Called(customerIDs)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) DeleteDeadLettersTo(customerIDs []uint) *gorm.DB {
+	args := d.Called(customerIDs)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) AcquireLease(name, holder string, ttl time.Duration) (l leader.Lease, tx *gorm.DB) {
+	args := d.Called(name, holder, ttl)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		l = args.Get(0).(leader.Lease)
+		if l.Holder == holder {
+			tx.RowsAffected = 1
+		}
+	}
+	return
+}
+
+func (d *DataBaseMock) ReleaseLease(name, holder string) *gorm.DB {
+	args := d.Called(name, holder)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) FirstLease(name string) (l leader.Lease, tx *gorm.DB) {
+	args := d.Called(name)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		l = args.Get(0).(leader.Lease)
+	}
+	return
+}
+
+func (d *DataBaseMock) SaveJobSettings(s *job.Settings) *gorm.DB {
+	args := d.Called(s)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindJobSettings() (ss []job.Settings, tx *gorm.DB) {
+	args := d.Called()
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		ss = args.Get(0).([]job.Settings)
+	}
+	return
+}
+
+func (d *DataBaseMock) CreateJobRun(r *job.Run) *gorm.DB {
+	args := d.Called(r)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindJobRuns(tag string, before uint, limit int) (rs []job.Run, tx *gorm.DB) {
+	args := d.Called(tag, before, limit)
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		rs = args.Get(0).([]job.Run)
+	}
+	return
+}
+
+func (d *DataBaseMock) LastJobRuns() (rs []job.Run, tx *gorm.DB) {
+	args := d.Called()
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		rs = args.Get(0).([]job.Run)
+	}
+	return
+}
+
+func (d *DataBaseMock) DeleteJobRuns(before time.Time) *gorm.DB {
+	args := d.Called(before)
+	return &gorm.DB{
+		RowsAffected: args.Get(0).(int64),
+		Error:        args.Error(1),
+	}
+}
+
+func (d *DataBaseMock) LockMigrations() *gorm.DB {
+	args := d.Called()
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) FindMigrations() (rs []migration.Record, tx *gorm.DB) {
+	args := d.Called()
+	tx = &gorm.DB{Error: args.Error(1)}
+	if tx.Error == nil {
+		rs = args.Get(0).([]migration.Record)
+	}
+	return
+}
+
+func (d *DataBaseMock) ExecMigration(sql string) error {
+	args := d.Called(sql)
+	return args.Error(0)
+}
+
+func (d *DataBaseMock) CreateMigration(r *migration.Record) *gorm.DB {
+	args := d.Called(r)
+	return &gorm.DB{Error: args.Error(0)}
+}
+
+func (d *DataBaseMock) DeleteMigration(version int) *gorm.DB {
+	args := d.Called(version)
+	return &gorm.DB{Error: args.Error(0)}
+}
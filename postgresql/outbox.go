@@ -0,0 +1,227 @@
+package postgresql
+
+import (
+	"api/customer"
+	"api/outbox"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type (
+	// OutboxDb is the part of Db dealing with the mail outbox
+	OutboxDb interface {
+		// Recipients retrieves the members of the mailing without a pending outbox.Message of the mailing nor a
+		// suppressed email address, ordered by id
+		Recipients(mailingID int64) ([]customer.Customer, *gorm.DB)
+		// SuppressedMembers retrieves the members of the mailing whose email address is suppressed, ordered by id
+		SuppressedMembers(mailingID int64) ([]customer.Customer, *gorm.DB)
+		// CreateMessages inserts the given messages, in batches
+		CreateMessages([]outbox.Message) *gorm.DB
+		// ClaimNext claims the oldest pending outbox.Message due for an attempt, skipping those being claimed by
+		// other transactions, by putting its next attempt off until the lease is over. It returns
+		// gorm.ErrRecordNotFound if there is none.
+		ClaimNext(lease time.Duration) (outbox.Message, *gorm.DB)
+		// MarkSent records that the message was sent
+		MarkSent(id uint) *gorm.DB
+		// Reschedule records that the message could not be sent after the given attempts, and why, leaving it
+		// pending until at
+		Reschedule(id uint, attempts int, reason string, at time.Time) *gorm.DB
+		// MarkFailed records that the message could not be sent after the given attempts, and why, giving up on it
+		MarkFailed(id uint, attempts int, reason string) *gorm.DB
+		// MarkBounced records that the message was rejected for good after the given attempts, and why
+		MarkBounced(id uint, attempts int, reason string) *gorm.DB
+		// CreateDeadLetter stores a copy of a message given up on
+		CreateDeadLetter(*outbox.DeadLetter) *gorm.DB
+		// FindDeadLetters retrieves up to limit dead letters of a mailing with an id greater than after
+		FindDeadLetters(mailingID int64, after uint, limit int) ([]outbox.DeadLetter, *gorm.DB)
+		// Requeue makes the messages of the given dead letters of a mailing, or of all of them if ids is nil, pending
+		// again with no failed attempts, traced by requestID from now on
+		Requeue(mailingID int64, ids []uint, requestID string) *gorm.DB
+		// DeleteDeadLetters removes the given dead letters of a mailing, or all of them if ids is nil
+		DeleteDeadLetters(mailingID int64, ids []uint) *gorm.DB
+		// CancelPending cancels the pending messages of a mailing
+		CancelPending(mailingID int64) *gorm.DB
+		// FindMessages retrieves the messages matching the query, ordered by id
+		FindMessages(MessageQuery) ([]outbox.Message, *gorm.DB)
+		// CountMessages counts the messages of a mailing by status
+		CountMessages(mailingID int64) (map[string]int64, *gorm.DB)
+		// DeleteMessagesTo removes the messages of every mailing to the given customers
+		DeleteMessagesTo(customerIDs []uint) *gorm.DB
+		// DeleteDeadLettersTo removes the dead letters of every mailing to the given customers
+		DeleteDeadLettersTo(customerIDs []uint) *gorm.DB
+	}
+
+	// MessageQuery filters the messages listed by FindMessages. Nil and empty fields do not filter.
+	MessageQuery struct {
+		MailingID  *int64
+		CustomerID *uint
+		Status     string
+		// After is the id of the last message of the previous page
+		After uint
+		Limit int
+	}
+)
+
+func (d *DBase) Recipients(mailingID int64) (cs []customer.Customer, tx *gorm.DB) {
+	tx = d.Tx.Joins("JOIN mailing_members mm ON mm.customer_id = customers.id").
+		Where("mm.mailing_id = ?", mailingID).
+		Where("NOT EXISTS (SELECT 1 FROM outbox_messages o "+
+			"WHERE o.mailing_id = mm.mailing_id AND o.customer_id = customers.id AND o.status = ?)",
+			outbox.StatusPending).
+		Where("NOT EXISTS (SELECT 1 FROM suppressions s WHERE s.email = LOWER(TRIM(customers.email)))").
+		Order("customers.id").
+		Find(&cs)
+	return
+}
+
+func (d *DBase) SuppressedMembers(mailingID int64) (cs []customer.Customer, tx *gorm.DB) {
+	tx = d.Tx.Joins("JOIN mailing_members mm ON mm.customer_id = customers.id").
+		Where("mm.mailing_id = ?", mailingID).
+		Where("EXISTS (SELECT 1 FROM suppressions s WHERE s.email = LOWER(TRIM(customers.email)))").
+		Order("customers.id").
+		Find(&cs)
+	return
+}
+
+func (d *DBase) CreateMessages(ms []outbox.Message) *gorm.DB {
+	return d.Tx.CreateInBatches(ms, 500)
+}
+
+func (d *DBase) ClaimNext(lease time.Duration) (m outbox.Message, tx *gorm.DB) {
+	now := d.Tx.NowFunc()
+	next := d.Tx.Model(&outbox.Message{}).
+		Select("id").
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", outbox.StatusPending).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("id").
+		Limit(1)
+	tx = d.Tx.Model(&m).
+		Clauses(clause.Returning{}).
+		Where("id = (?)", next).
+		Update("next_attempt_at", now.Add(lease))
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		_ = tx.AddError(gorm.ErrRecordNotFound)
+	}
+	return
+}
+
+func (d *DBase) MarkSent(id uint) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          outbox.StatusSent,
+			"sent_at":         d.Tx.NowFunc(),
+			"error":           "",
+			"next_attempt_at": nil,
+		})
+}
+
+func (d *DBase) Reschedule(id uint, attempts int, reason string, at time.Time) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"attempts": attempts, "error": reason, "next_attempt_at": at})
+}
+
+func (d *DBase) MarkFailed(id uint, attempts int, reason string) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          outbox.StatusFailed,
+			"attempts":        attempts,
+			"error":           reason,
+			"next_attempt_at": nil,
+		})
+}
+
+func (d *DBase) MarkBounced(id uint, attempts int, reason string) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          outbox.StatusBounced,
+			"attempts":        attempts,
+			"error":           reason,
+			"next_attempt_at": nil,
+		})
+}
+
+func (d *DBase) CreateDeadLetter(l *outbox.DeadLetter) *gorm.DB {
+	return d.Tx.Create(l)
+}
+
+func (d *DBase) FindDeadLetters(mailingID int64, after uint, limit int) (ls []outbox.DeadLetter, tx *gorm.DB) {
+	tx = d.Tx.Where("mailing_id = ? AND id > ?", mailingID, after).Order("id").Limit(limit).Find(&ls)
+	return
+}
+
+func (d *DBase) Requeue(mailingID int64, ids []uint, requestID string) *gorm.DB {
+	letters := d.Tx.Model(&outbox.DeadLetter{}).Select("message_id").Where("mailing_id = ?", mailingID)
+	if ids != nil {
+		letters = letters.Where("id IN ?", ids)
+	}
+	return d.Tx.Model(&outbox.Message{}).
+		Where("id IN (?) AND status = ?", letters, outbox.StatusFailed).
+		Updates(map[string]interface{}{
+			"status":          outbox.StatusPending,
+			"attempts":        0,
+			"error":           "",
+			"next_attempt_at": nil,
+			"request_id":      requestID,
+		})
+}
+
+func (d *DBase) DeleteDeadLetters(mailingID int64, ids []uint) *gorm.DB {
+	tx := d.Tx.Where("mailing_id = ?", mailingID)
+	if ids != nil {
+		tx = tx.Where("id IN ?", ids)
+	}
+	return tx.Delete(&outbox.DeadLetter{})
+}
+
+func (d *DBase) CancelPending(mailingID int64) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("mailing_id = ? AND status = ?", mailingID, outbox.StatusPending).
+		Update("status", outbox.StatusCancelled)
+}
+
+func (d *DBase) FindMessages(q MessageQuery) (ms []outbox.Message, tx *gorm.DB) {
+	tx = d.Tx.Where("id > ?", q.After)
+	if q.MailingID != nil {
+		tx = tx.Where("mailing_id = ?", *q.MailingID)
+	}
+	if q.CustomerID != nil {
+		tx = tx.Where("customer_id = ?", *q.CustomerID)
+	}
+	if q.Status != "" {
+		tx = tx.Where("status = ?", q.Status)
+	}
+	tx = tx.Order("id").Limit(q.Limit).Find(&ms)
+	return
+}
+
+func (d *DBase) CountMessages(mailingID int64) (map[string]int64, *gorm.DB) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	tx := d.Tx.Model(&outbox.Message{}).
+		Select("status, COUNT(*) AS count").
+		Where("mailing_id = ?", mailingID).
+		Group("status").
+		Scan(&rows)
+	counts := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	return counts, tx
+}
+
+func (d *DBase) DeleteMessagesTo(customerIDs []uint) *gorm.DB {
+	return d.Tx.Where("customer_id IN ?", customerIDs).Delete(&outbox.Message{})
+}
+
+func (d *DBase) DeleteDeadLettersTo(customerIDs []uint) *gorm.DB {
+	return d.Tx.Where("customer_id IN ?", customerIDs).Delete(&outbox.DeadLetter{})
+}
@@ -2,11 +2,14 @@ package postgresql
 
 import (
 	"api/customer"
+	"context"
 	"fmt"
-	"strconv"
+	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type (
@@ -15,41 +18,95 @@ type (
 	Db interface {
 		// Create handles calls to &gorm.DB.Create()
 		Create(*customer.Customer) *gorm.DB
-		// Migrate handles calls to &gorm.DB.Automigrate()
-		Migrate(*customer.Customer) error
-		// Delete does soft delete
+		// Close closes the pool of connections to the database
+		Close() error
+		// Delete does soft delete, filling the customer with the row deleted
 		Delete(*customer.Customer, int64) *gorm.DB
 		// First handles calls to &gorm.DB.First()
 		First(int64) (customer.Customer, *gorm.DB)
-		// Find finds customers
-		Find() ([]customer.Customer, *gorm.DB)
-		// DeleteOld removes old entries from database (soft delete)
-		DeleteOld(int) *gorm.DB
-		// DeleteByMailingID removes entries from database with the given mailingID (soft delete)
-		DeleteByMailingID(int64) *gorm.DB
+		// Find finds customers matching the given FindQuery
+		Find(FindQuery) ([]customer.Customer, *gorm.DB)
+		// DeleteExpired removes the customers whose retention is over (soft delete), and returns them. period is the
+		// global retention period, zero keeping the customers it applies to forever.
+		DeleteExpired(period time.Duration) ([]customer.Customer, *gorm.DB)
+		// Update overwrites a customer provided its updated_at column still holds the given time
+		Update(*customer.Customer, time.Time) *gorm.DB
+		// Transaction runs the given function within a transaction, which is rolled back if it returns an error
+		Transaction(func(Db) error) error
+		// WithContext returns a Db whose statements are cancelled once ctx is done
+		WithContext(ctx context.Context) Db
+		// Restore undoes the soft delete of a customer
+		Restore(int64) *gorm.DB
+		// Purge deletes for good up to limit customers soft deleted before the given time, but those under legal
+		// hold, and returns their ids
+		Purge(before time.Time, limit int) ([]uint, *gorm.DB)
+
+		OutboxDb
+		MailingDb
+		SuppressionDb
+		WebhookDb
+		LeaseDb
+		JobDb
+		MigrationDb
 	}
 	DBase struct {
 		Tx *gorm.DB
 	}
+
+	// SortKey orders results by Column, in descending order if Desc is set.
+	SortKey struct {
+		Column string
+		Desc   bool
+	}
+
+	// DeletedFilter tells whether soft deleted customers are found
+	DeletedFilter int
+
+	// FindQuery describes a filtered, keyset paginated lookup of customers.
+	// Zero values disable the corresponding filter.
+	FindQuery struct {
+		Email     string
+		Title     string
+		MailingID *int64
+		// MemberOf keeps the members of the mailing with this id
+		MemberOf      *int64
+		CreatedAfter  *time.Time
+		CreatedBefore *time.Time
+		Deleted       DeletedFilter
+		// Sort must end with a unique column so that the keyset is total.
+		Sort []SortKey
+		// After holds the Sort column values of the last row of the previous page.
+		After []interface{}
+		Limit int
+	}
 )
 
-var (
-	DB *DBase
+const (
+	ExcludeDeleted DeletedFilter = iota
+	IncludeDeleted
+	OnlyDeleted
 )
 
-func init() {
-	// Establish connection to local PostgreSQL
-	var err error
-	dsn := "host=localhost user=postgres password=example dbname=customer port=5432 sslmode=disable TimeZone=Europe/Warsaw"
-	pg, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Open connects to the database of the given data source name
+func Open(dsn string) (*DBase, error) {
+	pg, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		// PostgreSQL keeps microseconds: timestamps in memory must match the stored ones to be used as ETags
+		NowFunc: func() time.Time {
+			return time.Now().Truncate(time.Microsecond)
+		},
+	})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	DB = &DBase{Tx: pg}
+	return &DBase{Tx: pg}, nil
 }
 
-func (d *DBase) Migrate(customer *customer.Customer) error {
-	return d.Tx.AutoMigrate(customer)
+func (d *DBase) Close() error {
+	pool, err := d.Tx.DB()
+	if err != nil {
+		return err
+	}
+	return pool.Close()
 }
 
 func (d *DBase) Create(customer *customer.Customer) *gorm.DB {
@@ -57,23 +114,146 @@ func (d *DBase) Create(customer *customer.Customer) *gorm.DB {
 }
 
 func (d *DBase) Delete(c *customer.Customer, id int64) *gorm.DB {
-	return d.Tx.Delete(c, id)
+	return d.Tx.Clauses(clause.Returning{}).Delete(c, id)
 }
 
 func (d *DBase) First(id int64) (c customer.Customer, tx *gorm.DB) {
-	tx = d.Tx.First(c, id)
+	tx = d.Tx.First(&c, id)
 	return
 }
 
-func (d *DBase) Find() (cs []customer.Customer, tx *gorm.DB) {
-	tx = d.Tx.Find(&cs)
+func (d *DBase) Update(c *customer.Customer, updatedAt time.Time) *gorm.DB {
+	return d.Tx.Model(c).
+		Where("updated_at = ?", updatedAt).
+		Select("email", "title", "content", "mailing_id", "expires_at", "legal_hold").
+		Updates(c)
+}
+
+func (d *DBase) Restore(id int64) *gorm.DB {
+	return d.Tx.Unscoped().
+		Model(&customer.Customer{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+}
+
+func (d *DBase) Transaction(fn func(Db) error) error {
+	return d.Tx.Transaction(func(tx *gorm.DB) error {
+		return fn(&DBase{Tx: tx})
+	})
+}
+
+func (d *DBase) WithContext(ctx context.Context) Db {
+	return &DBase{Tx: d.Tx.WithContext(ctx)}
+}
+
+func (d *DBase) Find(q FindQuery) (cs []customer.Customer, tx *gorm.DB) {
+	tx = d.Tx.Model(&customer.Customer{})
+	switch q.Deleted {
+	case IncludeDeleted:
+		tx = tx.Unscoped()
+	case OnlyDeleted:
+		tx = tx.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if q.Email != "" {
+		tx = tx.Where("email = ?", q.Email)
+	}
+	if q.Title != "" {
+		tx = tx.Where("title = ?", q.Title)
+	}
+	if q.MailingID != nil {
+		tx = tx.Where("mailing_id = ?", *q.MailingID)
+	}
+	if q.MemberOf != nil {
+		tx = tx.Where("id IN (SELECT customer_id FROM mailing_members WHERE mailing_id = ?)", *q.MemberOf)
+	}
+	if q.CreatedAfter != nil {
+		tx = tx.Where("created_at >= ?", *q.CreatedAfter)
+	}
+	if q.CreatedBefore != nil {
+		tx = tx.Where("created_at < ?", *q.CreatedBefore)
+	}
+	if len(q.After) > 0 && len(q.After) == len(q.Sort) {
+		cond, args := keyset(q.Sort, q.After)
+		tx = tx.Where(cond, args...)
+	}
+	for _, key := range q.Sort {
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: key.Column}, Desc: key.Desc})
+	}
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+	tx = tx.Find(&cs)
 	return
 }
 
-func (d *DBase) DeleteOld(seconds int) *gorm.DB {
-	return d.Tx.Where(fmt.Sprintf("CreatedAt < NOW() - %d", seconds)).Delete(&customer.Customer{})
+// keyset builds the condition selecting the rows strictly after values in the given sort order:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keyset(sort []SortKey, values []interface{}) (string, []interface{}) {
+	var (
+		or   []string
+		args []interface{}
+	)
+	for i, key := range sort {
+		and := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, fmt.Sprintf("%s = ?", sort[j].Column))
+			args = append(args, values[j])
+		}
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		and = append(and, fmt.Sprintf("%s %s ?", key.Column, op))
+		args = append(args, values[i])
+		or = append(or, "("+strings.Join(and, " AND ")+")")
+	}
+	return strings.Join(or, " OR "), args
+}
+
+// Conditions under which a customer expires, see retention.Policy
+const (
+	// expiredAt customers have an expiry time of their own, which is past
+	expiredAt = "expires_at <= NOW()"
+	// memberMailings are the mailings a customer is a member of
+	memberMailings = "SELECT 1 FROM mailing_members mm JOIN mailings m ON m.id = mm.mailing_id " +
+		"WHERE mm.customer_id = customers.id"
+	// expiredByMailing customers are older than the retention of one of their mailings, none of the others keeping them
+	expiredByMailing = "expires_at IS NULL AND EXISTS (" + memberMailings + " AND m.retention_seconds > 0 " +
+		"AND customers.created_at < NOW() - make_interval(secs => m.retention_seconds)) " +
+		"AND NOT EXISTS (" + memberMailings + " AND (m.retention_seconds = 0 " +
+		"OR customers.created_at >= NOW() - make_interval(secs => m.retention_seconds)))"
+	// expiredByDefault customers are older than the global retention period, none of their mailings overriding it
+	expiredByDefault = "expires_at IS NULL AND created_at < NOW() - make_interval(secs => ?) " +
+		"AND NOT EXISTS (" + memberMailings + " AND m.retention_seconds IS NOT NULL)"
+)
+
+func (d *DBase) DeleteExpired(period time.Duration) (cs []customer.Customer, tx *gorm.DB) {
+	or, args := []string{expiredAt, expiredByMailing}, []interface{}(nil)
+	if period > 0 {
+		or = append(or, expiredByDefault)
+		args = append(args, period.Seconds())
+	}
+	tx = d.Tx.Clauses(clause.Returning{}).
+		Where("NOT legal_hold").
+		Where("(("+strings.Join(or, ") OR (")+"))", args...).
+		Delete(&cs)
+	return
 }
 
-func (d *DBase) DeleteByMailingID(mailingID int64) *gorm.DB {
-	return d.Tx.Where("mailingID = ?", strconv.FormatInt(mailingID, 10)).Delete(customer.Customer{})
+func (d *DBase) Purge(before time.Time, limit int) ([]uint, *gorm.DB) {
+	var cs []customer.Customer
+	batch := d.Tx.Unscoped().Model(&customer.Customer{}).
+		Select("id").
+		Where("deleted_at < ? AND NOT legal_hold", before).
+		Order("id").
+		Limit(limit)
+	tx := d.Tx.Unscoped().
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("id IN (?)", batch).
+		Delete(&cs)
+	ids := make([]uint, 0, len(cs))
+	for _, c := range cs {
+		ids = append(ids, c.ID)
+	}
+	return ids, tx
 }
@@ -0,0 +1,54 @@
+package postgresql
+
+import (
+	"api/customer"
+	"api/outbox"
+	"api/suppression"
+
+	"gorm.io/gorm"
+)
+
+// SuppressionDb is the part of Db dealing with suppressed email addresses
+type SuppressionDb interface {
+	// CreateSuppression inserts a suppression
+	CreateSuppression(*suppression.Suppression) *gorm.DB
+	// FirstSuppression retrieves the suppression of a normalized email address
+	FirstSuppression(email string) (suppression.Suppression, *gorm.DB)
+	// FindSuppressions retrieves up to limit suppressions with an email address greater than after, in order
+	FindSuppressions(after string, limit int) ([]suppression.Suppression, *gorm.DB)
+	// DeleteSuppression removes the suppression of a normalized email address
+	DeleteSuppression(email string) *gorm.DB
+	// SuppressPending marks as suppressed the pending messages to a normalized email address
+	SuppressPending(email string) *gorm.DB
+	// FirstWithDeleted retrieves a customer by primary key, even if it was soft deleted
+	FirstWithDeleted(int64) (customer.Customer, *gorm.DB)
+}
+
+func (d *DBase) CreateSuppression(s *suppression.Suppression) *gorm.DB {
+	return d.Tx.Create(s)
+}
+
+func (d *DBase) FirstSuppression(email string) (s suppression.Suppression, tx *gorm.DB) {
+	tx = d.Tx.Where("email = ?", email).First(&s)
+	return
+}
+
+func (d *DBase) FindSuppressions(after string, limit int) (ss []suppression.Suppression, tx *gorm.DB) {
+	tx = d.Tx.Where("email > ?", after).Order("email").Limit(limit).Find(&ss)
+	return
+}
+
+func (d *DBase) DeleteSuppression(email string) *gorm.DB {
+	return d.Tx.Where("email = ?", email).Delete(&suppression.Suppression{})
+}
+
+func (d *DBase) SuppressPending(email string) *gorm.DB {
+	return d.Tx.Model(&outbox.Message{}).
+		Where("LOWER(TRIM(recipient)) = ? AND status = ?", email, outbox.StatusPending).
+		Update("status", outbox.StatusSuppressed)
+}
+
+func (d *DBase) FirstWithDeleted(id int64) (c customer.Customer, tx *gorm.DB) {
+	tx = d.Tx.Unscoped().First(&c, id)
+	return
+}
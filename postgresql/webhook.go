@@ -0,0 +1,147 @@
+package postgresql
+
+import (
+	"api/webhook"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WebhookDb is the part of Db dealing with webhook subscriptions and their deliveries
+type WebhookDb interface {
+	// CreateSubscription inserts a webhook subscription
+	CreateSubscription(*webhook.Subscription) *gorm.DB
+	// FirstSubscription retrieves a webhook subscription by primary key
+	FirstSubscription(id uint) (webhook.Subscription, *gorm.DB)
+	// FindSubscriptions retrieves up to limit webhook subscriptions with an id greater than after
+	FindSubscriptions(after uint, limit int) ([]webhook.Subscription, *gorm.DB)
+	// UpdateSubscription overwrites the URL, events and secret of a webhook subscription
+	UpdateSubscription(*webhook.Subscription) *gorm.DB
+	// DeleteSubscription removes a webhook subscription
+	DeleteSubscription(id uint) *gorm.DB
+	// Subscribers retrieves the webhook subscriptions to the events of the given type
+	Subscribers(eventType string) ([]webhook.Subscription, *gorm.DB)
+	// CreateDeliveries inserts the given deliveries, in batches
+	CreateDeliveries([]webhook.Delivery) *gorm.DB
+	// ClaimDelivery claims the oldest pending webhook.Delivery due for an attempt, skipping those being claimed by
+	// other transactions, by putting its next attempt off until the lease is over. It returns
+	// gorm.ErrRecordNotFound if there is none.
+	ClaimDelivery(lease time.Duration) (webhook.Delivery, *gorm.DB)
+	// MarkDelivered records that the delivery was answered with the given 2xx status after the given attempts
+	MarkDelivered(id uint, attempts, status int) *gorm.DB
+	// RescheduleDelivery records that the delivery failed after the given attempts, the last one answered with
+	// status, zero if it was not, and why, leaving it pending until at
+	RescheduleDelivery(id uint, attempts, status int, reason string, at time.Time) *gorm.DB
+	// MarkDeliveryFailed records that the delivery failed after the given attempts, the last one answered with
+	// status, zero if it was not, and why, giving up on it
+	MarkDeliveryFailed(id uint, attempts, status int, reason string) *gorm.DB
+	// FindDeliveries retrieves up to limit deliveries to a subscription with an id greater than after, optionally
+	// in the given status
+	FindDeliveries(subscriptionID uint, status string, after uint, limit int) ([]webhook.Delivery, *gorm.DB)
+	// DeleteDeliveries removes the deliveries to a subscription
+	DeleteDeliveries(subscriptionID uint) *gorm.DB
+}
+
+func (d *DBase) CreateSubscription(s *webhook.Subscription) *gorm.DB {
+	return d.Tx.Create(s)
+}
+
+func (d *DBase) FirstSubscription(id uint) (s webhook.Subscription, tx *gorm.DB) {
+	tx = d.Tx.First(&s, id)
+	return
+}
+
+func (d *DBase) FindSubscriptions(after uint, limit int) (ss []webhook.Subscription, tx *gorm.DB) {
+	tx = d.Tx.Where("id > ?", after).Order("id").Limit(limit).Find(&ss)
+	return
+}
+
+func (d *DBase) UpdateSubscription(s *webhook.Subscription) *gorm.DB {
+	return d.Tx.Model(s).Select("url", "events", "secret").Updates(s)
+}
+
+func (d *DBase) DeleteSubscription(id uint) *gorm.DB {
+	return d.Tx.Delete(&webhook.Subscription{}, id)
+}
+
+func (d *DBase) Subscribers(eventType string) (ss []webhook.Subscription, tx *gorm.DB) {
+	// events are stored as a JSON array
+	events, _ := json.Marshal([]string{eventType})
+	tx = d.Tx.Where("events::jsonb @> ?::jsonb", string(events)).Order("id").Find(&ss)
+	return
+}
+
+func (d *DBase) CreateDeliveries(ds []webhook.Delivery) *gorm.DB {
+	return d.Tx.CreateInBatches(ds, 500)
+}
+
+func (d *DBase) ClaimDelivery(lease time.Duration) (dl webhook.Delivery, tx *gorm.DB) {
+	now := d.Tx.NowFunc()
+	next := d.Tx.Model(&webhook.Delivery{}).
+		Select("id").
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", webhook.StatusPending).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("id").
+		Limit(1)
+	tx = d.Tx.Model(&dl).
+		Clauses(clause.Returning{}).
+		Where("id = (?)", next).
+		Update("next_attempt_at", now.Add(lease))
+	if tx.Error == nil && tx.RowsAffected == 0 {
+		_ = tx.AddError(gorm.ErrRecordNotFound)
+	}
+	return
+}
+
+func (d *DBase) MarkDelivered(id uint, attempts, status int) *gorm.DB {
+	return d.Tx.Model(&webhook.Delivery{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          webhook.StatusDelivered,
+			"attempts":        attempts,
+			"response_status": status,
+			"error":           "",
+			"delivered_at":    d.Tx.NowFunc(),
+			"next_attempt_at": nil,
+		})
+}
+
+func (d *DBase) RescheduleDelivery(id uint, attempts, status int, reason string, at time.Time) *gorm.DB {
+	return d.Tx.Model(&webhook.Delivery{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"response_status": status,
+			"error":           reason,
+			"next_attempt_at": at,
+		})
+}
+
+func (d *DBase) MarkDeliveryFailed(id uint, attempts, status int, reason string) *gorm.DB {
+	return d.Tx.Model(&webhook.Delivery{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          webhook.StatusFailed,
+			"attempts":        attempts,
+			"response_status": status,
+			"error":           reason,
+			"next_attempt_at": nil,
+		})
+}
+
+func (d *DBase) FindDeliveries(subscriptionID uint, status string, after uint,
+	limit int) (ds []webhook.Delivery, tx *gorm.DB) {
+	tx = d.Tx.Where("subscription_id = ? AND id > ?", subscriptionID, after)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	tx = tx.Order("id").Limit(limit).Find(&ds)
+	return
+}
+
+func (d *DBase) DeleteDeliveries(subscriptionID uint) *gorm.DB {
+	return d.Tx.Where("subscription_id = ?", subscriptionID).Delete(&webhook.Delivery{})
+}
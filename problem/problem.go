@@ -0,0 +1,215 @@
+package problem
+
+import (
+	"api/dao"
+	"api/tracing"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// ContentType is the media type of problem details (RFC 7807)
+const ContentType = "application/problem+json"
+
+// Problem type URIs, relative to the API root
+const (
+	TypeBadRequest           = "/problems/bad-request"
+	TypeValidation           = "/problems/validation"
+	TypeNotFound             = "/problems/not-found"
+	TypeConflict             = "/problems/conflict"
+	TypeInvalidState         = "/problems/invalid-state"
+	TypeTemplate             = "/problems/template"
+	TypeSuppressed           = "/problems/suppressed"
+	TypePreconditionFailed   = "/problems/precondition-failed"
+	TypePreconditionRequired = "/problems/precondition-required"
+	TypeUnsupportedMedia     = "/problems/unsupported-media-type"
+	TypeSerialization        = "/problems/serialization-failure"
+	TypeTimeout              = "/problems/timeout"
+	TypeUnavailable          = "/problems/unavailable"
+	TypeDatabase             = "/problems/database"
+	TypeInternal             = "/problems/internal"
+)
+
+// databaseDetails maps every kind of dao.Error to its problem type and status
+var databaseDetails = map[error]Details{
+	dao.ErrNotFound:      {Type: TypeNotFound, Status: http.StatusNotFound},
+	dao.ErrConflict:      {Type: TypeConflict, Status: http.StatusConflict},
+	dao.ErrSerialization: {Type: TypeSerialization, Status: http.StatusConflict},
+	dao.ErrTimeout:       {Type: TypeTimeout, Status: http.StatusGatewayTimeout},
+	dao.ErrUnavailable:   {Type: TypeUnavailable, Status: http.StatusServiceUnavailable},
+	dao.ErrPg:            {Type: TypeDatabase, Status: http.StatusInternalServerError},
+}
+
+type (
+	// Details is an RFC 7807 problem details object
+	Details struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+		// Errors maps invalid fields to the reason they are invalid
+		Errors map[string]string `json:"errors,omitempty"`
+	}
+
+	// statusError forces the HTTP status an error is reported with
+	statusError struct {
+		status int
+		err    error
+	}
+)
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// Abort stops the handler chain and records err, to be reported by Middleware with the status derived from it.
+func Abort(ctx *gin.Context, err error) {
+	_ = ctx.Error(err)
+	ctx.Abort()
+}
+
+// AbortWithStatus stops the handler chain and records err, to be reported by Middleware with the given status.
+func AbortWithStatus(ctx *gin.Context, status int, err error) {
+	Abort(ctx, &statusError{status: status, err: err})
+}
+
+// Middleware writes the last error recorded by the handlers as an application/problem+json response,
+// unless a response was already written.
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		last := ctx.Errors.Last()
+		if last == nil || ctx.Writer.Written() {
+			return
+		}
+
+		details := New(last.Err)
+		details.Instance = ctx.Request.Header.Get(tracing.XRequestID)
+
+		ctx.Header("Content-Type", ContentType)
+		ctx.IndentedJSON(details.Status, details)
+	}
+}
+
+// New maps err into problem details.
+// Database errors are described by their kind only, so that their underlying cause does not leak.
+func New(err error) Details {
+	var (
+		status *statusError
+		d      Details
+	)
+	switch {
+	case errors.As(err, &status):
+		d = Details{Type: typeOf(status.status), Status: status.status}
+	case errors.Is(err, dao.ErrStale):
+		d = Details{Type: TypePreconditionFailed, Status: http.StatusPreconditionFailed}
+	case errors.Is(err, dao.ErrInvalidQuery):
+		d = Details{Type: TypeBadRequest, Status: http.StatusBadRequest}
+	case errors.Is(err, dao.ErrInvalidState):
+		d = Details{Type: TypeInvalidState, Status: http.StatusConflict}
+	case errors.Is(err, dao.ErrTemplate):
+		d = Details{Type: TypeTemplate, Status: http.StatusUnprocessableEntity}
+	case errors.Is(err, dao.ErrSuppressed):
+		d = Details{Type: TypeSuppressed, Status: http.StatusUnprocessableEntity}
+	case databaseKind(err) != nil:
+		d = databaseDetails[databaseKind(err)]
+	default:
+		d = Details{Type: TypeInternal, Status: http.StatusInternalServerError}
+	}
+	d.Detail = Detail(err)
+
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		d.Errors = FieldErrors(errs)
+		d.Detail = "some fields are not valid"
+		if status == nil {
+			d.Status = http.StatusBadRequest
+		}
+		d.Type = TypeValidation
+	}
+
+	d.Title = http.StatusText(d.Status)
+	return d
+}
+
+// Detail describes err in a way that is safe to show to API clients: database errors are told by their kind and
+// violated constraint only, and server errors not at all.
+func Detail(err error) string {
+	var (
+		status *statusError
+		dbErr  *dao.Error
+	)
+	switch {
+	case errors.As(err, &status):
+		if status.status >= http.StatusInternalServerError {
+			return ""
+		}
+		return err.Error()
+	case errors.Is(err, dao.ErrStale), errors.Is(err, dao.ErrInvalidQuery), errors.Is(err, dao.ErrInvalidState),
+		errors.Is(err, dao.ErrTemplate), errors.Is(err, dao.ErrSuppressed):
+		return err.Error()
+	case errors.As(err, &dbErr) && dbErr.Constraint != "":
+		return fmt.Sprintf("%s: violates %s", dbErr.Kind.Error(), dbErr.Constraint)
+	case databaseKind(err) != nil:
+		return databaseKind(err).Error()
+	}
+	return ""
+}
+
+// databaseKind returns the kind of dao error err is, or nil if it is not a database error
+func databaseKind(err error) error {
+	for kind := range databaseDetails {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
+
+// FieldErrors flattens ozzo validation errors into a field to message map
+func FieldErrors(err error) map[string]string {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return map[string]string{"": err.Error()}
+	}
+	fields := make(map[string]string, len(errs))
+	for field, e := range errs {
+		fields[field] = e.Error()
+	}
+	return fields
+}
+
+func typeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return TypeBadRequest
+	case http.StatusNotFound:
+		return TypeNotFound
+	case http.StatusConflict:
+		return TypeConflict
+	case http.StatusPreconditionFailed:
+		return TypePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return TypePreconditionRequired
+	case http.StatusUnsupportedMediaType:
+		return TypeUnsupportedMedia
+	case http.StatusServiceUnavailable:
+		return TypeUnavailable
+	case http.StatusGatewayTimeout:
+		return TypeTimeout
+	}
+	if status >= http.StatusInternalServerError {
+		return TypeInternal
+	}
+	// no further semantics than the status code itself
+	return "about:blank"
+}
@@ -0,0 +1,137 @@
+package render
+
+import (
+	"api/customer"
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+type (
+	// Templates are the parsed templates of a mailing
+	Templates struct {
+		subject *texttemplate.Template
+		text    *texttemplate.Template
+		html    *htmltemplate.Template
+	}
+
+	// Message is a message rendered for one recipient
+	Message struct {
+		Subject string
+		Text    string
+		// HTML is empty unless the mailing has an HTML body
+		HTML string
+	}
+)
+
+// funcs is the whole set of functions templates may call besides the builtin ones. It overrides call, so that
+// templates cannot call functions held in their data.
+var funcs = map[string]interface{}{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"default": func(def string, value interface{}) string {
+		if s := fmt.Sprint(value); value != nil && s != "" {
+			return s
+		}
+		return def
+	},
+	"call": func(...interface{}) (string, error) {
+		return "", errors.New("call is not allowed")
+	},
+}
+
+var (
+	// Text is a validation rule for text/template sources
+	Text = validation.By(func(value interface{}) error {
+		source, _ := value.(string)
+		t, err := parseText("", source)
+		if err != nil {
+			return err
+		}
+		return t.Execute(&bytes.Buffer{}, Data(&customer.Customer{}))
+	})
+
+	// HTML is a validation rule for html/template sources
+	HTML = validation.By(func(value interface{}) error {
+		source, _ := value.(string)
+		t, err := parseHTML(source)
+		if err != nil {
+			return err
+		}
+		return t.Execute(&bytes.Buffer{}, Data(&customer.Customer{}))
+	})
+)
+
+// Data is what templates are rendered with. It holds the recipient fields only, by their JSON names,
+// so that templates can neither reach other data nor call methods.
+func Data(c *customer.Customer) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         c.ID,
+		"email":      c.Email,
+		"title":      c.Title,
+		"content":    c.Content,
+		"mailing_id": c.MailingID,
+	}
+}
+
+// Parse parses the subject and body templates of a mailing. The HTML body is optional.
+func Parse(subject, text, html string) (*Templates, error) {
+	var (
+		t   Templates
+		err error
+	)
+	if t.subject, err = parseText("subject", subject); err != nil {
+		return nil, err
+	}
+	if t.text, err = parseText("body", text); err != nil {
+		return nil, err
+	}
+	if html != "" {
+		if t.html, err = parseHTML(html); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// Render renders the templates for a recipient
+func (t *Templates) Render(c *customer.Customer) (Message, error) {
+	var (
+		m    Message
+		buf  bytes.Buffer
+		data = Data(c)
+	)
+	if err := t.subject.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Subject = buf.String()
+
+	buf.Reset()
+	if err := t.text.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Text = buf.String()
+
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.Execute(&buf, data); err != nil {
+			return m, err
+		}
+		m.HTML = buf.String()
+	}
+	return m, nil
+}
+
+func parseText(name, source string) (*texttemplate.Template, error) {
+	return texttemplate.New(name).Funcs(funcs).Option("missingkey=error").Parse(source)
+}
+
+func parseHTML(source string) (*htmltemplate.Template, error) {
+	return htmltemplate.New("html_body").Funcs(funcs).Option("missingkey=error").Parse(source)
+}
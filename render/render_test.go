@@ -0,0 +1,103 @@
+package render
+
+import (
+	"api/customer"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	recipient := &customer.Customer{Email: "ana@example.com", Title: "Ms", Content: "<b>vip</b>"}
+
+	tests := map[string]struct {
+		subject, text, html string
+		expected            Message
+		withParseError      bool
+		withRenderError     string
+	}{
+		"merge fields": {
+			subject:  "Hello {{.title}}",
+			text:     "Dear {{.title}}, your address is {{.email}}",
+			expected: Message{Subject: "Hello Ms", Text: "Dear Ms, your address is ana@example.com"},
+		},
+		"functions": {
+			subject:  "{{.title | upper}} {{.content | default \"none\" | lower}}",
+			text:     "{{.mailing_id | default \"no mailing\"}}",
+			expected: Message{Subject: "MS <b>vip</b>", Text: "0"},
+		},
+		"html is escaped": {
+			subject:  "Hi",
+			text:     "{{.content}}",
+			html:     "<p>{{.content}}</p>",
+			expected: Message{Subject: "Hi", Text: "<b>vip</b>", HTML: "<p>&lt;b&gt;vip&lt;/b&gt;</p>"},
+		},
+		"syntax error": {
+			subject:        "Hello {{.title",
+			text:           "hi",
+			withParseError: true,
+		},
+		"unknown function": {
+			subject:        "{{exec \"ls\"}}",
+			text:           "hi",
+			withParseError: true,
+		},
+		"missing key": {
+			subject:         "Hello {{.name}}",
+			text:            "hi",
+			withRenderError: `map has no entry for key "name"`,
+		},
+		"call is not allowed": {
+			subject:         "{{call .title}}",
+			text:            "hi",
+			withRenderError: "call is not allowed",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			templates, err := Parse(test.subject, test.text, test.html)
+			if test.withParseError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			m, err := templates.Render(recipient)
+			if test.withRenderError != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), test.withRenderError)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, m)
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := map[string]struct {
+		rule      validation.Rule
+		source    string
+		withError bool
+	}{
+		"valid text":        {rule: Text, source: "Hi {{.email}}"},
+		"text syntax error": {rule: Text, source: "Hi {{.email", withError: true},
+		"text unknown key":  {rule: Text, source: "Hi {{.name}}", withError: true},
+		"valid html":        {rule: HTML, source: "<p>{{.email}}</p>"},
+		"html syntax error": {rule: HTML, source: "<p>{{if .email}}</p>", withError: true},
+		"empty html":        {rule: HTML, source: ""},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validation.Validate(test.source, test.rule)
+			if test.withError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
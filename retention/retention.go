@@ -0,0 +1,78 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Never is how a period that never ends is written
+const Never = "never"
+
+// Policy tells how long customers are kept before they expire, that is, before they are deleted.
+//
+// A customer expires at its own expires_at if it has one. Otherwise it expires once it is older than the retention of
+// its mailing, if the mailing overrides it, or than Period. Customers under legal hold never expire.
+type Policy struct {
+	// Period is how long customers are kept after they are created, unless told otherwise. Zero keeps them forever
+	Period time.Duration
+	// Interval is how often expired customers are deleted
+	Interval time.Duration
+}
+
+// Purge tells when soft deleted customers are deleted for good
+type Purge struct {
+	// GracePeriod is how long customers stay soft deleted, and may be restored, before they are purged
+	GracePeriod time.Duration
+	// Interval is how often customers are purged
+	Interval time.Duration
+	// BatchSize is the maximum number of customers purged per transaction, so that locks are held briefly
+	BatchSize int
+}
+
+// DefaultPolicy keeps customers for 5 minutes, looking for expired ones every second
+var DefaultPolicy = Policy{Period: 5 * time.Minute, Interval: time.Second}
+
+// DefaultPurge purges the customers soft deleted 30 days ago, every hour, 500 at a time
+var DefaultPurge = Purge{GracePeriod: 30 * 24 * time.Hour, Interval: time.Hour, BatchSize: 500}
+
+// Validate tells whether the policy may be applied
+func (p Policy) Validate() error {
+	if p.Period < 0 {
+		return fmt.Errorf("retention period must not be negative")
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("retention interval must be positive")
+	}
+	return nil
+}
+
+// Validate tells whether the purge may be run
+func (p Purge) Validate() error {
+	if p.GracePeriod < 0 {
+		return fmt.Errorf("purge grace period must not be negative")
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("purge interval must be positive")
+	}
+	if p.BatchSize <= 0 {
+		return fmt.Errorf("purge batch size must be positive")
+	}
+	return nil
+}
+
+// ParsePeriod parses a retention period: either Never, which is returned as zero, or a positive duration as
+// understood by time.ParseDuration, e.g. "720h"
+func ParsePeriod(s string) (time.Duration, error) {
+	if strings.EqualFold(strings.TrimSpace(s), Never) {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("retention period %q must be positive, or %q", s, Never)
+	}
+	return d, nil
+}
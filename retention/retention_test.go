@@ -0,0 +1,49 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := map[string]struct {
+		period    string
+		expected  time.Duration
+		withError bool
+	}{
+		"duration":    {period: "720h", expected: 720 * time.Hour},
+		"never":       {period: "never", expected: 0},
+		"never upper": {period: " NEVER ", expected: 0},
+		"zero":        {period: "0s", withError: true},
+		"negative":    {period: "-1m", withError: true},
+		"garbage":     {period: "a month", withError: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			d, err := ParsePeriod(test.period)
+			if test.withError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, d)
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy.Validate())
+	assert.NoError(t, Policy{Interval: time.Minute}.Validate())
+	assert.Error(t, Policy{Period: -time.Second, Interval: time.Minute}.Validate())
+	assert.Error(t, Policy{Period: time.Hour}.Validate())
+}
+
+func TestPurge_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPurge.Validate())
+	assert.NoError(t, Purge{Interval: time.Minute, BatchSize: 1}.Validate())
+	assert.Error(t, Purge{GracePeriod: -time.Second, Interval: time.Minute, BatchSize: 1}.Validate())
+	assert.Error(t, Purge{GracePeriod: time.Hour, BatchSize: 1}.Validate())
+	assert.Error(t, Purge{GracePeriod: time.Hour, Interval: time.Minute}.Validate())
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"api/config"
+	"api/cron"
+	"api/dao"
+	"api/handler"
+	"api/leader"
+	"api/logging"
+	"api/mail"
+	"api/migration"
+	"api/outbox"
+	"api/postgresql"
+	"api/suppression"
+	"api/webhook"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Server is an instance of the service, with its own dependencies: several may run side by side, e.g. in tests
+type Server struct {
+	Config config.Config
+	Log    *logging.Logger
+	Store  *dao.Store
+
+	Customers    *handler.CustomerHandler
+	Mailings     *handler.MailingHandler
+	Suppressions *handler.SuppressionHandler
+	Webhooks     *handler.WebhookHandler
+	Unsubscribe  *handler.UnsubscribeHandler
+	Admin        *handler.AdminHandler
+	Health       *handler.HealthHandler
+
+	Elector *cron.Elector
+	Jobs    *cron.Jobs
+
+	// requestLog is where the requests served are logged
+	requestLog io.Writer
+	// ginLog is the file requestLog writes to, if any
+	ginLog *os.File
+	http   *http.Server
+}
+
+// NewServer opens the logs and the database of cfg, and wires the service on top of them. Nothing is served until
+// Serve is called.
+func NewServer(cfg config.Config) (*Server, error) {
+	logger, err := logging.Open(cfg.Log.AppFile)
+	if err != nil {
+		return nil, err
+	}
+	ginLog, err := os.OpenFile(cfg.Log.GinFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migration.All()
+	if err != nil {
+		return nil, err
+	}
+	db, err := postgresql.Open(cfg.Database.DSN())
+	if err != nil {
+		return nil, err
+	}
+	mailer, err := mail.New(cfg.Mail.Config())
+	if err != nil {
+		return nil, err
+	}
+	// replicas share the cron jobs: only the one holding the lease runs them
+	holder, err := leader.NewHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newServer(cfg, logger, io.MultiWriter(ginLog, os.Stdout), dao.New(db, migrations), mailer, holder)
+	if err != nil {
+		return nil, err
+	}
+	s.ginLog = ginLog
+	return s, nil
+}
+
+// newServer wires the service on top of the given dependencies
+func newServer(cfg config.Config, logger *logging.Logger, requestLog io.Writer, store *dao.Store, mailer mail.Mailer,
+	holder string) (*Server, error) {
+	key, err := unsubscribeKey(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	signer := &suppression.Signer{Key: key}
+	elector := &cron.Elector{
+		Leases: store.Leases,
+		Log:    logger,
+		Lease:  leader.CronLease,
+		Holder: holder,
+		TTL:    leader.DefaultTTL,
+	}
+	purge := cfg.PurgePolicy()
+	jobs := cron.NewJobs(elector, store.Jobs, logger)
+	jobs.Timeout = cfg.Cron.Timeout
+	err = cron.Schedule(jobs, cron.Tasks{
+		Dispatcher: &cron.Dispatcher{
+			Outbox:    store.Outbox,
+			Mailings:  store.Mailings,
+			Log:       logger,
+			Mailer:    mailer,
+			From:      cfg.Mail.From,
+			PostSend:  cfg.Mail.PostSend,
+			BatchSize: 100,
+			Retry:     outbox.DefaultRetryPolicy,
+			// the unsubscribe links point at this very service
+			Unsubscribe:    signer,
+			UnsubscribeURL: strings.TrimSuffix(cfg.PublicURL, "/") + "/unsubscribe/",
+		},
+		Scheduled: &cron.Scheduled{Mailings: store.Mailings, Log: logger},
+		Webhooks: &cron.WebhookDispatcher{
+			Webhooks:  store.Webhooks,
+			Log:       logger,
+			Sender:    &webhook.Sender{},
+			BatchSize: 100,
+			Retry:     outbox.DefaultRetryPolicy,
+		},
+		Retention: &cron.Retention{Customers: store.Customers, Log: logger, Policy: cfg.RetentionPolicy()},
+		Purger:    &cron.Purger{Customers: store.Customers, Log: logger, Policy: purge},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Config:       cfg,
+		Log:          logger,
+		Store:        store,
+		Customers:    handler.NewCustomerHandler(store, logger),
+		Mailings:     handler.NewMailingHandler(store, logger),
+		Suppressions: handler.NewSuppressionHandler(store, logger),
+		Webhooks:     handler.NewWebhookHandler(store, logger),
+		Unsubscribe:  handler.NewUnsubscribeHandler(store, signer, logger),
+		Admin:        handler.NewAdminHandler(store, logger, purge, holder, jobs),
+		Health:       &handler.HealthHandler{},
+		Elector:      elector,
+		Jobs:         jobs,
+		requestLog:   requestLog,
+	}
+	s.http = &http.Server{Addr: cfg.Server.Addr, Handler: s.Router()}
+	return s, nil
+}
+
+// Serve checks that the schema of the database is the one of this release, starts the cron jobs and serves the API
+// until Shutdown is called. The schema is brought up to date beforehand, by the migrate command.
+func (s *Server) Serve() error {
+	if err := s.Store.Migrations.Check(); err != nil {
+		return err
+	}
+	if err := s.Jobs.Start(); err != nil {
+		return err
+	}
+	if err := s.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the service gracefully. Readiness fails first, and requests are still served for a drain delay. The
+// server then stops accepting connections and waits for the requests in flight, and the cron jobs are stopped and
+// waited for, all within the shutdown timeout. The leadership is handed over, the logs are closed, and the database is
+// closed last.
+func (s *Server) Shutdown() {
+	s.Health.Drain()
+	time.Sleep(s.Config.Server.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Server.ShutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(ctx); err != nil {
+		s.Log.Error.Printf("SHUTDOWN: server: %s", err.Error())
+	}
+	if err := s.Jobs.Stop(ctx); err != nil {
+		s.Log.Error.Printf("SHUTDOWN: cron jobs: %s", err.Error())
+	}
+	if err := s.Elector.Resign(); err != nil {
+		s.Log.Error.Printf("SHUTDOWN: leadership: %s", err.Error())
+	}
+	s.Log.Info.Printf("SHUTDOWN: done")
+
+	if err := s.Log.Close(); err != nil {
+		log.Printf("SHUTDOWN: logs: %s", err.Error())
+	}
+	if s.ginLog != nil {
+		if err := s.ginLog.Close(); err != nil {
+			log.Printf("SHUTDOWN: logs: %s", err.Error())
+		}
+	}
+	if err := s.Store.Customers.Close(); err != nil {
+		log.Printf("SHUTDOWN: database: %s", err.Error())
+	}
+}
+
+// errNoUnsubscribeKey is returned when the service is started without an unsubscribe key, out of development
+var errNoUnsubscribeKey = errors.New("UNSUBSCRIBE_KEY, or UNSUBSCRIBE_KEY_FILE, is not set: it is only optional " +
+	"in development")
+
+// unsubscribeKey returns the key unsubscribe links are signed with. In development, and only there, a random key is
+// used without one, and the links sent stop working once the service restarts.
+func unsubscribeKey(cfg config.Config, logger *logging.Logger) ([]byte, error) {
+	if cfg.UnsubscribeKey != "" {
+		return []byte(cfg.UnsubscribeKey), nil
+	}
+	if !cfg.Development {
+		return nil, errNoUnsubscribeKey
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	logger.Warn.Printf("UNSUBSCRIBE_KEY is not set, unsubscribe links will not survive a restart")
+	return random, nil
+}
@@ -0,0 +1,40 @@
+package suppression
+
+import (
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+const (
+	// ReasonUnsubscribed is the reason of the suppressions added through an unsubscribe link
+	ReasonUnsubscribed = "unsubscribed"
+	// ReasonManual is the reason of the suppressions added by an administrator without telling why
+	ReasonManual = "manual"
+	// ReasonBounced is the reason of the suppressions added when the mailer rejected an address for good
+	ReasonBounced = "bounced"
+)
+
+// Suppression keeps an email address from being mailed or registered as a customer again
+type Suppression struct {
+	// Email is normalized, see Normalize
+	Email  string `json:"email" gorm:"primaryKey"`
+	Reason string `json:"reason"`
+	// MailingID is the mailing whose unsubscribe link was followed, or whose message bounced, if any
+	MailingID int64     `json:"mailing_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Suppression) Validate() error {
+	return validation.Errors{
+		"email":  validation.Validate(s.Email, validation.Required, is.Email, validation.Length(0, 50)),
+		"reason": validation.Validate(s.Reason, validation.Length(0, 200)),
+	}.Filter()
+}
+
+// Normalize returns the form email addresses are suppressed and looked up by: trimmed and lower cased
+func Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
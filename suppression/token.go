@@ -0,0 +1,57 @@
+package suppression
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidToken is returned when an unsubscribe token is malformed or its signature does not match
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// Signer issues and checks the tokens of unsubscribe links. A token embeds a customer and the mailing it was sent,
+// signed with HMAC-SHA256 so that nobody can unsubscribe somebody else.
+type Signer struct {
+	Key []byte
+}
+
+// Token returns the unsubscribe token of a customer for a mailing
+func (s *Signer) Token(customerID uint, mailingID int64) string {
+	payload := []byte(fmt.Sprintf("%d.%d", customerID, mailingID))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Parse checks the signature of a token and returns the customer and mailing it embeds.
+// It returns ErrInvalidToken otherwise.
+func (s *Signer) Parse(token string) (uint, int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.sign(payload)) {
+		return 0, 0, ErrInvalidToken
+	}
+
+	var (
+		customerID uint
+		mailingID  int64
+	)
+	if _, err := fmt.Sscanf(string(payload), "%d.%d", &customerID, &mailingID); err != nil {
+		return 0, 0, ErrInvalidToken
+	}
+	return customerID, mailingID, nil
+}
+
+func (s *Signer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
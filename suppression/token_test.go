@@ -0,0 +1,65 @@
+package suppression
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	signer := &Signer{Key: []byte("secret")}
+	token := signer.Token(42, 7)
+
+	tests := map[string]struct {
+		signer     *Signer
+		token      string
+		customerID uint
+		mailingID  int64
+		withError  error
+	}{
+		"valid": {
+			signer:     signer,
+			token:      token,
+			customerID: 42,
+			mailingID:  7,
+		},
+		"other key": {
+			signer:    &Signer{Key: []byte("other")},
+			token:     token,
+			withError: ErrInvalidToken,
+		},
+		"tampered payload": {
+			signer:    signer,
+			token:     signer.Token(43, 7)[:strings.Index(token, ".")] + token[strings.Index(token, "."):],
+			withError: ErrInvalidToken,
+		},
+		"no signature": {
+			signer:    signer,
+			token:     token[:strings.Index(token, ".")],
+			withError: ErrInvalidToken,
+		},
+		"malformed": {
+			signer:    signer,
+			token:     "%%.%%",
+			withError: ErrInvalidToken,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			customerID, mailingID, err := test.signer.Parse(test.token)
+			if test.withError != nil {
+				assert.ErrorIs(t, err, test.withError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.customerID, customerID)
+			assert.Equal(t, test.mailingID, mailingID)
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "hello@example.com", Normalize("  Hello@Example.COM "))
+}
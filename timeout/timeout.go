@@ -0,0 +1,51 @@
+package timeout
+
+import (
+	"api/problem"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrDeadline is reported, with a 504, when a request fails once the timeout of its route is over
+	ErrDeadline = errors.New("request timed out")
+	// ErrCanceled is reported, with a 503, when a request fails once cancelled before its timeout, e.g. as its client
+	// went away
+	ErrCanceled = errors.New("request cancelled")
+)
+
+// Route is the key of a route among the timeouts given to Middleware, e.g. Route(http.MethodPost, "/api/clients/batch")
+func Route(method, path string) string {
+	return method + " " + path
+}
+
+// Middleware bounds the context of every request by the timeout of its route in routes, or by fallback. A request
+// failing once its context is done is reported as timed out, or cancelled, unless a response was written already.
+// Handlers must hand the context of the request down for their work to be cancelled.
+func Middleware(fallback time.Duration, routes map[string]time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		d, ok := routes[Route(ctx.Request.Method, ctx.FullPath())]
+		if !ok {
+			d = fallback
+		}
+		c, cancel := context.WithTimeout(ctx.Request.Context(), d)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(c)
+
+		ctx.Next()
+
+		if ctx.Writer.Written() || len(ctx.Errors) == 0 {
+			return
+		}
+		switch {
+		case errors.Is(c.Err(), context.DeadlineExceeded):
+			problem.AbortWithStatus(ctx, http.StatusGatewayTimeout, ErrDeadline)
+		case errors.Is(c.Err(), context.Canceled):
+			problem.AbortWithStatus(ctx, http.StatusServiceUnavailable, ErrCanceled)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package timeout
+
+import (
+	"api/problem"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	// wait fails once the context of the request is done, as a database call would
+	wait := func(ctx *gin.Context) {
+		<-ctx.Request.Context().Done()
+		problem.Abort(ctx, ctx.Request.Context().Err())
+	}
+
+	tests := map[string]struct {
+		path         string
+		handler      gin.HandlerFunc
+		cancel       bool
+		expectedCode int
+		expectedType string
+	}{
+		"200 in time": {
+			path:         "/fast",
+			handler:      func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") },
+			expectedCode: http.StatusOK,
+		},
+		"504 once the timeout is over": {
+			path:         "/fast",
+			handler:      wait,
+			expectedCode: http.StatusGatewayTimeout,
+			expectedType: problem.TypeTimeout,
+		},
+		"504 once the timeout of the route is over": {
+			path: "/slow",
+			handler: func(ctx *gin.Context) {
+				deadline, _ := ctx.Request.Context().Deadline()
+				if time.Until(deadline) < 100*time.Millisecond {
+					problem.Abort(ctx, errors.New("route timeout not applied"))
+					return
+				}
+				wait(ctx)
+			},
+			expectedCode: http.StatusGatewayTimeout,
+			expectedType: problem.TypeTimeout,
+		},
+		"503 once cancelled": {
+			path:         "/fast",
+			handler:      wait,
+			cancel:       true,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedType: problem.TypeUnavailable,
+		},
+		"400 unrelated to the timeout": {
+			path: "/fast",
+			handler: func(ctx *gin.Context) {
+				problem.AbortWithStatus(ctx, http.StatusBadRequest, errors.New("bad request"))
+			},
+			expectedCode: http.StatusBadRequest,
+			expectedType: problem.TypeBadRequest,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(problem.Middleware())
+			r.Use(Middleware(10*time.Millisecond, map[string]time.Duration{
+				Route(http.MethodGet, "/slow"): 200 * time.Millisecond,
+			}))
+			r.GET(test.path, test.handler)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, test.path, nil)
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, test.expectedCode, w.Code)
+			if test.expectedType != "" {
+				assert.Contains(t, w.Body.String(), test.expectedType)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	// examples taken from RFC 7396, appendix A
+	tests := map[string]struct {
+		doc, patch, expected string
+	}{
+		"replace":        {doc: `{"a":"b"}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		"add":            {doc: `{"a":"b"}`, patch: `{"b":"c"}`, expected: `{"a":"b","b":"c"}`},
+		"remove":         {doc: `{"a":"b"}`, patch: `{"a":null}`, expected: `{}`},
+		"remove one":     {doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, expected: `{"b":"c"}`},
+		"array":          {doc: `{"a":["b"]}`, patch: `{"a":"c"}`, expected: `{"a":"c"}`},
+		"to array":       {doc: `{"a":"c"}`, patch: `{"a":["b"]}`, expected: `{"a":["b"]}`},
+		"nested":         {doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, expected: `{"a":{"b":"d"}}`},
+		"not an object":  {doc: `["a","b"]`, patch: `["c","d"]`, expected: `["c","d"]`},
+		"object to obj":  {doc: `["a"]`, patch: `{"a":"b"}`, expected: `{"a":"b"}`},
+		"nested nulls":   {doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, expected: `{"a":{"bb":{}}}`},
+		"keep unchanged": {doc: `{"e":null}`, patch: `{"a":1}`, expected: `{"a":1,"e":null}`},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := MergePatch([]byte(test.doc), []byte(test.patch))
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(got))
+		})
+	}
+
+	t.Run("invalid patch", func(t *testing.T) {
+		_, err := MergePatch([]byte(`{}`), []byte(`{`))
+		require.Error(t, err)
+	})
+}
@@ -10,14 +10,15 @@ import (
 
 const XRequestID = "X-RequestID"
 
-func XRequestIDMiddleware() gin.HandlerFunc {
+// XRequestIDMiddleware makes sure every request has an XRequestID header, generating one unless given, and logs it
+func XRequestIDMiddleware(log *logging.Logger) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		id := ctx.Request.Header.Get(XRequestID)
 		if id == "" {
 			var err error
 			id, err = tools.GenerateUUID4()
 			if err != nil {
-				logging.ErrorLogger.Printf("cannot generate UUID4: %s", err.Error())
+				log.Error.Printf("cannot generate UUID4: %s", err.Error())
 				return
 			}
 
@@ -25,6 +26,6 @@ func XRequestIDMiddleware() gin.HandlerFunc {
 		}
 
 		ts := time.Now().Format(time.UnixDate)
-		logging.InfoLogger.Printf("%s - %s\n", ts, id)
+		log.Info.Printf("%s - %s\n", ts, id)
 	}
 }
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"api/tracing"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	// HeaderSignature holds the time the delivery was signed at and its signature, "t=<unix time>,v1=<hex>"
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderEvent holds the type of the event
+	HeaderEvent = "X-Webhook-Event"
+	// HeaderDelivery holds the id of the delivery, the same on every attempt
+	HeaderDelivery = "X-Webhook-Delivery"
+)
+
+// Sender posts deliveries to their subscribers
+type Sender struct {
+	// Timeout bounds every attempt, from connecting to reading the response. Defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// Sign returns the signature of a body sent at the given time: the hex encoded HMAC-SHA256, keyed by secret, of the
+// Unix time, a dot and the body
+func Sign(secret string, at time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(at.Unix(), 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts the event of a delivery to the URL of its subscription, signed with its secret, giving up once ctx is
+// done. It returns the status the subscriber answered with, zero if it did not, and an error unless it is a 2xx one.
+func (s *Sender) Send(ctx context.Context, sub *Subscription, d *Delivery) (int, error) {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderSignature, fmt.Sprintf("t=%d,v1=%s", now.Unix(), Sign(sub.Secret, now, body)))
+	req.Header.Set(HeaderEvent, d.Event.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(d.ID), 10))
+	if d.RequestID != "" {
+		req.Header.Set(tracing.XRequestID, d.RequestID)
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	res, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// the body is drained so that the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook: %s answered %s", sub.URL, res.Status)
+	}
+	return res.StatusCode, nil
+}
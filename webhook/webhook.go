@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"api/tools"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
+)
+
+// Types of an Event
+const (
+	// EventCustomerCreated is fired once a customer was created
+	EventCustomerCreated = "customer.created"
+	// EventCustomerDeleted is fired once a customer was deleted, by the API or after its message was sent
+	EventCustomerDeleted = "customer.deleted"
+	// EventCustomerExpired is fired once a customer was deleted for being too old
+	EventCustomerExpired = "customer.expired"
+	// EventMailingSent is fired once a mailing has no pending message left
+	EventMailingSent = "mailing.sent"
+)
+
+// Events lists every type of Event
+var Events = []string{EventCustomerCreated, EventCustomerDeleted, EventCustomerExpired, EventMailingSent}
+
+// Statuses of a Delivery
+const (
+	// StatusPending deliveries are waiting for the dispatcher, or for their next attempt
+	StatusPending = "pending"
+	// StatusDelivered deliveries were answered with a 2xx status
+	StatusDelivered = "delivered"
+	// StatusFailed deliveries failed on every attempt
+	StatusFailed = "failed"
+)
+
+// Statuses lists every status of a Delivery
+var Statuses = []string{StatusPending, StatusDelivered, StatusFailed}
+
+// ClaimLease is how long a delivery claimed by a dispatcher is kept from the others while it is being sent, well
+// over the timeout of a Sender. A dispatcher dying meanwhile leaves the delivery to be sent again once the lease is
+// over.
+const ClaimLease = time.Minute
+
+// MinSecretLength is the length of the shortest secret a Subscription may be signed with
+const MinSecretLength = 16
+
+type (
+	// Subscription asks for the events of the given types to be posted to URL, signed with Secret
+	Subscription struct {
+		ID     uint     `json:"id" gorm:"primarykey"`
+		URL    string   `json:"url"`
+		Events []string `json:"events" gorm:"serializer:json"`
+		// Secret is never shown once the subscription was created
+		Secret    string    `json:"-"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	// Event is the body posted to the subscribers of its type
+	Event struct {
+		ID        string    `json:"id"`
+		Type      string    `json:"type"`
+		CreatedAt time.Time `json:"created_at"`
+		// Data is the customer or mailing the event is about, as the API shows it
+		Data json.RawMessage `json:"data"`
+	}
+
+	// Delivery is an Event to be posted to one Subscription. Deliveries are kept once delivered or given up on: they
+	// are the log of what every subscriber got.
+	Delivery struct {
+		ID             uint   `json:"id" gorm:"primarykey"`
+		SubscriptionID uint   `json:"subscription_id" gorm:"index"`
+		EventType      string `json:"event_type"`
+		Event          Event  `json:"event" gorm:"serializer:json"`
+		// RequestID is the X-RequestID of the request the event originates from, forwarded to the subscriber
+		RequestID string `json:"request_id,omitempty"`
+		Status    string `json:"status" gorm:"index"`
+		// Attempts counts the attempts to deliver the event
+		Attempts int `json:"attempts"`
+		// ResponseStatus is the HTTP status the subscriber answered the last attempt with, if it answered
+		ResponseStatus int    `json:"response_status,omitempty"`
+		Error          string `json:"error,omitempty"`
+		// NextAttemptAt is when a failed delivery may be attempted again, nil if it never failed
+		NextAttemptAt *time.Time `json:"next_attempt_at,omitempty" gorm:"index"`
+		DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+	}
+)
+
+func (Subscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+func (s *Subscription) Validate() error {
+	return validation.Errors{
+		"url":    validation.Validate(s.URL, validation.Required, is.URL, validation.Length(0, 2000), validation.By(web)),
+		"events": validation.Validate(s.Events, validation.Required, validation.Each(validation.In(types()...))),
+		"secret": validation.Validate(s.Secret, validation.Required, validation.Length(MinSecretLength, 200)),
+	}.Filter()
+}
+
+// Wants tells whether the subscription asked for the events of the given type
+func (s *Subscription) Wants(eventType string) bool {
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// NewEvent builds an event of the given type about data, marshalled as JSON
+func NewEvent(eventType string, data interface{}) (Event, error) {
+	id, err := tools.GenerateUUID4()
+	if err != nil {
+		return Event{}, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: raw}, nil
+}
+
+// web checks that a URL is an http or https one
+func web(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+func types() []interface{} {
+	ts := make([]interface{}, 0, len(Events))
+	for _, e := range Events {
+		ts = append(ts, e)
+	}
+	return ts
+}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"api/tracing"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscription_Validate(t *testing.T) {
+	tests := map[string]struct {
+		subscription Subscription
+		invalid      string
+	}{
+		"valid": {
+			subscription: Subscription{URL: "https://example.com/hook", Events: []string{EventCustomerCreated},
+				Secret: "0123456789abcdef"},
+		},
+		"not a web URL": {
+			subscription: Subscription{URL: "ftp://example.com/hook", Events: []string{EventCustomerCreated},
+				Secret: "0123456789abcdef"},
+			invalid: "url",
+		},
+		"unknown event": {
+			subscription: Subscription{URL: "https://example.com/hook", Events: []string{"customer.updated"},
+				Secret: "0123456789abcdef"},
+			invalid: "events",
+		},
+		"no events": {
+			subscription: Subscription{URL: "https://example.com/hook", Secret: "0123456789abcdef"},
+			invalid:      "events",
+		},
+		"short secret": {
+			subscription: Subscription{URL: "https://example.com/hook", Events: []string{EventMailingSent},
+				Secret: "secret"},
+			invalid: "secret",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := test.subscription.Validate()
+			if test.invalid == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.invalid)
+		})
+	}
+}
+
+func TestSender_Send(t *testing.T) {
+	event, err := NewEvent(EventCustomerCreated, map[string]interface{}{"id": 1})
+	require.NoError(t, err)
+	delivery := &Delivery{ID: 3, EventType: EventCustomerCreated, Event: event, RequestID: "req"}
+
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	sender := &Sender{Timeout: time.Second}
+
+	t.Run("delivered", func(t *testing.T) {
+		sub := &Subscription{URL: server.URL + "/hook", Secret: "0123456789abcdef"}
+		status, err := sender.Send(context.Background(), sub, delivery)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+
+		assert.Equal(t, EventCustomerCreated, received.Header.Get(HeaderEvent))
+		assert.Equal(t, "3", received.Header.Get(HeaderDelivery))
+		assert.Equal(t, "req", received.Header.Get(tracing.XRequestID))
+
+		var ts int64
+		var signature string
+		_, err = fmt.Sscanf(received.Header.Get(HeaderSignature), "t=%d,v1=%s", &ts, &signature)
+		require.NoError(t, err)
+		assert.Equal(t, Sign(sub.Secret, time.Unix(ts, 0), body), signature)
+
+		var sent Event
+		require.NoError(t, json.Unmarshal(body, &sent))
+		assert.Equal(t, event.ID, sent.ID)
+		assert.JSONEq(t, `{"id": 1}`, string(sent.Data))
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		status, err := sender.Send(context.Background(),
+			&Subscription{URL: server.URL + "/broken", Secret: "0123456789abcdef"}, delivery)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusInternalServerError, status)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		status, err := sender.Send(context.Background(),
+			&Subscription{URL: "http://127.0.0.1:1/hook", Secret: "0123456789abcdef"}, delivery)
+		assert.Error(t, err)
+		assert.Zero(t, status)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		status, err := sender.Send(ctx, &Subscription{URL: server.URL + "/hook", Secret: "0123456789abcdef"}, delivery)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Zero(t, status)
+	})
+}
+
+func TestSign(t *testing.T) {
+	at := time.Unix(1677664800, 0)
+	signature := Sign("secret", at, []byte(`{}`))
+	assert.Len(t, signature, 64)
+	assert.Equal(t, signature, Sign("secret", at, []byte(`{}`)))
+	assert.NotEqual(t, signature, Sign("other", at, []byte(`{}`)))
+	assert.NotEqual(t, signature, Sign("secret", at.Add(time.Second), []byte(`{}`)))
+	assert.NotEqual(t, signature, Sign("secret", at, []byte(`{"id":1}`)))
+}